@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
 	"sync"
 
 	"github.com/krisna112/scriptxray/go_panel/pkg/bot"
@@ -17,6 +18,21 @@ func main() {
 	modeMenu := flag.Bool("menu", false, "Run CLI Menu")
 	modeXP := flag.Bool("xp", false, "Run Expiry Check")
 	modeQuota := flag.Bool("quota", false, "Run Quota Check")
+	modeIPLimit := flag.Bool("ip-limit", false, "Run Device (IP) Limit Check")
+	modeDBCheck := flag.Bool("db-check", false, "Validate clients.db and inbounds.db")
+	dbFix := flag.Bool("fix", false, "With -db-check: apply every repair without asking")
+	auditExport := flag.String("audit-export", "", "Write the audit log to stdout (csv|json)")
+	clientsExport := flag.String("export", "", "Write every client to stdout (csv|json)")
+	clientsImport := flag.String("import", "", "Import clients from a csv/json export file")
+	panelImport := flag.String("import-panel", "", "Import inbounds and clients from a 3x-ui/x-ui or Marzban database or Marzban export")
+	adoptConfig := flag.String("adopt", "", "Adopt the inbounds and clients of an existing Xray config.json")
+	importConflict := flag.String("conflict", core.ConflictSkip, "With -import/-import-panel/-adopt: existing username (skip|overwrite|rename)")
+	importKeepUUID := flag.Bool("keep-uuid", true, "With -import/-import-panel/-adopt: keep the UUIDs from the file")
+	importDryRun := flag.Bool("dry-run", false, "With -import/-import-panel/-adopt: only print what would happen")
+	backupOut := flag.String("backup", "", "Write a full backup archive to this file (- = stdout)")
+	restoreIn := flag.String("restore", "", "Restore a backup archive (shows the changes first)")
+	restoreYes := flag.Bool("yes", false, "With -restore: do not ask for confirmation")
+	modeAutoBackup := flag.Bool("auto-backup", false, "Run the scheduled backup (retention + S3 upload)")
 
 	// Server Flags
 	port := flag.Int("port", 5000, "Web Server Port")
@@ -26,11 +42,20 @@ func main() {
 	dbClients := flag.String("db_clients", "/etc/xray/clients.db", "Path to clients.db")
 	dbInbounds := flag.String("db_inbounds", "/etc/xray/inbounds.db", "Path to inbounds.db")
 	configXray := flag.String("config_xray", "/usr/local/etc/xray/config.json", "Path to xray config.json")
+	storeKind := flag.String("store", "bolt", "Client store backend (bolt|file)")
+	dbStore := flag.String("db_store", "/etc/xray/panel.db", "Path to embedded bolt database")
+	dbUsage := flag.String("db_usage", core.DB_USAGE, "Path to usage history database")
+	xrayAPI := flag.String("xray_api", core.XRAY_API, "Address of the Xray gRPC API (HandlerService, StatsService)")
 
 	flag.Parse()
 
 	// Initialize Core
 	core.SetPaths(*dbClients, *dbInbounds, *configXray)
+	core.DB_USAGE = *dbUsage
+	core.XRAY_API = *xrayAPI
+	if err := core.OpenStore(*storeKind, *dbStore); err != nil {
+		log.Fatalf("Store Error: %v", err)
+	}
 
 	// Routing
 	if *modeMenu {
@@ -39,12 +64,94 @@ func main() {
 	}
 	if *modeXP {
 		tasks.RunExpiryCheck()
+		tasks.RunTrashPurge()
+		tasks.RunUsagePrune()
 		return
 	}
 	if *modeQuota {
 		tasks.RunQuotaCheck()
 		return
 	}
+	if *modeIPLimit {
+		tasks.RunIPLimitCheck()
+		return
+	}
+	if *modeAutoBackup {
+		tasks.RunAutoBackup()
+		return
+	}
+	if *auditExport != "" {
+		if err := cli.ExportAudit(*auditExport); err != nil {
+			log.Fatalf("Audit export failed: %v", err)
+		}
+		return
+	}
+	if *clientsExport != "" {
+		if err := cli.ExportClients(*clientsExport); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		return
+	}
+	if *clientsImport != "" {
+		ok, err := cli.ImportClients(*clientsImport, core.ImportOptions{
+			KeepUUID: *importKeepUUID,
+			Conflict: *importConflict,
+			DryRun:   *importDryRun,
+		})
+		if err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	if *backupOut != "" {
+		if err := cli.WriteBackup(*backupOut); err != nil {
+			log.Fatalf("Backup failed: %v", err)
+		}
+		return
+	}
+	if *restoreIn != "" {
+		if err := cli.RestoreBackup(*restoreIn, *restoreYes); err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		return
+	}
+	if *panelImport != "" {
+		ok, err := cli.ImportForeignPanel(*panelImport, core.ImportOptions{
+			KeepUUID: *importKeepUUID,
+			Conflict: *importConflict,
+			DryRun:   *importDryRun,
+		})
+		if err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	if *adoptConfig != "" {
+		ok, err := cli.AdoptXrayConfig(*adoptConfig, core.ImportOptions{
+			KeepUUID: *importKeepUUID,
+			Conflict: *importConflict,
+			DryRun:   *importDryRun,
+		})
+		if err != nil {
+			log.Fatalf("Adopt failed: %v", err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	if *modeDBCheck {
+		if !cli.RunDBCheck(*dbFix) {
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Default: Run Server & Bot
 	var wg sync.WaitGroup
@@ -20,11 +20,16 @@ const (
 	WaitDays
 	WaitUUIDOption
 	WaitUUIDManual
+	WaitPlan
+	WaitPlanUsername
+	WaitPlanChoice
+	WaitFind
 )
 
 type UserSession struct {
 	State    BotState
 	TempUser core.Client
+	Upgrade  bool // WaitPlanUsername/WaitPlanChoice: upgrade instead of renew
 }
 
 type Bot struct {
@@ -87,6 +92,17 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 			session.State = Idle
 			b.sendMessage(msg.Chat.ID, "Cancelled.")
 			b.sendMenu(msg.Chat.ID)
+		case "find":
+			if q := msg.CommandArguments(); q != "" {
+				b.sendFind(msg.Chat.ID, q)
+			} else {
+				session.State = WaitFind
+				b.sendMessage(msg.Chat.ID, findHelp)
+			}
+		case "tags":
+			b.setTags(msg.Chat.ID, msg.CommandArguments())
+		case "note":
+			b.setNote(msg.Chat.ID, msg.CommandArguments())
 		}
 		return
 	}
@@ -94,11 +110,21 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 	// State Machine
 	switch session.State {
 	case WaitUsername:
-		if !strings.EqualFold(msg.Text, "") { // Validate alphanumeric if needed
-			session.TempUser.Username = msg.Text
-			session.State = WaitQuota
-			b.sendMessage(msg.Chat.ID, "✅ Enter Quota (GB):")
+		username := strings.TrimSpace(msg.Text)
+		if err := core.ValidateUsername(username); err != nil {
+			b.sendMessage(msg.Chat.ID, "❌ "+err.Error()+"\nEnter another username or /cancel:")
+			return
+		}
+		session.TempUser = core.Client{Username: username}
+		if kb, ok := tgBotKeyboardPlans(true); ok {
+			session.State = WaitPlan
+			m := tgbotapi.NewMessage(msg.Chat.ID, "📦 Choose Plan:")
+			m.ReplyMarkup = kb
+			b.API.Send(m)
+			return
 		}
+		session.State = WaitQuota
+		b.sendMessage(msg.Chat.ID, "✅ Enter Quota (GB):")
 	case WaitQuota:
 		q, err := strconv.ParseFloat(msg.Text, 64)
 		if err != nil {
@@ -115,17 +141,35 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 			return
 		}
 		session.TempUser.Expiry = time.Now().Add(time.Duration(d) * 24 * time.Hour)
-
-		// Ask for UUID
-		kb := tgBotKeyboardUUID()
-		msg := tgbotapi.NewMessage(msg.Chat.ID, "🔑 Choose UUID Mode:")
-		msg.ReplyMarkup = kb
-		b.API.Send(msg)
-		session.State = WaitUUIDOption
+		b.askUUIDMode(msg.Chat.ID, session)
 
 	case WaitUUIDManual:
-		session.TempUser.UUID = msg.Text
+		uuid := core.NormalizeUUID(msg.Text)
+		if err := core.ValidateUUID(uuid, ""); err != nil {
+			b.sendMessage(msg.Chat.ID, "❌ "+err.Error()+"\nEnter another UUID or /cancel:")
+			return
+		}
+		session.TempUser.UUID = uuid
 		b.finalizeCreateUser(msg.Chat.ID, session)
+
+	case WaitFind:
+		session.State = Idle
+		b.sendFind(msg.Chat.ID, msg.Text)
+
+	case WaitPlanUsername:
+		username := strings.TrimSpace(msg.Text)
+		kb, ok := tgBotKeyboardPlans(false)
+		if !ok {
+			b.sendMessage(msg.Chat.ID, "❌ No plans defined.")
+			session.State = Idle
+			b.sendMenu(msg.Chat.ID)
+			return
+		}
+		session.TempUser = core.Client{Username: username}
+		session.State = WaitPlanChoice
+		m := tgbotapi.NewMessage(msg.Chat.ID, "📦 Choose Plan:")
+		m.ReplyMarkup = kb
+		b.API.Send(m)
 	}
 }
 
@@ -147,6 +191,23 @@ func (b *Bot) handleCallback(cq *tgbotapi.CallbackQuery) {
 		if data == "create" {
 			session.State = WaitUsername
 			b.sendMessage(chatID, "🆕 Enter Username:")
+		} else if data == "renew" || data == "upgrade" {
+			session.Upgrade = data == "upgrade"
+			session.State = WaitPlanUsername
+			b.sendMessage(chatID, "👤 Enter Username:")
+		} else if data == "trash" {
+			b.sendTrash(chatID)
+		} else if data == "find" {
+			session.State = WaitFind
+			b.sendMessage(chatID, findHelp)
+		} else if id, ok := strings.CutPrefix(data, "restore:"); ok {
+			if err := core.RestoreClient(b.actor(), id); err != nil {
+				b.sendMessage(chatID, "❌ Error: "+err.Error())
+			} else {
+				b.sendMessage(chatID, "✅ User restored")
+				b.applyConfig(chatID)
+			}
+			b.sendMenu(chatID)
 		} else if data == "status" {
 			// FIXED: GetActiveInbound returns 3 values (tag, port, error)
 			inb, port, err := core.GetActiveInbound()
@@ -156,6 +217,34 @@ func (b *Bot) handleCallback(cq *tgbotapi.CallbackQuery) {
 				b.sendMessage(chatID, fmt.Sprintf("System Status:\nInbound: %s\nPort: %d", inb, port))
 			}
 		}
+	case WaitPlan:
+		if name, ok := strings.CutPrefix(data, "plan:"); ok {
+			session.TempUser.Plan = name
+			b.askUUIDMode(chatID, session)
+		} else if data == "custom" {
+			session.State = WaitQuota
+			b.sendMessage(chatID, "✅ Enter Quota (GB):")
+		}
+	case WaitPlanChoice:
+		name, ok := strings.CutPrefix(data, "plan:")
+		if !ok {
+			return
+		}
+		username := session.TempUser.Username
+		var err error
+		if session.Upgrade {
+			err = core.UpgradeToPlan(b.actor(), username, name)
+		} else {
+			err = core.RenewFromPlan(b.actor(), username, name)
+		}
+		if err != nil {
+			b.sendMessage(chatID, "❌ Error: "+err.Error())
+		} else {
+			b.sendMessage(chatID, fmt.Sprintf("✅ %s is now on plan %s", username, name))
+			b.applyConfig(chatID)
+		}
+		session.State = Idle
+		b.sendMenu(chatID)
 	case WaitUUIDOption:
 		if data == "auto" {
 			session.TempUser.UUID = core.GenerateUUID()
@@ -167,30 +256,174 @@ func (b *Bot) handleCallback(cq *tgbotapi.CallbackQuery) {
 	}
 }
 
+const findHelp = "🔎 Enter search (username, notes or tag), with optional filters:\n" +
+	"tag:vip status:active inbound:vless-ws expires:7 usage:80 usage:<10 usage:0 (never used)"
+
+// Telegram membatasi panjang pesan (4096), hasil dan notes dipotong
+const (
+	findMaxResults = 30
+	findNoteLen    = 60
+)
+
+// sendFind lists the clients matching a search
+func (b *Bot) sendFind(chatID int64, q string) {
+	filter, err := core.ParseClientFilter(q)
+	if err != nil {
+		b.sendMessage(chatID, "❌ "+err.Error())
+		return
+	}
+	clients, err := core.LoadClients()
+	if err != nil {
+		b.sendMessage(chatID, "❌ Error: "+err.Error())
+		return
+	}
+	found := core.FilterClients(clients, filter)
+	if len(found) == 0 {
+		b.sendMessage(chatID, "🔎 No users match")
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🔎 %d of %d users\n", len(found), len(clients))
+	for i, c := range found {
+		if i == findMaxResults {
+			fmt.Fprintf(&sb, "… and %d more, narrow the search", len(found)-findMaxResults)
+			break
+		}
+		usage := core.FormatBytes(c.Used)
+		if c.Quota > 0 {
+			usage += fmt.Sprintf(" / %.0f GB (%d%%)", c.Quota, c.UsagePercent())
+		}
+		fmt.Fprintf(&sb, "\n• %s — %s — %s — exp %s", c.Username, c.Status, usage, c.Expiry.Format("2006-01-02"))
+		if len(c.Tags) > 0 {
+			sb.WriteString(" #" + strings.Join(c.Tags, " #"))
+		}
+		if c.Notes != "" {
+			note := []rune(strings.ReplaceAll(c.Notes, "\n", " "))
+			if len(note) > findNoteLen {
+				note = append(note[:findNoteLen], '…')
+			}
+			sb.WriteString("\n   📝 " + string(note))
+		}
+	}
+	b.sendMessage(chatID, sb.String())
+}
+
+// setTags handles "/tags <username> <tag,tag>"; "-" removes every tag
+func (b *Bot) setTags(chatID int64, args string) {
+	username, list, _ := strings.Cut(strings.TrimSpace(args), " ")
+	list = strings.TrimSpace(list)
+	if username == "" || list == "" {
+		b.sendMessage(chatID, "Usage: /tags <username> <tag,tag> (- = none)")
+		return
+	}
+	var tags []string
+	var err error
+	if list != "-" {
+		tags, err = core.ParseTags(list)
+	}
+	if err == nil {
+		err = core.SetClientTags(b.actor(), username, tags)
+	}
+	if err != nil {
+		b.sendMessage(chatID, "❌ Error: "+err.Error())
+		return
+	}
+	b.sendMessage(chatID, fmt.Sprintf("✅ Tags of %s: %s", username, strings.Join(tags, ", ")))
+}
+
+// setNote handles "/note <username> <text>"; "-" removes the note
+func (b *Bot) setNote(chatID int64, args string) {
+	username, note, _ := strings.Cut(strings.TrimSpace(args), " ")
+	note = strings.TrimSpace(note)
+	if username == "" || note == "" {
+		b.sendMessage(chatID, "Usage: /note <username> <text> (- = none)")
+		return
+	}
+	if note == "-" {
+		note = ""
+	}
+	if err := core.SetClientNotes(b.actor(), username, note); err != nil {
+		b.sendMessage(chatID, "❌ Error: "+err.Error())
+		return
+	}
+	b.sendMessage(chatID, "✅ Note of "+username+" saved")
+}
+
+// sendTrash lists the recycle bin with one restore button per entry
+func (b *Bot) sendTrash(chatID int64) {
+	entries, err := core.LoadTrash()
+	if err != nil {
+		b.sendMessage(chatID, "❌ Error: "+err.Error())
+		return
+	}
+	if len(entries) == 0 {
+		b.sendMessage(chatID, "🗑 Trash is empty")
+		return
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		label := fmt.Sprintf("♻️ %s (%s)", e.Client.Username, e.DeletedAt.Format("01-02 15:04"))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, "restore:"+e.ID),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Back", "back"),
+	))
+	msg := tgbotapi.NewMessage(chatID, "🗑 Deleted users, tap to restore:")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.API.Send(msg)
+}
+
+func (b *Bot) askUUIDMode(chatID int64, session *UserSession) {
+	msg := tgbotapi.NewMessage(chatID, "🔑 Choose UUID Mode:")
+	msg.ReplyMarkup = tgBotKeyboardUUID()
+	b.API.Send(msg)
+	session.State = WaitUUIDOption
+}
+
 func (b *Bot) finalizeCreateUser(chatID int64, session *UserSession) {
 	// Finish
-	// FIXED: GetActiveInbound returns 3 values
-	proto, _, err := core.GetActiveInbound()
+	var err error
+	if plan := session.TempUser.Plan; plan != "" {
+		session.TempUser, err = core.NewClientFromPlan(session.TempUser.Username, session.TempUser.UUID, plan)
+	} else {
+		// FIXED: GetActiveInbound returns 3 values
+		var proto string
+		proto, _, err = core.GetActiveInbound()
+		session.TempUser.Protocol = proto
+	}
 	if err != nil {
-		b.sendMessage(chatID, "❌ Error getting protocol: "+err.Error())
+		b.sendMessage(chatID, "❌ Error: "+err.Error())
 		session.State = Idle
 		b.sendMenu(chatID)
 		return
 	}
-	session.TempUser.Protocol = proto
 
-	err = core.SaveClient(session.TempUser)
+	err = core.SaveClient(b.actor(), session.TempUser)
 	if err != nil {
 		b.sendMessage(chatID, "❌ Error saving: "+err.Error())
 	} else {
-		core.SyncConfig()
-		core.RestartXray()
 		b.sendMessage(chatID, fmt.Sprintf("✅ User Created: %s\nUUID: %s", session.TempUser.Username, session.TempUser.UUID))
+		b.applyConfig(chatID)
 	}
 	session.State = Idle
 	b.sendMenu(chatID)
 }
 
+// actor identifies the bot admin in the audit log
+func (b *Bot) actor() core.Actor {
+	return core.Actor{Name: fmt.Sprintf("telegram:%d", b.AdminID), Channel: core.ChannelBot}
+}
+
+// applyConfig puts a change live and tells the admin when Xray rejected it
+func (b *Bot) applyConfig(chatID int64) {
+	if err := core.ApplyXrayConfig(); err != nil {
+		b.sendMessage(chatID, "⚠️ Saved, but the new Xray config was not applied: "+err.Error())
+	}
+}
+
 func (b *Bot) sendMessage(chatID int64, text string) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	b.API.Send(msg)
@@ -208,9 +441,38 @@ func tgBotMenu() tgbotapi.InlineKeyboardMarkup {
 			tgbotapi.NewInlineKeyboardButtonData("Status", "status"),
 			tgbotapi.NewInlineKeyboardButtonData("Create User", "create"),
 		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Renew", "renew"),
+			tgbotapi.NewInlineKeyboardButtonData("Upgrade", "upgrade"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Find Users", "find"),
+			tgbotapi.NewInlineKeyboardButtonData("Trash", "trash"),
+		),
 	)
 }
 
+// tgBotKeyboardPlans lists every plan, optionally with a "Custom" button.
+// ok is false when no plans exist.
+func tgBotKeyboardPlans(custom bool) (tgbotapi.InlineKeyboardMarkup, bool) {
+	plans, _ := core.LoadPlans()
+	if len(plans) == 0 {
+		return tgbotapi.InlineKeyboardMarkup{}, false
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, p := range plans {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(p.Summary(), "plan:"+p.Name),
+		))
+	}
+	if custom {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Custom", "custom"),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...), true
+}
+
 func tgBotKeyboardUUID() tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
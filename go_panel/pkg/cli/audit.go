@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// ExportAudit writes the whole audit log to stdout as csv or json
+func ExportAudit(format string) error {
+	entries, err := core.ReadAudit(core.AuditFilter{})
+	if err != nil {
+		return err
+	}
+	return core.ExportAudit(os.Stdout, entries, format)
+}
+
+func auditMenu(r *bufio.Reader) {
+	fmt.Println("\n--- Audit Log ---")
+	var f core.AuditFilter
+
+	fmt.Print("Channel (web/bot/cli/task, Enter = all): ")
+	in, _ := r.ReadString('\n')
+	f.Channel = core.Channel(strings.TrimSpace(in))
+
+	fmt.Print("Action (e.g. create, delete, renew, Enter = all): ")
+	in, _ = r.ReadString('\n')
+	f.Action = strings.TrimSpace(in)
+
+	fmt.Print("Target contains (Enter = all): ")
+	in, _ = r.ReadString('\n')
+	f.Target = strings.TrimSpace(in)
+
+	fmt.Print("Last N days (Enter = all): ")
+	in, _ = r.ReadString('\n')
+	var days int
+	if fmt.Sscanf(strings.TrimSpace(in), "%d", &days); days > 0 {
+		f.Since = time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	}
+
+	entries, err := core.ReadAudit(f)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		waitForKey(r)
+		return
+	}
+	fmt.Printf("%d matching entries.\n", len(entries))
+
+	fmt.Print("Export to file (.csv or .json, Enter = show last 20): ")
+	in, _ = r.ReadString('\n')
+	path := strings.TrimSpace(in)
+	if path == "" {
+		start := 0
+		if len(entries) > 20 {
+			start = len(entries) - 20
+		}
+		for _, e := range entries[start:] {
+			fmt.Printf("%s %-4s %-12s %-14s %s\n", e.Time.Format("2006-01-02 15:04"), e.Channel, e.Actor, e.Action, e.Target)
+		}
+		waitForKey(r)
+		return
+	}
+
+	format := "json"
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		format = "csv"
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err == nil {
+		err = core.ExportAudit(out, entries, format)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	} else {
+		fmt.Printf("✅ Exported %d entries to %s\n", len(entries), path)
+	}
+	waitForKey(r)
+}
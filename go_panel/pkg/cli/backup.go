@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// WriteBackup writes a full backup archive to path ("-" = stdout)
+func WriteBackup(path string) error {
+	if path == "-" {
+		_, err := core.WriteBackup(os.Stdout)
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	m, err := core.WriteBackup(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	fmt.Printf("✅ Backup written to %s (%d clients, %d inbounds, %d files)\n", path, m.Clients, m.Inbounds, len(m.Files))
+	return nil
+}
+
+// RestoreBackup validates an archive, prints what would change and applies
+// it after confirmation (skipped with yes). Xray is synced and restarted.
+func RestoreBackup(path string, yes bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Backup S3 terenkripsi: coba passphrase server ini, kalau gagal tanya
+	plain, err := core.OpenBackupData(data, "")
+	if err != nil && core.IsEncryptedBackup(data) {
+		fmt.Print("Backup passphrase: ")
+		pass, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		plain, err = core.OpenBackupData(data, strings.TrimSpace(pass))
+	}
+	if err != nil {
+		return err
+	}
+	b, err := core.ReadBackup(bytes.NewReader(plain))
+	if err != nil {
+		return err
+	}
+	changes, err := b.Diff()
+	if err != nil {
+		return err
+	}
+
+	m := b.Manifest
+	fmt.Printf("Backup of %s from %s (%d clients, %d inbounds)\n\n", m.Hostname, m.CreatedAt.Format("2006-01-02 15:04"), m.Clients, m.Inbounds)
+	printBackupChanges(changes)
+
+	if !yes {
+		fmt.Print("\nRestore this backup? The current state is saved first. (y/n): ")
+		ans, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(strings.ToLower(ans)) != "y" {
+			fmt.Println("Cancelled.")
+			return nil
+		}
+	}
+
+	pre, err := core.RestoreBackup(core.CLIActor(), b)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ Backup restored. Previous state saved to %s\n", pre)
+	applyConfig()
+	return nil
+}
+
+func printBackupChanges(changes []core.BackupChange) {
+	for _, ch := range changes {
+		fmt.Printf(" %-15s %s\n", ch.Name, ch.Status)
+		for _, d := range ch.Details {
+			fmt.Printf("     %s\n", d)
+		}
+	}
+}
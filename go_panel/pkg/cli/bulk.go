@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// ExportClients writes every client to stdout as csv or json
+func ExportClients(format string) error {
+	clients, err := core.LoadClients()
+	if err != nil {
+		return err
+	}
+	return core.ExportClients(os.Stdout, clients, format)
+}
+
+// ImportClients imports a csv/json export and prints the report. The Xray
+// config is synced and restarted once at the end. Returns false when a
+// record failed.
+func ImportClients(path string, opt core.ImportOptions) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	clients, err := core.ParseClientImport(data, core.DetectImportFormat(path, data))
+	if err != nil {
+		return false, err
+	}
+	report, err := core.ImportClients(core.CLIActor(), clients, opt)
+	if err != nil {
+		return false, err
+	}
+	printImportReport(report)
+	if report.Changed() {
+		applyConfig()
+	}
+	return report.Count("failed") == 0, nil
+}
+
+func printImportReport(report core.ImportReport) {
+	if report.DryRun {
+		fmt.Println("--- Dry run, nothing was written ---")
+	}
+	for _, res := range report.Results {
+		name := res.Username
+		if res.Source != res.Username {
+			name = fmt.Sprintf("%s -> %s", res.Source, res.Username)
+		}
+		line := fmt.Sprintf("%-11s %s", res.Action, name)
+		if res.Note != "" {
+			line += " (" + res.Note + ")"
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("\ncreated %d, overwritten %d, renamed %d, skipped %d, failed %d\n",
+		report.Count("created"), report.Count("overwritten"), report.Count("renamed"),
+		report.Count("skipped"), report.Count("failed"))
+}
+
+// ImportForeignPanel imports a 3x-ui/x-ui database or a Marzban database /
+// export and prints what was created and what could not be mapped
+func ImportForeignPanel(path string, opt core.ImportOptions) (bool, error) {
+	fi, err := core.ReadForeignPanel(path)
+	if err != nil {
+		return false, err
+	}
+	return applyForeignImport(fi, opt)
+}
+
+// AdoptXrayConfig takes over a hand-written Xray config.json: its inbounds
+// and clients go into the databases before SyncConfig rewrites the file.
+// A pre-adopt backup keeps the original config.
+func AdoptXrayConfig(path string, opt core.ImportOptions) (bool, error) {
+	fi, err := core.ReadXrayConfig(path)
+	if err != nil {
+		return false, err
+	}
+	if !opt.DryRun {
+		backup, err := core.SaveBackupFile(core.BACKUP_DIR, "pre-adopt")
+		if err != nil {
+			return false, fmt.Errorf("backup before adopt: %v", err)
+		}
+		fmt.Printf("💾 Current files saved to %s\n\n", backup)
+	}
+	return applyForeignImport(fi, opt)
+}
+
+func applyForeignImport(fi *core.ForeignImport, opt core.ImportOptions) (bool, error) {
+	report, err := core.ApplyForeignImport(core.CLIActor(), fi, opt)
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Printf("Source: %s\n\n", report.Source)
+	if len(report.Inbounds) > 0 {
+		fmt.Println("Inbounds:")
+		for _, res := range report.Inbounds {
+			line := fmt.Sprintf("%-11s %s (%s)", res.Action, res.Username, res.Source)
+			if res.Note != "" {
+				line += " " + res.Note
+			}
+			fmt.Println(line)
+		}
+		fmt.Println()
+	}
+	fmt.Println("Clients:")
+	printImportReport(report.Clients)
+	if len(report.Unmapped) > 0 {
+		fmt.Println("\n⚠️  Not mapped:")
+		for _, u := range report.Unmapped {
+			fmt.Println(" - " + u)
+		}
+	}
+	if report.Overlay != "" {
+		fmt.Printf("\n💾 Parts the panel does not manage saved as config overlay: %s\n", report.Overlay)
+	}
+	if report.InboundsChanged() || report.Clients.Changed() {
+		applyConfig()
+	}
+	return report.Clients.Count("failed") == 0, nil
+}
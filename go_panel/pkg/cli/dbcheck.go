@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// RunDBCheck validates clients.db / inbounds.db and offers a repair.
+// With fix=true every repair is applied without asking.
+// Returns false when problems remain.
+func RunDBCheck(fix bool) bool {
+	return runDBCheck(bufio.NewReader(os.Stdin), fix)
+}
+
+func runDBCheck(r *bufio.Reader, fix bool) bool {
+	report, err := core.CheckDatabases()
+	if err != nil {
+		fmt.Printf("❌ Check failed: %v\n", err)
+		return false
+	}
+
+	fmt.Printf("Checked %d clients and %d inbounds.\n", report.Clients, report.Inbounds)
+	if len(report.Issues) == 0 {
+		fmt.Println("✅ No problems found.")
+		return true
+	}
+
+	fmt.Printf("\n⚠️  %d problem(s) found:\n", len(report.Issues))
+	for i, is := range report.Issues {
+		fmt.Printf(" [%d] %s\n      fix: %s\n", i+1, is, is.Fix)
+	}
+
+	all := fix
+	quit := false
+	approve := func(is core.DBIssue) bool {
+		if all {
+			return true
+		}
+		if quit {
+			return false
+		}
+		fmt.Printf("\n%s\nApply fix (%s)? [y]es/[n]o/[a]ll/[q]uit: ", is, is.Fix)
+		ans, _ := r.ReadString('\n')
+		switch strings.TrimSpace(strings.ToLower(ans)) {
+		case "y":
+			return true
+		case "a":
+			all = true
+			return true
+		case "q":
+			quit = true
+		}
+		return false
+	}
+
+	res, err := core.RepairDatabases(core.CLIActor(), report.Issues, approve)
+	if err != nil {
+		fmt.Printf("❌ Repair failed: %v\n", err)
+		return false
+	}
+	if len(res.Applied) == 0 {
+		fmt.Println("\nNothing changed.")
+		return false
+	}
+
+	for _, b := range res.Backups {
+		fmt.Printf("💾 Backup: %s\n", b)
+	}
+	fmt.Printf("✅ Applied %d fix(es).\n", len(res.Applied))
+
+	applyConfig()
+	return len(res.Applied) == len(report.Issues)
+}
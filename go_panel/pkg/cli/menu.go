@@ -34,6 +34,10 @@ func RunMenu() {
 		fmt.Println(" [12] Update Script (Force)")
 		fmt.Println(" [13] User Monitor (Traffic/Status)")
 		fmt.Println(" [14] Debug Center (Logs & Error)")
+		fmt.Println(" [15] Plans (Packages & Renewals)")
+		fmt.Println(" [16] Audit Log (View & Export)")
+		fmt.Println(" [17] Recycle Bin (Restore Deleted Users)")
+		fmt.Println(" [18] Account Sharing Report")
 		fmt.Println(" ")
 		fmt.Println(" [x]  Exit")
 		fmt.Print("\n Select Option: ")
@@ -43,7 +47,7 @@ func RunMenu() {
 
 		switch input {
 		case "1":
-			listUsers()
+			listUsers(reader)
 		case "2":
 			addUser(reader)
 		case "3":
@@ -58,7 +62,9 @@ func RunMenu() {
 			printSystemStatus()
 		case "8":
 			fmt.Println("Restarting...")
-			core.RestartXray()
+			if err := core.RestartXray(); err != nil {
+				fmt.Printf("⚠️  %v\n", err)
+			}
 			core.ManageSystemdService("xray-panel", "restart")
 			waitForKey(reader)
 		case "9":
@@ -67,7 +73,9 @@ func RunMenu() {
 				fmt.Printf("Error: %v\n", err)
 			} else {
 				fmt.Println("Xray Updated!")
-				core.RestartXray()
+				if err := core.RestartXray(); err != nil {
+					fmt.Printf("⚠️  %v\n", err)
+				}
 			}
 			waitForKey(reader)
 		case "10":
@@ -86,6 +94,14 @@ func RunMenu() {
 			monitorUsers(reader)
 		case "14":
 			debugMenu(reader)
+		case "15":
+			managePlans(reader)
+		case "16":
+			auditMenu(reader)
+		case "17":
+			trashMenu(reader)
+		case "18":
+			sharingReport(reader)
 		case "x", "X":
 			return
 		}
@@ -106,7 +122,7 @@ func deleteUser(r *bufio.Reader) {
 	}
 
 	// Konfirmasi
-	fmt.Printf("Are you sure you want to delete '%s'? (y/n): ", user)
+	fmt.Printf("Move '%s' to the trash? (y/n): ", user)
 	confirm, _ := r.ReadString('\n')
 	if strings.TrimSpace(strings.ToLower(confirm)) != "y" {
 		fmt.Println("Cancelled.")
@@ -114,13 +130,12 @@ func deleteUser(r *bufio.Reader) {
 		return
 	}
 
-	err := core.DeleteClient(user)
+	err := core.DeleteClient(core.CLIActor(), user)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 	} else {
-		core.SyncConfig()
-		core.RestartXray()
-		fmt.Println("✅ User Deleted!")
+		fmt.Println("✅ User moved to trash (restore via Recycle Bin).")
+		applyConfig()
 	}
 	waitForKey(r)
 }
@@ -138,6 +153,8 @@ func debugMenu(r *bufio.Reader) {
 		fmt.Println(" [3] View Panel & Bot Log (Systemd Journal)")
 		fmt.Println(" [4] Check Service Status (Detailed)")
 		fmt.Println(" [5] Test Config Syntax (xray run -test)")
+		fmt.Println(" [6] Check & Repair Databases")
+		fmt.Println(" [7] Config Overlay & User-Owned Sections")
 		fmt.Println(" ")
 		fmt.Println(" [x] Back to Main Menu")
 		fmt.Print("\n Select Log: ")
@@ -164,11 +181,15 @@ func debugMenu(r *bufio.Reader) {
 			waitForKey(r)
 		case "5":
 			fmt.Println("\n--- Config Syntax Check ---")
-			binPath := "/usr/local/bin/xray"
-			if _, err := os.Stat(binPath); os.IsNotExist(err) {
-				binPath = "/usr/bin/xray"
-			}
-			runCommand(binPath, "run", "-test", "-confdir", "/usr/local/etc/xray")
+			runCommand(core.XrayBinary(), "run", "-test", "-confdir", "/usr/local/etc/xray")
+			waitForKey(r)
+		case "6":
+			fmt.Println("\n--- Database Check ---")
+			runDBCheck(r, false)
+			waitForKey(r)
+		case "7":
+			fmt.Println("\n--- Config Overlay ---")
+			configOverlayMenu(r)
 			waitForKey(r)
 		case "x", "X":
 			return
@@ -176,6 +197,13 @@ func debugMenu(r *bufio.Reader) {
 	}
 }
 
+// applyConfig puts a change live and warns when Xray rejected it
+func applyConfig() {
+	if err := core.ApplyXrayConfig(); err != nil {
+		fmt.Printf("⚠️  Saved, but the new Xray config was not applied: %v\n", err)
+	}
+}
+
 func runCommand(name string, args ...string) {
 	cmd := exec.Command(name, args...)
 	cmd.Stdout = os.Stdout
@@ -191,10 +219,12 @@ func monitorUsers(r *bufio.Reader) {
 		fmt.Println("==================================================")
 		fmt.Println("             LIVE USER MONITOR                    ")
 		fmt.Println("==================================================")
-		fmt.Printf("%-12s | %-10s | %-10s \n", "Username", "Usage/Quota", "Status")
+		fmt.Printf("%-12s | %-10s | %-9s | %-10s \n", "Username", "Usage/Quota", "Today", "Status")
 		fmt.Println("--------------------------------------------------")
 
 		clients, _ := core.LoadClients()
+		today, _ := core.UsageTotals(time.Now())
+		online := core.OnlineUsers()
 		for _, c := range clients {
 			usedGB := float64(c.Used) / 1024 / 1024 / 1024
 			usageStr := fmt.Sprintf("%.2f/%.2f", usedGB, c.Quota)
@@ -202,29 +232,35 @@ func monitorUsers(r *bufio.Reader) {
 			status := "OFFLINE"
 			color := ""
 
-			if c.IsExpired {
+			if c.Status != core.StatusActive {
+				status = strings.ToUpper(string(c.Status))
+				color = "\033[31m"
+			} else if c.IsExpired {
 				status = "EXPIRED"
-				color = "\033[31m" 
-			} else if c.Used >= (c.Quota * 1024 * 1024 * 1024) {
+				color = "\033[31m"
+			} else if c.Quota > 0 && c.Used >= c.QuotaBytes() {
 				status = "LIMIT"
-				color = "\033[31m" 
+				color = "\033[31m"
 			} else {
-				if core.IsUserOnline(c.Username) {
+				if online[c.Username] {
 					status = "ONLINE"
-					color = "\033[32m" 
+					color = "\033[32m"
 				}
 			}
 
-			fmt.Printf("%-12s | %-10s | %s%s\033[0m\n", c.Username, usageStr, color, status)
+			todayStr := core.FormatBytes(float64(today[c.Username].Total()))
+			fmt.Printf("%-12s | %-10s | %-9s | %s%s\033[0m\n", c.Username, usageStr, todayStr, color, status)
 		}
 		fmt.Println("==================================================")
-		fmt.Println(" [Enter] Refresh  [x] Back to Menu")
+		fmt.Println(" [Enter] Refresh  [h] Usage History  [x] Back to Menu")
 		fmt.Print(" Select: ")
 
 		input, _ := r.ReadString('\n')
-		input = strings.TrimSpace(input)
-		if strings.ToLower(input) == "x" {
+		switch strings.ToLower(strings.TrimSpace(input)) {
+		case "x":
 			return
+		case "h":
+			usageHistory(r)
 		}
 	}
 }
@@ -324,19 +360,48 @@ func waitForKey(r *bufio.Reader) {
 	r.ReadString('\n')
 }
 
-func listUsers() {
+func listUsers(r *bufio.Reader) {
 	clients, _ := core.LoadClients()
+	if tags := core.TagList(clients); len(tags) > 0 {
+		fmt.Printf("\nTags: %s\n", strings.Join(tags, ", "))
+	}
+	var filter core.ClientFilter
+	for {
+		fmt.Print("Filter (Enter = all, e.g. vip status:active inbound:vless-ws expires:7 usage:80): ")
+		in, _ := r.ReadString('\n')
+		f, err := core.ParseClientFilter(in)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		filter = f
+		break
+	}
+
+	shown := core.FilterClients(clients, filter)
 	fmt.Println("\n--- User List ---")
-	for i, c := range clients {
+	if !filter.IsZero() {
+		fmt.Printf("Filter: %s (%d of %d users)\n", filter, len(shown), len(clients))
+	}
+	for i, c := range shown {
 		status := "OK"
-		if c.IsExpired {
+		if c.Status != core.StatusActive {
+			status = strings.ToUpper(string(c.Status))
+		} else if c.IsExpired {
 			status = "EXPIRED"
 		}
 		usedGB := float64(c.Used) / 1024 / 1024 / 1024
-		fmt.Printf("[%d] %-12s | %.2f/%.2f GB | %s | %s\n", i+1, c.Username, usedGB, c.Quota, status, c.Expiry.Format("2006-01-02"))
+		fmt.Printf("[%d] %-12s | %.2f/%.2f GB | %s | %s", i+1, c.Username, usedGB, c.Quota, status, c.Expiry.Format("2006-01-02"))
+		if len(c.Tags) > 0 {
+			fmt.Printf(" | #%s", strings.Join(c.Tags, " #"))
+		}
+		fmt.Println()
+		if c.Notes != "" {
+			fmt.Printf("     📝 %s\n", strings.ReplaceAll(c.Notes, "\n", " "))
+		}
 	}
 	fmt.Print("\nPress Enter...")
-	bufio.NewReader(os.Stdin).ReadString('\n')
+	r.ReadString('\n')
 }
 
 func addUser(r *bufio.Reader) {
@@ -349,59 +414,100 @@ func addUser(r *bufio.Reader) {
 		return
 	}
 
-	fmt.Println("\nSelect Inbound Protocol:")
-	var availableProtocols []string
-	seen := make(map[string]bool)
-	
-	idx := 1
-	for _, inb := range inbounds {
-		// Tampilkan list protocol yang unik agar user tidak bingung
-		if !seen[inb.Tag] {
-			fmt.Printf(" [%d] %s (Port: %d, etc)\n", idx, inb.Tag, inb.Port)
-			availableProtocols = append(availableProtocols, inb.Tag)
-			seen[inb.Tag] = true
-			idx++
-		}
-	}
-	
-	fmt.Print("Choice: ")
-	selStr, _ := r.ReadString('\n')
-	sel, _ := strconv.Atoi(strings.TrimSpace(selStr))
-	
-	if sel < 1 || sel > len(availableProtocols) {
+	// Pilih paket dulu; tanpa paket quota/days/inbound diisi manual
+	plan, ok := selectPlan(r, "Plan (Enter = custom): ")
+	if !ok {
 		fmt.Println("Invalid choice")
+		waitForKey(r)
 		return
 	}
-	selectedTag := availableProtocols[sel-1]
 
-	fmt.Print("Username: ")
-	user, _ := r.ReadString('\n')
-	user = strings.TrimSpace(user)
+	var selectedTags []string
+	if plan == "" {
+		fmt.Println("\nSelect Inbound Protocol (one or more, e.g. 1,3):")
+		selectedTags = selectInbounds(r, inbounds)
+		if len(selectedTags) == 0 {
+			fmt.Println("Invalid choice")
+			return
+		}
+	}
 
-	fmt.Print("Quota (GB): ")
-	qStr, _ := r.ReadString('\n')
-	var quota float64
-	fmt.Sscanf(strings.TrimSpace(qStr), "%f", &quota)
+	var user string
+	for {
+		fmt.Print("Username: ")
+		user, _ = r.ReadString('\n')
+		user = strings.TrimSpace(user)
+		if user == "" {
+			return
+		}
+		if err := core.ValidateUsername(user); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		break
+	}
 
-	fmt.Print("Days: ")
-	dStr, _ := r.ReadString('\n')
+	var quota float64
 	var days int
-	fmt.Sscanf(strings.TrimSpace(dStr), "%d", &days)
+	if plan == "" {
+		fmt.Print("Quota (GB): ")
+		qStr, _ := r.ReadString('\n')
+		fmt.Sscanf(strings.TrimSpace(qStr), "%f", &quota)
+
+		fmt.Print("Days: ")
+		dStr, _ := r.ReadString('\n')
+		fmt.Sscanf(strings.TrimSpace(dStr), "%d", &days)
+	}
+	var period string
+	var deviceLimit int
+	if plan == "" {
+		period = readResetPeriod(r, core.ResetNone)
+		deviceLimit = readDeviceLimit(r, 0)
+	}
+
+	tags := readTags(r, nil)
+	notes := readNotes(r, "")
+
+	uuid := core.GenerateUUID()
+	for {
+		fmt.Print("UUID (empty = random): ")
+		in, _ := r.ReadString('\n')
+		in = core.NormalizeUUID(in)
+		if in == "" {
+			break
+		}
+		if err := core.ValidateUUID(in, ""); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		uuid = in
+		break
+	}
 
 	client := core.Client{
-		Username: user,
-		Quota:    quota,
-		Expiry:   time.Now().Add(time.Duration(days) * 24 * time.Hour),
-		Protocol: selectedTag,
-		UUID:     core.GenerateUUID(),
+		Username:    user,
+		Quota:       quota,
+		Expiry:      time.Now().Add(time.Duration(days) * 24 * time.Hour),
+		UUID:        uuid,
+		ResetPeriod: period,
+		DeviceLimit: deviceLimit,
+	}
+	client.SetInbounds(selectedTags)
+	if plan != "" {
+		if client, err = core.NewClientFromPlan(user, uuid, plan); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			waitForKey(r)
+			return
+		}
 	}
+	client.Tags = tags
+	client.Notes = notes
 
-	if err := core.SaveClient(client); err != nil {
+	if err := core.SaveClient(core.CLIActor(), client); err != nil {
 		fmt.Printf("Error: %v\n", err)
 	} else {
-		core.SyncConfig()
-		core.RestartXray()
 		fmt.Println("\n✅ User Created!")
+		applyConfig()
 
 		domainBytes, _ := os.ReadFile("/root/domain")
 		domain := strings.TrimSpace(string(domainBytes))
@@ -409,19 +515,143 @@ func addUser(r *bufio.Reader) {
 			domain = core.GetHostname()
 		}
 
-		link := core.GenerateLink(client, domain)
-		fmt.Println("\n🔗 Xray Link:")
-		fmt.Println(link)
+		printLinks(client, domain)
+	}
+	waitForKey(r)
+}
 
-		if link != "" {
-			fmt.Println("\n📱 QR Code:")
-			cmd := exec.Command("qrencode", "-t", "ANSIUTF8", link)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			cmd.Run()
+// selectInbounds lists the distinct inbound tags and reads a comma separated
+// choice. Returns nil on empty or invalid input.
+func selectInbounds(r *bufio.Reader, inbounds []core.InboundDet) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, inb := range inbounds {
+		// Tampilkan list protocol yang unik agar user tidak bingung
+		if !seen[inb.Tag] {
+			fmt.Printf(" [%d] %s (Port: %d, etc)\n", len(tags)+1, inb.Tag, inb.Port)
+			tags = append(tags, inb.Tag)
+			seen[inb.Tag] = true
 		}
 	}
-	waitForKey(r)
+
+	fmt.Print("Choice: ")
+	selStr, _ := r.ReadString('\n')
+	var selected []string
+	for _, part := range strings.Split(selStr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		sel, err := strconv.Atoi(part)
+		if err != nil || sel < 1 || sel > len(tags) {
+			return nil
+		}
+		selected = append(selected, tags[sel-1])
+	}
+	return selected
+}
+
+// readResetPeriod asks for a quota reset period until it parses; Enter keeps current
+func readResetPeriod(r *bufio.Reader, current string) string {
+	for {
+		fmt.Printf("Quota Reset (none/daily/weekly/monthly/day:N, Enter = %s): ", core.DescribeResetPeriod(current))
+		in, _ := r.ReadString('\n')
+		in = strings.TrimSpace(in)
+		if in == "" {
+			return current
+		}
+		period, err := core.ParseResetPeriod(in)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		return period
+	}
+}
+
+// readDeviceLimit asks for the max IPs until it parses; Enter keeps current
+func readDeviceLimit(r *bufio.Reader, current int) int {
+	for {
+		fmt.Printf("Max IPs (0 = unlimited, Enter = %d): ", current)
+		in, _ := r.ReadString('\n')
+		if strings.TrimSpace(in) == "" {
+			return current
+		}
+		n, err := core.ParseDeviceLimit(in)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		return n
+	}
+}
+
+// readTags asks for comma separated tags until they parse; Enter keeps
+// current, "-" clears
+func readTags(r *bufio.Reader, current []string) []string {
+	for {
+		fmt.Printf("Tags (e.g. reseller,vip, - = none, Enter = %s): ", describeList(current))
+		in, _ := r.ReadString('\n')
+		in = strings.TrimSpace(in)
+		switch in {
+		case "":
+			return current
+		case "-":
+			return nil
+		}
+		tags, err := core.ParseTags(in)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		return tags
+	}
+}
+
+// readNotes asks for a one line note; Enter keeps current, "-" clears
+func readNotes(r *bufio.Reader, current string) string {
+	for {
+		keep := "none"
+		if current != "" {
+			keep = "keep"
+		}
+		fmt.Printf("Notes (- = none, Enter = %s): ", keep)
+		in, _ := r.ReadString('\n')
+		in = strings.TrimSpace(in)
+		switch in {
+		case "":
+			return current
+		case "-":
+			return ""
+		}
+		notes, err := core.ParseNotes(in)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		return notes
+	}
+}
+
+func describeList(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ",")
+}
+
+// printLinks shows the link and QR code of every attached inbound
+func printLinks(c core.Client, domain string) {
+	for _, link := range core.GenerateLink(c, domain) {
+		fmt.Printf("\n🔗 Xray Link (%s):\n", link.Tag)
+		fmt.Println(link.URL)
+
+		fmt.Println("\n📱 QR Code:")
+		cmd := exec.Command("qrencode", "-t", "ANSIUTF8", link.URL)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Run()
+	}
 }
 
 func editUser(r *bufio.Reader) {
@@ -457,24 +687,71 @@ func editUser(r *bufio.Reader) {
 	var days int
 	if dStr != "" {
 		fmt.Sscanf(dStr, "%d", &days)
-		if days > 0 {
-			found.Expiry = found.Expiry.Add(time.Duration(days) * 24 * time.Hour)
-			found.IsExpired = false
+	}
+
+	if found.PrevUsed > 0 {
+		fmt.Printf("Last period usage: %.2f GB\n", found.PrevUsed/1024/1024/1024)
+	}
+	period := readResetPeriod(r, found.ResetPeriod)
+	deviceLimit := readDeviceLimit(r, found.DeviceLimit)
+	if found.Notes != "" {
+		fmt.Printf("Current Notes: %s\n", found.Notes)
+	}
+	tags := readTags(r, found.Tags)
+	notes := readNotes(r, found.Notes)
+
+	inbounds, _ := core.LoadAllInbounds()
+	fmt.Printf("Current Inbounds: %s\n", strings.Join(found.InboundTags(), ", "))
+	fmt.Println("New Inbounds (e.g. 1,3, Enter to keep):")
+	newTags := selectInbounds(r, inbounds)
+
+	fmt.Printf("Current Status: %s. New Status (active/disabled/suspended, Enter to keep): ", found.Status)
+	sStr, _ := r.ReadString('\n')
+	sStr = strings.TrimSpace(strings.ToLower(sStr))
+	var newStatus core.ClientStatus
+	if sStr != "" {
+		st, err := core.ParseStatus(sStr)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			waitForKey(r)
+			return
 		}
+		newStatus = st
 	}
 
-	err := core.UpdateClient(user, func(target *core.Client) {
+	if len(newTags) > 0 {
+		if err := core.CheckInboundTags(newTags); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			waitForKey(r)
+			return
+		}
+	}
+
+	// Satu update, satu catatan audit: tidak ada edit yang setengah tersimpan
+	err := core.UpdateClient(core.CLIActor(), user, func(target *core.Client) {
 		target.Quota = found.Quota
-		target.Expiry = found.Expiry
-		target.IsExpired = found.IsExpired
+		target.SetResetPeriod(period)
+		target.DeviceLimit = deviceLimit
+		target.Tags = tags
+		target.Notes = notes
+		target.LiftLimitStatus()
+		if days > 0 {
+			// Renew memakai UUID yang sama dan mengaktifkan lagi user expired / limit
+			target.Renew(days)
+		}
+		if len(newTags) > 0 {
+			target.SetInbounds(newTags)
+		}
+		if newStatus != "" && newStatus != found.Status {
+			target.SetStatus(newStatus, "set from CLI")
+		}
 	})
 
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 	} else {
-		core.SyncConfig()
-		core.RestartXray()
 		fmt.Println("User Updated!")
+		applyConfig()
 	}
 	waitForKey(r)
 }
@@ -501,6 +778,7 @@ func addInbound(r *bufio.Reader) {
 	fmt.Println("1. XTLS-Vision (TCP)")
 	fmt.Println("2. WebSocket (WS)")
 	fmt.Println("3. gRPC")
+	fmt.Println("4. REALITY (VLESS only)")
 	fmt.Print("Choice (default 1): ")
 	transStr, _ := r.ReadString('\n')
 	transStr = strings.TrimSpace(transStr)
@@ -511,12 +789,18 @@ func addInbound(r *bufio.Reader) {
 		transport = "ws"
 	case "3":
 		transport = "grpc"
+	case "4":
+		transport = core.RealityTransport
 	}
 
 	if protocol == "vmess" && transport == "xtls" {
 		fmt.Println("Warning: VMess + XTLS is not recommended. Switching to WS.")
 		transport = "ws"
 	}
+	if protocol != "vless" && transport == core.RealityTransport {
+		fmt.Println("Warning: REALITY only works with VLESS. Switching to VLESS.")
+		protocol = "vless"
+	}
 
 	// INPUT PORT MANUAL
 	fmt.Print("\nEnter Port (e.g. 443, 8080, 2053): ")
@@ -530,14 +814,36 @@ func addInbound(r *bufio.Reader) {
 		}
 	}
 
-	fmt.Printf("\nCreating %s-%s on Port %d...\n", protocol, transport, port)
+	var err error
+	if transport == core.RealityTransport {
+		// Kunci x25519 dibuat di sini, tidak perlu `xray x25519`
+		fmt.Printf("Dest (default %s): ", core.RealityDefaultDest)
+		dest, _ := r.ReadString('\n')
+		fmt.Print("Server names, comma separated (default host of dest): ")
+		names, _ := r.ReadString('\n')
+		fmt.Print("Short IDs, comma separated (default random): ")
+		sids, _ := r.ReadString('\n')
 
-	err := core.AddInbound(protocol, transport, port)
+		reality, rerr := core.NewInboundReality(dest, core.ParseRealityList(names), core.ParseRealityList(sids))
+		if rerr != nil {
+			fmt.Printf("Error: %v\n", rerr)
+			waitForKey(r)
+			return
+		}
+		fmt.Printf("\nCreating %s-%s on Port %d...\n", protocol, transport, port)
+		fmt.Printf("Public key: %s\n", reality.PublicKey)
+		fmt.Printf("Short IDs : %s\n", strings.Join(reality.ShortIDs, ","))
+		err = core.AddRealityInbound(core.CLIActor(), port, reality)
+	} else {
+		fmt.Printf("\nCreating %s-%s on Port %d...\n", protocol, transport, port)
+		err = core.AddInbound(core.CLIActor(), protocol, transport, port)
+	}
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
+	} else if err := core.RestartXray(); err != nil {
+		fmt.Printf("⚠️  Inbound created, but %v\n", err)
 	} else {
 		fmt.Println("Inbound Created & Xray Restarted!")
-		core.RestartXray()
 	}
 	waitForKey(r)
 }
@@ -567,13 +873,12 @@ func deleteInbound(r *bufio.Reader) {
 
 	target := inbounds[sel-1]
 	fmt.Printf("Deleting %s on port %d...\n", target.Tag, target.Port)
-	
-	if err := core.DeleteInbound(target.Port); err != nil {
+
+	if err := core.DeleteInbound(core.CLIActor(), target.Port); err != nil {
 		fmt.Printf("Error: %v\n", err)
 	} else {
-		core.SyncConfig()
-		core.RestartXray()
 		fmt.Println("Inbound Deleted!")
+		applyConfig()
 	}
 	waitForKey(r)
 }
@@ -618,7 +923,7 @@ func manageBot(r *bufio.Reader) {
 			return
 		}
 
-		if err := core.SaveBotConfig(token, adminID); err != nil {
+		if err := core.SaveBotConfig(core.CLIActor(), token, adminID); err != nil {
 			fmt.Printf("❌ Failed to save config: %v\n", err)
 		} else {
 			fmt.Println("✅ Config Saved! Restarting Panel to activate Bot...")
@@ -626,7 +931,7 @@ func manageBot(r *bufio.Reader) {
 		}
 
 	case "2":
-		if err := core.RemoveBotConfig(); err != nil {
+		if err := core.RemoveBotConfig(core.CLIActor()); err != nil {
 			fmt.Println("⚠️  Bot is already disabled or file missing.")
 		}
 		fmt.Println("✅ Bot Config Removed. Restarting Panel...")
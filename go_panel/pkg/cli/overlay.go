@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// configOverlayMenu shows the config.d fragments and lets the admin pick the
+// config.json sections SyncConfig must keep as they are
+func configOverlayMenu(r *bufio.Reader) {
+	settings, err := core.LoadPanelSettings()
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	fmt.Printf("Overlays in %s:\n", core.CONFIG_OVERLAY_DIR)
+	overlays, err := core.LoadOverlays()
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+	} else if len(overlays) == 0 {
+		fmt.Println(" (none, drop *.json fragments there; merged in name order)")
+	}
+	for _, f := range overlays {
+		fmt.Printf(" - %-24s %s\n", f.Name, strings.Join(f.Sections, ", "))
+	}
+	if err == nil {
+		if _, err := core.PreviewConfig(); err != nil {
+			fmt.Printf("⚠️  The next sync would fail: %v\n", err)
+		}
+	}
+
+	current := "(none)"
+	if len(settings.UserSections) > 0 {
+		current = strings.Join(settings.UserSections, ", ")
+	}
+	fmt.Printf("\nUser-owned sections: %s\n", current)
+	fmt.Printf("Choices: %s\n", strings.Join(core.UserSectionChoices, ", "))
+	fmt.Print("New list (comma separated, '-' = none, empty = keep): ")
+	in, _ := r.ReadString('\n')
+	in = strings.TrimSpace(in)
+	if in == "" {
+		return
+	}
+
+	var sections []string
+	if in != "-" {
+		for _, s := range strings.FieldsFunc(in, func(c rune) bool { return c == ',' || c == ' ' }) {
+			sections = append(sections, strings.ToLower(s))
+		}
+	}
+	settings.UserSections = sections
+	if err := core.SavePanelSettings(core.CLIActor(), settings); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+	fmt.Println("✅ Saved!")
+	applyConfig()
+}
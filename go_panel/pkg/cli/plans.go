@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+func managePlans(r *bufio.Reader) {
+	for {
+		clearScreen()
+		fmt.Println("==================================================")
+		fmt.Println("             PLANS & RENEWALS                     ")
+		fmt.Println("==================================================")
+		plans, err := core.LoadPlans()
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+		}
+		if len(plans) == 0 {
+			fmt.Println(" No plans yet.")
+		}
+		for _, p := range plans {
+			inb := "all inbounds"
+			if len(p.Inbounds) > 0 {
+				inb = strings.Join(p.Inbounds, ",")
+			}
+			fmt.Printf(" - %s (%s)\n", p.Summary(), inb)
+		}
+		fmt.Println("--------------------------------------------------")
+		fmt.Println(" [1] Add / Update Plan")
+		fmt.Println(" [2] Delete Plan")
+		fmt.Println(" [3] Renew User from Plan")
+		fmt.Println(" [4] Upgrade User to Plan")
+		fmt.Println(" ")
+		fmt.Println(" [x] Back to Main Menu")
+		fmt.Print("\n Select Option: ")
+
+		input, _ := r.ReadString('\n')
+		switch strings.TrimSpace(input) {
+		case "1":
+			editPlan(r)
+		case "2":
+			name, ok := selectPlan(r, "Plan to delete: ")
+			if ok && name != "" {
+				if err := core.DeletePlan(core.CLIActor(), name); err != nil {
+					fmt.Printf("Error: %v\n", err)
+				} else {
+					fmt.Println("✅ Plan Deleted!")
+				}
+			}
+			waitForKey(r)
+		case "3":
+			applyPlanToUser(r, false)
+		case "4":
+			applyPlanToUser(r, true)
+		case "x", "X":
+			return
+		}
+	}
+}
+
+func editPlan(r *bufio.Reader) {
+	fmt.Print("Plan Name: ")
+	name, _ := r.ReadString('\n')
+	p := core.Plan{Name: strings.TrimSpace(name)}
+	if p.Name == "" {
+		return
+	}
+
+	fmt.Print("Quota (GB, 0 = unlimited): ")
+	qStr, _ := r.ReadString('\n')
+	fmt.Sscanf(strings.TrimSpace(qStr), "%f", &p.Quota)
+
+	fmt.Print("Days: ")
+	dStr, _ := r.ReadString('\n')
+	fmt.Sscanf(strings.TrimSpace(dStr), "%d", &p.Days)
+
+	fmt.Print("Device Limit (0 = unlimited): ")
+	lStr, _ := r.ReadString('\n')
+	fmt.Sscanf(strings.TrimSpace(lStr), "%d", &p.DeviceLimit)
+
+	p.ResetPeriod = readResetPeriod(r, core.ResetNone)
+
+	fmt.Print("Price: ")
+	pStr, _ := r.ReadString('\n')
+	fmt.Sscanf(strings.TrimSpace(pStr), "%f", &p.Price)
+
+	inbounds, _ := core.LoadAllInbounds()
+	fmt.Println("Allowed Inbounds (e.g. 1,3, Enter = all):")
+	p.Inbounds = selectInbounds(r, inbounds)
+
+	if err := core.SavePlan(core.CLIActor(), p); err != nil {
+		fmt.Printf("Error: %v\n", err)
+	} else {
+		fmt.Println("✅ Plan Saved!")
+	}
+	waitForKey(r)
+}
+
+// selectPlan lists the plans and reads a choice. Empty input returns ""
+// (no plan); ok is false for an invalid number.
+func selectPlan(r *bufio.Reader, prompt string) (name string, ok bool) {
+	plans, _ := core.LoadPlans()
+	if len(plans) == 0 {
+		return "", true
+	}
+	fmt.Println("\nPlans:")
+	for i, p := range plans {
+		fmt.Printf(" [%d] %s\n", i+1, p.Summary())
+	}
+	fmt.Print(prompt)
+	in, _ := r.ReadString('\n')
+	in = strings.TrimSpace(in)
+	if in == "" {
+		return "", true
+	}
+	sel, err := strconv.Atoi(in)
+	if err != nil || sel < 1 || sel > len(plans) {
+		return "", false
+	}
+	return plans[sel-1].Name, true
+}
+
+func applyPlanToUser(r *bufio.Reader, upgrade bool) {
+	fmt.Print("Username: ")
+	user, _ := r.ReadString('\n')
+	user = strings.TrimSpace(user)
+	if user == "" {
+		return
+	}
+	plan, ok := selectPlan(r, "Plan: ")
+	if !ok || plan == "" {
+		fmt.Println("Invalid choice")
+		waitForKey(r)
+		return
+	}
+
+	var err error
+	if upgrade {
+		err = core.UpgradeToPlan(core.CLIActor(), user, plan)
+	} else {
+		err = core.RenewFromPlan(core.CLIActor(), user, plan)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	} else {
+		fmt.Printf("✅ %s is now on plan %s\n", user, plan)
+		applyConfig()
+	}
+	waitForKey(r)
+}
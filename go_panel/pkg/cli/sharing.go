@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// sharingReport prints the clients most likely to share their account
+func sharingReport(r *bufio.Reader) {
+	fmt.Print("Hours to analyse (Enter = 24): ")
+	in, _ := r.ReadString('\n')
+	hours, err := strconv.Atoi(strings.TrimSpace(in))
+	if err != nil || hours <= 0 {
+		hours = 24
+	}
+
+	report, err := core.BuildSharingReport(time.Now().Add(-time.Duration(hours) * time.Hour))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		waitForKey(r)
+		return
+	}
+	clearScreen()
+	fmt.Printf("--- Account sharing, last %d hours (%d connections) ---\n", hours, report.Lines)
+	if !report.Country || !report.ASN {
+		fmt.Printf("⚠️  GeoIP databases missing, networks/countries not scored (%s, %s)\n", core.GEOIP_COUNTRY_DB, core.GEOIP_ASN_DB)
+	}
+	if len(report.Scores) == 0 {
+		fmt.Println("\nNo suspicious accounts in this period.")
+		waitForKey(r)
+		return
+	}
+	fmt.Printf("\n%-3s %-16s %6s %5s %5s %5s  %s\n", "#", "Username", "Score", "IPs", "Nets", "Ctry", "Reasons")
+	for i, s := range report.Scores {
+		fmt.Printf("%-3d %-16s %6.1f %5d %5d %5d  %s\n", i+1, s.Username, s.Score,
+			len(s.IPs), len(s.ASNs), len(s.Countries), strings.Join(s.Reasons, "; "))
+	}
+
+	fmt.Print("\nShow details for username (Enter = back): ")
+	user, _ := r.ReadString('\n')
+	user = strings.TrimSpace(user)
+	for _, s := range report.Scores {
+		if s.Username != user {
+			continue
+		}
+		fmt.Printf("\n%s: %d connections, up to %d IPs in one minute\n", s.Username, s.Connections, s.MaxParallel)
+		fmt.Println("IPs:       " + strings.Join(s.IPs, ", "))
+		if len(s.ASNs) > 0 {
+			fmt.Println("Networks:  " + strings.Join(s.ASNs, ", "))
+		}
+		if len(s.Countries) > 0 {
+			fmt.Println("Countries: " + strings.Join(s.Countries, ", "))
+		}
+		waitForKey(r)
+	}
+}
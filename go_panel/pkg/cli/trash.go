@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+func trashMenu(r *bufio.Reader) {
+	for {
+		clearScreen()
+		fmt.Println("==================================================")
+		fmt.Println("             RECYCLE BIN                          ")
+		fmt.Println("==================================================")
+		entries, err := core.LoadTrash()
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+		}
+		if len(entries) == 0 {
+			fmt.Println(" Trash is empty.")
+		}
+		for i, e := range entries {
+			c := e.Client
+			fmt.Printf("[%d] %-12s | %.2f/%.2f GB | exp %s | deleted %s by %s\n", i+1, c.Username,
+				c.Used/1024/1024/1024, c.Quota, c.Expiry.Format("2006-01-02"),
+				e.DeletedAt.Format("2006-01-02 15:04"), e.DeletedBy)
+		}
+		settings, _ := core.LoadPanelSettings()
+		fmt.Println("--------------------------------------------------")
+		if settings.TrashRetentionDays > 0 {
+			fmt.Printf(" Auto purge after %d days\n", settings.TrashRetentionDays)
+		} else {
+			fmt.Println(" Auto purge disabled")
+		}
+		fmt.Println(" [1] Restore User")
+		fmt.Println(" [2] Purge User (Permanent)")
+		fmt.Println(" [3] Set Retention Days")
+		fmt.Println(" ")
+		fmt.Println(" [x] Back to Main Menu")
+		fmt.Print("\n Select Option: ")
+
+		input, _ := r.ReadString('\n')
+		switch strings.TrimSpace(input) {
+		case "1":
+			if e, ok := selectTrash(r, entries); ok {
+				if err := core.RestoreClient(core.CLIActor(), e.ID); err != nil {
+					fmt.Printf("Error: %v\n", err)
+				} else {
+					fmt.Printf("✅ %s restored!\n", e.Client.Username)
+					applyConfig()
+				}
+				waitForKey(r)
+			}
+		case "2":
+			if e, ok := selectTrash(r, entries); ok {
+				fmt.Printf("Delete '%s' permanently? (y/n): ", e.Client.Username)
+				confirm, _ := r.ReadString('\n')
+				if strings.TrimSpace(strings.ToLower(confirm)) == "y" {
+					if err := core.PurgeTrash(core.CLIActor(), e.ID); err != nil {
+						fmt.Printf("Error: %v\n", err)
+					} else {
+						fmt.Println("✅ Purged!")
+					}
+				}
+				waitForKey(r)
+			}
+		case "3":
+			fmt.Printf("Retention days (0 = forever, current %d): ", settings.TrashRetentionDays)
+			in, _ := r.ReadString('\n')
+			days, err := strconv.Atoi(strings.TrimSpace(in))
+			if err == nil {
+				settings.TrashRetentionDays = days
+				err = core.SavePanelSettings(core.CLIActor(), settings)
+			}
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+			} else {
+				fmt.Println("✅ Saved!")
+			}
+			waitForKey(r)
+		case "x", "X":
+			return
+		}
+	}
+}
+
+func selectTrash(r *bufio.Reader, entries []core.TrashEntry) (core.TrashEntry, bool) {
+	if len(entries) == 0 {
+		return core.TrashEntry{}, false
+	}
+	fmt.Print("Number: ")
+	in, _ := r.ReadString('\n')
+	sel, err := strconv.Atoi(strings.TrimSpace(in))
+	if err != nil || sel < 1 || sel > len(entries) {
+		fmt.Println("Invalid choice")
+		waitForKey(r)
+		return core.TrashEntry{}, false
+	}
+	return entries[sel-1], true
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// usageHistory prints the hourly and daily traffic of one client
+func usageHistory(r *bufio.Reader) {
+	fmt.Print("Username: ")
+	user, _ := r.ReadString('\n')
+	user = strings.TrimSpace(user)
+	if user == "" {
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	hours, err := core.UsageHistory(user, core.Hourly, now.Add(-23*time.Hour), now)
+	if err == nil {
+		var days []core.UsagePoint
+		if days, err = core.UsageHistory(user, core.Daily, today.AddDate(0, 0, -13), now); err == nil {
+			clearScreen()
+			fmt.Printf("--- Usage of %s: last 24 hours ---\n", user)
+			printUsage(hours, "01-02 15:00")
+			fmt.Printf("\n--- Usage of %s: last 14 days ---\n", user)
+			printUsage(days, "2006-01-02 ")
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	waitForKey(r)
+}
+
+// printUsage shows one line per bucket with a bar scaled to the busiest one
+func printUsage(points []core.UsagePoint, layout string) {
+	const barWidth = 20
+	var peak int64
+	for _, p := range points {
+		if p.Total() > peak {
+			peak = p.Total()
+		}
+	}
+	for _, p := range points {
+		n := 0
+		if peak > 0 {
+			n = int(p.Total() * barWidth / peak)
+		}
+		fmt.Printf("%s | %-*s | up %-10s down %-10s total %s\n", p.Time.Format(layout), barWidth,
+			strings.Repeat("#", n), core.FormatBytes(float64(p.Up)), core.FormatBytes(float64(p.Down)),
+			core.FormatBytes(float64(p.Total())))
+	}
+}
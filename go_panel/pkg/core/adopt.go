@@ -0,0 +1,427 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Adoption of a hand-written Xray config.json: SyncConfig rewrites the
+// panel's part of CONFIG_XRAY from the databases, so the inbounds and
+// clients of an existing config are first read into inbounds.db /
+// clients.db. What the panel does not manage (custom sections, outbounds,
+// routing, unsupported inbounds) goes into ForeignImport.Overlay and is
+// written to CONFIG_OVERLAY_DIR. The result goes through ApplyForeignImport
+// like the other panel imports.
+
+const SourceXrayConfig = "config.json"
+
+// isXrayConfig tells an Xray config apart from a Marzban export
+func isXrayConfig(data []byte) bool {
+	var probe struct {
+		Inbounds json.RawMessage `json:"inbounds"`
+	}
+	return json.Unmarshal(data, &probe) == nil && probe.Inbounds != nil
+}
+
+// ReadXrayConfig maps an Xray config.json to inbounds and clients
+func ReadXrayConfig(path string) (*ForeignImport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fi := &ForeignImport{Source: SourceXrayConfig, ClientNotes: make(map[string][]string), Overlay: make(map[string]interface{})}
+	if err := fi.readXrayConfig(data); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return fi, nil
+}
+
+func (fi *ForeignImport) readXrayConfig(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	base := baseXrayConfig()
+
+	var sections []string
+	for name := range raw {
+		sections = append(sections, name)
+	}
+	sort.Strings(sections)
+	for _, name := range sections {
+		if !managedXraySections[name] {
+			fi.keep(name, raw[name])
+			fi.unmapped("section %q is not managed by the panel, kept in the config overlay", name)
+		}
+	}
+	// Log dan API dipakai panel (access log, stats); policy boleh ditimpa
+	for _, sec := range []struct {
+		name string
+		ours interface{}
+	}{{"log", base.Log}, {"api", base.API}, {"stats", base.Stats}, {"policy", base.Policy}} {
+		theirs, ok := raw[sec.name]
+		if !ok || sameJSON(theirs, sec.ours) {
+			continue
+		}
+		if sec.name == "policy" {
+			fi.keep(sec.name, theirs)
+			fi.unmapped("section \"policy\" differs from the panel default, kept in the config overlay")
+		} else {
+			fi.unmapped("section %q differs from the panel default and is replaced", sec.name)
+		}
+	}
+	fi.checkOutbounds(raw["outbounds"])
+	fi.checkRouting(raw["routing"])
+
+	var inbounds []json.RawMessage
+	if b, ok := raw["inbounds"]; ok {
+		if err := json.Unmarshal(b, &inbounds); err != nil {
+			return fmt.Errorf("inbounds: %v", err)
+		}
+	}
+	used := make(map[string]bool)
+	byEmail := make(map[string]int)        // email -> index di fi.Clients
+	creds := make(map[string]*adoptedCred) // email -> id / password asli
+	ports := make(map[int]bool)            // port inbound yang diambil alih panel
+	var foreign []json.RawMessage          // inbound yang tidak didukung panel
+	adopted := 0
+	for i, b := range inbounds {
+		inb, name, ok := fi.parseXrayInbound(i, b)
+		if !ok {
+			continue
+		}
+		protocol := strings.ToLower(inb.Protocol)
+		det, ok := fi.mapInbound(name, protocol, inb.StreamSettings)
+		if !ok {
+			if inb.Tag != "" && !isPanelInbound(inb.Tag) {
+				foreign = append(foreign, b)
+				fi.unmapped("%s is kept unmanaged in the config overlay, its %d client(s) are not imported", name, len(inb.Settings.Clients))
+			} else if len(inb.Settings.Clients) > 0 {
+				fi.unmapped("%s: %d client(s) not imported", name, len(inb.Settings.Clients))
+			}
+			continue
+		}
+		tag := det.Tag
+		det.Port = inb.Port
+		if fi.addInbound(name, ForeignInbound{Remark: inb.Tag, InboundDet: det}) {
+			ports[inb.Port] = true
+		}
+
+		for j, xc := range inb.Settings.Clients {
+			source := xc.Email
+			if source == "" {
+				source = fmt.Sprintf("%s-%d", tag, j+1)
+			}
+			// Email yang sama di beberapa inbound = satu client
+			if k, ok := byEmail[source]; ok && xc.Email != "" {
+				c := &fi.Clients[k]
+				if !creds[source].merge(c, protocol, xc) {
+					fi.unmapped("%s: client %s has a different credential than in an earlier inbound, not attached", name, source)
+					continue
+				}
+				c.SetInbounds(append(c.InboundTags(), tag))
+				continue
+			}
+			c := Client{
+				Username:  foreignUsername(source, used),
+				CreatedAt: time.Now(),
+				Expiry:    time.Now().AddDate(foreignNoExpiryYears, 0, 0),
+			}
+			c.SetInbounds([]string{tag})
+			if c.Username != source {
+				fi.note(c.Username, "renamed from %s", source)
+			}
+			cred := &adoptedCred{}
+			if protocol == "trojan" {
+				fi.trojanCredential(&c, xc.Password)
+				cred.password = xc.Password
+			} else if c.UUID = NormalizeUUID(xc.ID); CheckUUIDFormat(c.UUID) != nil {
+				fi.note(c.Username, "%s credential is not a UUID, a new UUID is generated", protocol)
+				c.UUID = GenerateUUID()
+			} else {
+				cred.id = xc.ID
+			}
+			c.SetStatus(StatusActive, "adopted from "+SourceXrayConfig)
+			byEmail[source] = len(fi.Clients)
+			creds[source] = cred
+			fi.Clients = append(fi.Clients, c)
+			adopted++
+		}
+	}
+	// Inbound yang portnya dipakai inbound panel tidak bisa disimpan
+	var kept []interface{}
+	for _, b := range foreign {
+		var probe struct {
+			Tag  string      `json:"tag"`
+			Port json.Number `json:"port"`
+		}
+		json.Unmarshal(b, &probe)
+		if port, err := probe.Port.Int64(); err == nil && ports[int(port)] {
+			fi.unmapped("inbound %q: port %d is taken by an adopted inbound, dropped from the config overlay", probe.Tag, port)
+			continue
+		}
+		if v, err := decodeJSON(b); err == nil {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) > 0 {
+		fi.Overlay["inbounds"] = kept
+	}
+	if adopted > 0 {
+		fi.unmapped("config.json has no quota or expiry: %d client(s) get unlimited quota and expire on %s",
+			adopted, time.Now().AddDate(foreignNoExpiryYears, 0, 0).Format("2006-01-02"))
+	}
+	return nil
+}
+
+// adoptedCred is the credential a client had in config.json: the UUID of
+// its vless/vmess inbounds and the password of its trojan inbounds
+type adoptedCred struct {
+	id       string
+	password string
+}
+
+// merge checks the credential of one more inbound of an adopted client. The
+// first trojan password and the first UUID seen are taken over, later ones
+// must match. Reports whether the inbound can be attached.
+func (ac *adoptedCred) merge(c *Client, protocol string, xc XrayClient) bool {
+	if protocol == "trojan" {
+		if ac.password != "" {
+			return xc.Password == ac.password
+		}
+		if xc.Password == "" {
+			return false
+		}
+		ac.password = xc.Password
+		if xc.Password != c.UUID {
+			c.Password = xc.Password
+		}
+		return true
+	}
+	if ac.id != "" {
+		return xc.ID == ac.id
+	}
+	// UUID sejauh ini hanya dari password trojan atau dibuat baru
+	id := NormalizeUUID(xc.ID)
+	if CheckUUIDFormat(id) != nil {
+		return false
+	}
+	if c.Password == "" && ac.password != "" {
+		c.Password = ac.password
+	}
+	c.UUID = id
+	if c.Password == c.UUID {
+		c.Password = ""
+	}
+	ac.id = xc.ID
+	return true
+}
+
+// parseXrayInbound decodes one inbound and reports the parts SyncConfig
+// does not write back
+func (fi *ForeignImport) parseXrayInbound(i int, b json.RawMessage) (Inbound, string, bool) {
+	var inb Inbound
+	name := fmt.Sprintf("inbound #%d", i+1)
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		fi.unmapped("%s: %v", name, err)
+		return inb, name, false
+	}
+	var tag, protocol string
+	json.Unmarshal(raw["tag"], &tag)
+	json.Unmarshal(raw["protocol"], &protocol)
+	if tag != "" {
+		name = fmt.Sprintf("inbound %q", tag)
+	}
+	if protocol == "dokodemo-door" && tag == "api" {
+		return inb, name, false // API inbound dibuat sendiri oleh SyncConfig
+	}
+
+	// Port boleh string di Xray ("443"), range tidak didukung
+	var portStr string
+	if json.Unmarshal(raw["port"], &portStr) == nil {
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			fi.unmapped("%s: port %q is not a single port", name, portStr)
+			return inb, name, false
+		}
+		raw["port"], _ = json.Marshal(port)
+	}
+	var port int
+	json.Unmarshal(raw["port"], &port)
+	name = fmt.Sprintf("%s (%s port %d)", name, protocol, port)
+
+	// Fallbacks bisa berupa path/socket, panel memakai fallback sendiri
+	var settings map[string]json.RawMessage
+	if json.Unmarshal(raw["settings"], &settings) == nil {
+		if _, ok := settings["fallbacks"]; ok {
+			fi.unmapped("%s: fallbacks are replaced by the panel's (port 80 on 443 only)", name)
+			delete(settings, "fallbacks")
+		}
+		for _, k := range sortedKeys(settings) {
+			switch k {
+			case "clients", "decryption", "address":
+			default:
+				fi.unmapped("%s: settings.%s is dropped", name, k)
+			}
+		}
+		raw["settings"], _ = json.Marshal(settings)
+	}
+	for _, k := range sortedKeys(raw) {
+		switch k {
+		case "tag", "port", "protocol", "settings", "streamSettings", "sniffing":
+		case "listen":
+			var listen string
+			json.Unmarshal(raw[k], &listen)
+			if listen != "" && listen != "0.0.0.0" && listen != "::" {
+				fi.unmapped("%s: listen %s becomes all addresses", name, listen)
+			}
+		default:
+			fi.unmapped("%s: %s is dropped", name, k)
+		}
+	}
+	var stream map[string]json.RawMessage
+	if json.Unmarshal(raw["streamSettings"], &stream) == nil {
+		for _, k := range sortedKeys(stream) {
+			switch k {
+			case "network", "security", "tlsSettings", "xtlsSettings", "wsSettings", "grpcSettings", "realitySettings":
+			default:
+				fi.unmapped("%s: streamSettings.%s is dropped", name, k)
+			}
+		}
+	}
+
+	fixed, _ := json.Marshal(raw)
+	if err := json.Unmarshal(fixed, &inb); err != nil {
+		fi.unmapped("%s: %v", name, err)
+		return inb, name, false
+	}
+	if tls := inb.StreamSettings.TLSSettings; tls != nil {
+		for _, cert := range tls.Certificates {
+			if cert.CertificateFile != TLS_CERT || cert.KeyFile != TLS_KEY {
+				fi.unmapped("%s: certificate %s is replaced by %s", name, cert.CertificateFile, TLS_CERT)
+			}
+		}
+	}
+	return inb, name, true
+}
+
+// checkOutbounds reports outbounds other than a plain freedom / blackhole
+func (fi *ForeignImport) checkOutbounds(b json.RawMessage) {
+	var outbounds []map[string]json.RawMessage
+	if b == nil || json.Unmarshal(b, &outbounds) != nil {
+		return
+	}
+	for i, raw := range outbounds {
+		var ob Outbound
+		json.Unmarshal(raw["protocol"], &ob.Protocol)
+		json.Unmarshal(raw["tag"], &ob.Tag)
+		plain := ob.Protocol == "freedom" || ob.Protocol == "blackhole"
+		for k, v := range raw {
+			switch k {
+			case "protocol", "tag":
+			case "settings":
+				if !sameJSON(v, map[string]interface{}{}) {
+					plain = false
+				}
+			default:
+				plain = false
+			}
+		}
+		if !plain {
+			name := ob.Tag
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			fi.keepItem("outbounds", raw)
+			fi.unmapped("outbound %s (%s) is kept in the config overlay", name, ob.Protocol)
+		}
+	}
+}
+
+// checkRouting reports routing options and rules SyncConfig does not write
+func (fi *ForeignImport) checkRouting(b json.RawMessage) {
+	var raw map[string]json.RawMessage
+	if b == nil || json.Unmarshal(b, &raw) != nil {
+		return
+	}
+	routing := make(map[string]interface{})
+	for _, k := range sortedKeys(raw) {
+		if k != "rules" {
+			if v, err := decodeJSON(raw[k]); err == nil {
+				routing[k] = v
+			}
+			fi.unmapped("routing.%s is kept in the config overlay", k)
+		}
+	}
+	var rules []json.RawMessage
+	json.Unmarshal(raw["rules"], &rules)
+	for i, rule := range rules {
+		managed := false
+		for _, ours := range baseXrayConfig().Routing.Rules {
+			if sameJSON(rule, ours) {
+				managed = true
+			}
+		}
+		if !managed {
+			var r RoutingRule
+			json.Unmarshal(rule, &r)
+			if v, err := decodeJSON(rule); err == nil {
+				rules, _ := routing["rules"].([]interface{})
+				routing["rules"] = append(rules, v)
+			}
+			fi.unmapped("routing rule #%d (to %q) is kept in the config overlay", i+1, r.OutboundTag)
+		}
+	}
+	if len(routing) > 0 {
+		fi.Overlay["routing"] = routing
+	}
+}
+
+// keep puts a section of the adopted config into the overlay
+func (fi *ForeignImport) keep(section string, raw json.RawMessage) {
+	if v, err := decodeJSON(raw); err == nil {
+		fi.Overlay[section] = v
+	}
+}
+
+// keepItem appends one element to a list section of the overlay
+func (fi *ForeignImport) keepItem(section string, raw interface{}) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return
+	}
+	if v, err := decodeJSON(data); err == nil {
+		list, _ := fi.Overlay[section].([]interface{})
+		fi.Overlay[section] = append(list, v)
+	}
+}
+
+// sameJSON compares raw JSON with a value after a round trip through
+// interface{}, so key order and omitted empty fields do not matter
+func sameJSON(raw json.RawMessage, v interface{}) bool {
+	var a, b interface{}
+	if json.Unmarshal(raw, &a) != nil {
+		return false
+	}
+	data, err := json.Marshal(v)
+	if err != nil || json.Unmarshal(data, &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
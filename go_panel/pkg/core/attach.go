@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A client can be attached to several inbounds (tags like "vless-ws").
+// Credential, quota and expiry are shared; Xray counts the traffic per email,
+// so usage over all attachments adds up to one total.
+// Protocol always holds the first attachment so old readers keep working.
+
+// InboundTags returns the inbound tags the client is attached to
+func (c Client) InboundTags() []string {
+	if len(c.Inbounds) > 0 {
+		return c.Inbounds
+	}
+	if c.Protocol == "" {
+		return nil
+	}
+	return []string{c.Protocol}
+}
+
+// AttachedTo reports whether the client belongs to the inbound tag
+func (c Client) AttachedTo(tag string) bool {
+	for _, t := range c.InboundTags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// SetInbounds replaces the attachments (duplicates and blanks are dropped)
+func (c *Client) SetInbounds(tags []string) {
+	var clean []string
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		clean = append(clean, t)
+	}
+	c.Inbounds = clean
+	c.Protocol = ""
+	if len(clean) > 0 {
+		c.Protocol = clean[0]
+	}
+}
+
+// TrojanPassword is the password of the client on trojan inbounds. Clients
+// created here use their UUID; imported ones keep the password they had.
+func (c Client) TrojanPassword() string {
+	if c.Password != "" {
+		return c.Password
+	}
+	return c.UUID
+}
+
+// CheckInboundTags verifies every tag exists in inbounds.db
+func CheckInboundTags(tags []string) error {
+	if len(tags) == 0 {
+		return fmt.Errorf("select at least one inbound")
+	}
+	inbounds, err := LoadAllInbounds()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, inb := range inbounds {
+		known[inb.Tag] = true
+	}
+	for _, t := range tags {
+		if !known[t] {
+			return fmt.Errorf("inbound %s does not exist", t)
+		}
+	}
+	return nil
+}
+
+// InboundTagList returns the distinct inbound tags in inbounds.db order
+func InboundTagList() ([]string, error) {
+	inbounds, err := LoadAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	seen := make(map[string]bool)
+	for _, inb := range inbounds {
+		if !seen[inb.Tag] {
+			seen[inb.Tag] = true
+			tags = append(tags, inb.Tag)
+		}
+	}
+	return tags, nil
+}
+
+// SetClientInbounds persists a new set of attachments for one client
+func SetClientInbounds(a Actor, username string, tags []string) error {
+	if err := CheckInboundTags(tags); err != nil {
+		return err
+	}
+	return updateClient(a, "inbounds", username, func(c *Client) {
+		c.SetInbounds(tags)
+	})
+}
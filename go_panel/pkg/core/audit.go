@@ -0,0 +1,185 @@
+package core
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+var AUDIT_LOG = "/etc/xray/audit.log"
+
+// Channel is the front-end a mutation came from
+type Channel string
+
+const (
+	ChannelWeb  Channel = "web"
+	ChannelBot  Channel = "bot"
+	ChannelCLI  Channel = "cli"
+	ChannelTask Channel = "task"
+)
+
+// Actor identifies who changed something; every mutation takes one
+type Actor struct {
+	Name    string
+	Channel Channel
+}
+
+// CLIActor is the logged in system user running the menu or a CLI mode
+func CLIActor() Actor {
+	name := os.Getenv("SUDO_USER")
+	if name == "" {
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+	}
+	return Actor{Name: name, Channel: ChannelCLI}
+}
+
+// TaskActor is a timer job such as the expiry or quota check
+func TaskActor(task string) Actor {
+	return Actor{Name: task, Channel: ChannelTask}
+}
+
+// AuditEntry is one line of the audit log (JSON lines)
+type AuditEntry struct {
+	Time    time.Time       `json:"time"`
+	Actor   string          `json:"actor"`
+	Channel Channel         `json:"channel"`
+	Action  string          `json:"action"`
+	Target  string          `json:"target"`
+	Before  json.RawMessage `json:"before,omitempty"`
+	After   json.RawMessage `json:"after,omitempty"`
+}
+
+// Audit appends an entry. The mutation already happened, so a failure to
+// write the log is only reported, never returned.
+func Audit(a Actor, action, target string, before, after interface{}) {
+	e := AuditEntry{
+		Time:    time.Now(),
+		Actor:   a.Name,
+		Channel: a.Channel,
+		Action:  action,
+		Target:  target,
+		Before:  auditValue(before),
+		After:   auditValue(after),
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("audit: %v", err)
+		return
+	}
+	// Satu write dengan O_APPEND, jadi baris dari proses lain tidak tercampur
+	f, err := os.OpenFile(AUDIT_LOG, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("audit: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+func auditValue(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	if c, ok := v.(*Client); ok {
+		if c == nil {
+			return nil
+		}
+		v = *c
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// AuditFilter selects entries; empty fields match everything
+type AuditFilter struct {
+	Actor   string
+	Channel Channel
+	Action  string
+	Target  string
+	Since   time.Time
+	Until   time.Time
+}
+
+func (f AuditFilter) match(e AuditEntry) bool {
+	switch {
+	case f.Actor != "" && e.Actor != f.Actor:
+		return false
+	case f.Channel != "" && e.Channel != f.Channel:
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case f.Target != "" && !strings.Contains(e.Target, f.Target):
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// ReadAudit returns the matching entries, oldest first
+func ReadAudit(f AuditFilter) ([]AuditEntry, error) {
+	file, err := os.Open(AUDIT_LOG)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // baris rusak (mis. disk penuh) dilewati
+		}
+		if f.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// ExportAudit writes entries as "csv" or "json" (one object per line)
+func ExportAudit(w io.Writer, entries []AuditEntry, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "actor", "channel", "action", "target", "before", "after"})
+		for _, e := range entries {
+			cw.Write([]string{
+				e.Time.Format(time.RFC3339), e.Actor, string(e.Channel),
+				e.Action, e.Target, string(e.Before), string(e.After),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
@@ -2,7 +2,9 @@ package core
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 )
 
 var ADMIN_CONFIG = "/etc/xray/web_admin.json"
@@ -28,11 +30,22 @@ func GetAdminCreds() AdminCreds {
 	return creds
 }
 
-func SaveAdminCreds(username, password string) error {
+func SaveAdminCreds(a Actor, username, password string) error {
+	if strings.TrimSpace(username) == "" || password == "" {
+		return fmt.Errorf("username and password must not be empty")
+	}
+	before := GetAdminCreds().Username
 	creds := AdminCreds{Username: username, Password: password}
 	data, err := json.Marshal(creds)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(ADMIN_CONFIG, data, 0644)
+	if err := WriteFileAtomic(ADMIN_CONFIG, data, 0600); err != nil {
+		return err
+	}
+	// Password tidak pernah masuk audit log
+	Audit(a, "admin_credentials", "web_admin",
+		map[string]string{"username": before},
+		map[string]string{"username": username, "password": "changed"})
+	return nil
 }
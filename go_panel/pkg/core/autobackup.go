@@ -0,0 +1,314 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Scheduled backups: the xray-backup timer runs "-auto-backup" daily. Each
+// run writes auto-<stamp>.tar.gz into BACKUP_DIR, optionally copies it to an
+// S3 compatible bucket, and prunes old copies on both sides with the
+// daily/weekly retention from panel.json.
+//
+// The S3 copy never contains backup_s3.json (the S3 credentials). It still
+// holds the bot token and the TLS key, so it is encrypted when a passphrase
+// is set (auto-<stamp>.tar.gz.enc, see backupcrypt.go).
+
+var BACKUP_S3 = "/etc/xray/backup_s3.json"
+var BACKUP_STATUS = "/etc/xray/backup_status.json"
+
+const autoBackupPrefix = "auto"
+
+// Batas waktu upload / list / delete ke S3 per run
+const s3Timeout = 5 * time.Minute
+
+// S3Config is the optional off-site copy target (AWS S3, MinIO, R2, ...)
+type S3Config struct {
+	Endpoint  string `json:"endpoint"` // host[:port], no scheme
+	UseSSL    bool   `json:"use_ssl"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"` // "folder" inside the bucket
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// Passphrase encrypts the uploaded copy; empty uploads it as is
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// Enabled reports whether backups are copied to S3
+func (c S3Config) Enabled() bool {
+	return c.Endpoint != "" && c.Bucket != ""
+}
+
+func (c S3Config) objectName(name string) string {
+	return path.Join(strings.Trim(c.Prefix, "/"), name)
+}
+
+// LoadS3Config returns the saved S3 target; a missing file means disabled
+func LoadS3Config() (S3Config, error) {
+	var cfg S3Config
+	data, err := os.ReadFile(BACKUP_S3)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return S3Config{}, fmt.Errorf("%s: %v", BACKUP_S3, err)
+	}
+	return cfg, nil
+}
+
+// SaveS3Config stores the S3 target. An empty endpoint disables uploads.
+func SaveS3Config(a Actor, cfg S3Config) error {
+	cfg.Endpoint = strings.TrimSpace(cfg.Endpoint)
+	if strings.Contains(cfg.Endpoint, "://") {
+		return fmt.Errorf("endpoint is host[:port] without http:// (use the SSL option)")
+	}
+	if cfg.Endpoint != "" && (cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "") {
+		return fmt.Errorf("bucket, access key and secret key are required")
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(BACKUP_S3, data, 0600); err != nil {
+		return err
+	}
+	// Secret key tidak pernah masuk audit log
+	Audit(a, "backup_s3", "backup", nil, map[string]interface{}{
+		"endpoint": cfg.Endpoint, "bucket": cfg.Bucket, "prefix": cfg.Prefix,
+		"encrypted": cfg.Passphrase != "",
+	})
+	return nil
+}
+
+func (c S3Config) client() (*minio.Client, error) {
+	return minio.New(c.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(c.AccessKey, c.SecretKey, ""),
+		Secure: c.UseSSL,
+		Region: c.Region,
+	})
+}
+
+// BackupStatus is the outcome of the last scheduled backup
+type BackupStatus struct {
+	Time        time.Time `json:"time"`
+	OK          bool      `json:"ok"`
+	File        string    `json:"file"`
+	Size        int64     `json:"size"`
+	Error       string    `json:"error,omitempty"`
+	Uploaded    bool      `json:"uploaded"` // copied to S3
+	UploadError string    `json:"upload_error,omitempty"`
+	Pruned      int       `json:"pruned"` // old copies removed (local + S3)
+}
+
+// LoadBackupStatus returns the last run; zero Time means never ran
+func LoadBackupStatus() (BackupStatus, error) {
+	var st BackupStatus
+	data, err := os.ReadFile(BACKUP_STATUS)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(data, &st)
+	return st, err
+}
+
+func saveBackupStatus(st BackupStatus) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(BACKUP_STATUS, data, 0644)
+}
+
+// RunAutoBackup makes one scheduled backup and applies the retention.
+// The status is saved even when the run fails so the dashboard shows it.
+func RunAutoBackup() (BackupStatus, error) {
+	st := BackupStatus{Time: time.Now()}
+	err := runAutoBackup(&st)
+	st.OK = err == nil && st.UploadError == ""
+	if err != nil {
+		st.Error = err.Error()
+	}
+	if serr := saveBackupStatus(st); serr != nil && err == nil {
+		err = serr
+	}
+	return st, err
+}
+
+func runAutoBackup(st *BackupStatus) error {
+	settings, err := LoadPanelSettings()
+	if err != nil {
+		return err
+	}
+	file, err := SaveBackupFile(BACKUP_DIR, autoBackupPrefix)
+	if err != nil {
+		return err
+	}
+	st.File = file
+	if fi, err := os.Stat(file); err == nil {
+		st.Size = fi.Size()
+	}
+
+	// Salinan lokal
+	local, _ := filepath.Glob(filepath.Join(BACKUP_DIR, autoBackupPrefix+"-*.tar.gz"))
+	for _, p := range expiredBackups(local, settings.BackupDailyKeep, settings.BackupWeeklyKeep) {
+		if err := os.Remove(p); err == nil {
+			st.Pruned++
+		}
+	}
+
+	cfg, err := LoadS3Config()
+	if err != nil {
+		st.UploadError = err.Error()
+		return nil
+	}
+	if cfg.Enabled() {
+		n, err := uploadBackup(cfg, file, settings)
+		st.Pruned += n
+		if err != nil {
+			st.UploadError = err.Error()
+		} else {
+			st.Uploaded = true
+		}
+	}
+	return nil
+}
+
+// uploadBackup copies a backup named after file to S3 and prunes old
+// copies there. The copy is made without the S3 credentials and is
+// encrypted when cfg has a passphrase.
+func uploadBackup(cfg S3Config, file string, settings PanelSettings) (int, error) {
+	mc, err := cfg.client()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	var buf bytes.Buffer
+	if _, err := writeBackup(&buf, "backup_s3.json"); err != nil {
+		return 0, err
+	}
+	data, name, contentType := buf.Bytes(), filepath.Base(file), "application/gzip"
+	if cfg.Passphrase != "" {
+		if data, err = EncryptBackup(data, cfg.Passphrase); err != nil {
+			return 0, err
+		}
+		name += encryptedBackupExt
+		contentType = "application/octet-stream"
+	}
+	_, err = mc.PutObject(ctx, cfg.Bucket, cfg.objectName(name), bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return 0, fmt.Errorf("upload to %s/%s: %v", cfg.Endpoint, cfg.Bucket, err)
+	}
+
+	prefix := cfg.objectName(autoBackupPrefix + "-")
+	var remote []string
+	for obj := range mc.ListObjects(ctx, cfg.Bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return 0, fmt.Errorf("list %s: %v", cfg.Bucket, obj.Err)
+		}
+		remote = append(remote, obj.Key)
+	}
+	pruned := 0
+	for _, key := range expiredBackups(remote, settings.BackupDailyKeep, settings.BackupWeeklyKeep) {
+		if err := mc.RemoveObject(ctx, cfg.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			return pruned, fmt.Errorf("remove %s: %v", key, err)
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// backupTime reads the timestamp and the -N suffix SaveBackupFile adds for
+// a second copy in the same second from an auto-<stamp>[-N].tar.gz[.enc] name
+func backupTime(name string) (time.Time, int, bool) {
+	const layout = "20060102-150405"
+	base := strings.TrimSuffix(strings.TrimSuffix(path.Base(name), encryptedBackupExt), ".tar.gz")
+	stamp, ok := strings.CutPrefix(base, autoBackupPrefix+"-")
+	if !ok || len(stamp) < len(layout) {
+		return time.Time{}, 0, false
+	}
+	t, err := time.ParseInLocation(layout, stamp[:len(layout)], time.Local)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	seq := 1
+	if rest := stamp[len(layout):]; rest != "" {
+		if seq, err = strconv.Atoi(strings.TrimPrefix(rest, "-")); err != nil {
+			return time.Time{}, 0, false
+		}
+	}
+	return t, seq, true
+}
+
+// expiredBackups returns the names the retention does not keep: the newest
+// copy of each of the last `daily` days and of each of the last `weekly`
+// ISO weeks survive. Names that are not scheduled backups are never returned.
+func expiredBackups(names []string, daily, weekly int) []string {
+	if daily+weekly <= 0 {
+		return nil
+	}
+	type entry struct {
+		name string
+		at   time.Time
+		seq  int
+	}
+	var entries []entry
+	for _, n := range names {
+		if t, seq, ok := backupTime(n); ok {
+			entries = append(entries, entry{n, t, seq})
+		}
+	}
+	// Terbaru dulu
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].at.Equal(entries[j].at) {
+			return entries[i].at.After(entries[j].at)
+		}
+		return entries[i].seq > entries[j].seq
+	})
+
+	keep := make(map[string]bool)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for _, e := range entries {
+		day := e.at.Format("2006-01-02")
+		if !days[day] && len(days) < daily {
+			days[day] = true
+			keep[e.name] = true
+		}
+		y, w := e.at.ISOWeek()
+		week := fmt.Sprintf("%d-%02d", y, w)
+		if !weeks[week] && len(weeks) < weekly {
+			weeks[week] = true
+			keep[e.name] = true
+		}
+	}
+
+	var expired []string
+	for _, e := range entries {
+		if !keep[e.name] {
+			expired = append(expired, e.name)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,369 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestExpiredBackupsRetention(t *testing.T) {
+	// Senin 2024-01-01 adalah hari pertama minggu ISO 2024-W01
+	names := []string{
+		"auto-20240108-020000.tar.gz",   // Senin W02
+		"auto-20240107-235959.tar.gz",   // Minggu W01, terbaru di minggu itu
+		"auto-20240107-020000.tar.gz",   // Minggu W01, kalah dari 23:59:59
+		"auto-20240106-020000.tar.gz",   // Sabtu W01
+		"auto-20240101-000000.tar.gz",   // Senin W01
+		"auto-20231231-235959.tar.gz",   // Minggu 2023-W52
+		"auto-20231225-020000.tar.gz",   // Senin 2023-W52
+		"auto-20231218-020000.tar.gz",   // 2023-W51
+		"backups/auto-20240105.tar.gz",  // bukan nama backup terjadwal
+		"manual-20240101-000000.tar.gz", // prefix lain
+	}
+	got := expiredBackups(names, 2, 2)
+	sort.Strings(got)
+	// Harian: 01-08, 01-07 23:59:59. Mingguan: W02 (01-08), W01 (01-07 23:59:59)
+	want := []string{
+		"auto-20231218-020000.tar.gz",
+		"auto-20231225-020000.tar.gz",
+		"auto-20231231-235959.tar.gz",
+		"auto-20240101-000000.tar.gz",
+		"auto-20240106-020000.tar.gz",
+		"auto-20240107-020000.tar.gz",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expired =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Minggu ke-3 menjangkau 2023-W52 lewat batas tahun
+	got = expiredBackups(names, 0, 3)
+	for _, n := range got {
+		if n == "auto-20231231-235959.tar.gz" {
+			t.Errorf("newest copy of 2023-W52 expired: %v", got)
+		}
+	}
+	if len(got) != 5 {
+		t.Errorf("weekly 3: expired %v", got)
+	}
+
+	if got := expiredBackups(names, 0, 0); got != nil {
+		t.Errorf("no retention configured must keep everything, got %v", got)
+	}
+}
+
+func TestExpiredBackupsSameSecond(t *testing.T) {
+	names := []string{
+		"auto-20240301-020000.tar.gz",
+		"auto-20240301-020000-2.tar.gz",
+		"auto-20240301-020000-3.tar.gz.enc",
+		"auto-20240301-020000-x.tar.gz",
+	}
+	got := expiredBackups(names, 1, 0)
+	sort.Strings(got)
+	want := []string{"auto-20240301-020000-2.tar.gz", "auto-20240301-020000.tar.gz"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expired = %v, want %v", got, want)
+	}
+}
+
+func TestEncryptBackupRoundTrip(t *testing.T) {
+	data := []byte("archive")
+	enc, err := EncryptBackup(data, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedBackup(enc) || bytes.Contains(enc, data) {
+		t.Fatal("archive not encrypted")
+	}
+	if _, err := DecryptBackup(enc, "wrong"); err == nil {
+		t.Error("wrong passphrase accepted")
+	}
+	enc[len(enc)-1] ^= 1
+	if _, err := DecryptBackup(enc, "correct horse"); err == nil {
+		t.Error("damaged archive accepted")
+	}
+	enc[len(enc)-1] ^= 1
+	plain, err := DecryptBackup(enc, "correct horse")
+	if err != nil || !bytes.Equal(plain, data) {
+		t.Errorf("decrypt = %q, %v", plain, err)
+	}
+	if got, err := OpenBackupData(data, ""); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("plain archive changed: %q, %v", got, err)
+	}
+}
+
+// setupBackupPaths points every backup item at a temp dir with a few files
+func setupBackupPaths(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	vars := map[*string]string{
+		&DB_CLIENTS: "clients.db", &DB_INBOUNDS: "inbounds.db", &CONFIG_XRAY: "config.json",
+		&ADMIN_CONFIG: "web_admin.json", &CONFIG_BOT: "bot.json", &DOMAIN_FILE: "domain",
+		&TLS_CERT: "xray.crt", &TLS_KEY: "xray.key", &CONFIG_PLANS: "plans.json",
+		&CONFIG_PANEL: "panel.json", &BACKUP_S3: "backup_s3.json", &DB_TRASH: "trash.json",
+		&DB_USAGE: "usage.db", &CONFIG_OVERLAY_DIR: "config.d", &BACKUP_DIR: "backups",
+		&BACKUP_STATUS: "backup_status.json", &AUDIT_LOG: "audit.log",
+	}
+	for v, name := range vars {
+		old := *v
+		*v = filepath.Join(dir, name)
+		t.Cleanup(func() { *v = old })
+	}
+	oldStore := activeStore
+	activeStore = &FileStore{}
+	t.Cleanup(func() { activeStore = oldStore })
+
+	for name, data := range map[string]string{
+		"domain":         "vpn.example.com\n",
+		"bot.json":       `{"token":"bot-token"}`,
+		"backup_s3.json": `{"secret_key":"s3-secret"}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := Client{Username: "alice", UUID: GenerateUUID(), Expiry: time.Now().AddDate(1, 0, 0)}
+	if err := activeStore.SaveClient(c); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// fakeS3 implements the PUT / ListObjectsV2 / DELETE calls uploadBackup makes
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPut && key != "":
+		data, err := readS3Body(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		w.Header().Set("ETag", `"fake"`)
+	case r.Method == http.MethodGet && key != "":
+		data, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Write(data)
+	case r.Method == http.MethodDelete && key != "":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		type object struct {
+			Key          string
+			Size         int
+			LastModified string
+			ETag         string
+		}
+		res := struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Name        string
+			Prefix      string
+			KeyCount    int
+			MaxKeys     int
+			IsTruncated bool
+			Contents    []object
+		}{Name: f.bucket, Prefix: r.URL.Query().Get("prefix"), MaxKeys: 1000}
+		for k, v := range f.objects {
+			if strings.HasPrefix(k, res.Prefix) {
+				res.Contents = append(res.Contents, object{k, len(v), time.Now().UTC().Format(time.RFC3339), `"fake"`})
+			}
+		}
+		res.KeyCount = len(res.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(res)
+	default:
+		http.Error(w, "NotImplemented "+r.Method+" "+r.URL.String(), http.StatusNotImplemented)
+	}
+}
+
+// readS3Body undoes the aws-chunked encoding minio-go uses over plain HTTP
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var out []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("chunk size %q: %v", line, err)
+		}
+		if size == 0 {
+			return out, nil
+		}
+		chunk := make([]byte, size+2) // + CRLF
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		out = append(out, chunk[:size]...)
+	}
+}
+
+// testS3Config returns a MinIO target from MINIO_ENDPOINT, MINIO_ACCESS_KEY,
+// MINIO_SECRET_KEY and MINIO_BUCKET (an existing bucket), or an in-process
+// fake when MINIO_ENDPOINT is not set. Each call uses its own prefix.
+func testS3Config(t *testing.T) S3Config {
+	t.Helper()
+	cfg := S3Config{Region: "us-east-1", Prefix: "panel-test/" + strconv.FormatInt(time.Now().UnixNano(), 36)}
+	if ep := os.Getenv("MINIO_ENDPOINT"); ep != "" {
+		cfg.Endpoint, cfg.Bucket = ep, os.Getenv("MINIO_BUCKET")
+		cfg.AccessKey, cfg.SecretKey = os.Getenv("MINIO_ACCESS_KEY"), os.Getenv("MINIO_SECRET_KEY")
+		return cfg
+	}
+	f := &fakeS3{bucket: "backups", objects: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	cfg.Endpoint = strings.TrimPrefix(srv.URL, "http://")
+	cfg.Bucket, cfg.AccessKey, cfg.SecretKey = f.bucket, "access", "secret"
+	return cfg
+}
+
+func listS3(t *testing.T, cfg S3Config) []string {
+	t.Helper()
+	mc, err := cfg.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for obj := range mc.ListObjects(context.Background(), cfg.Bucket, minio.ListObjectsOptions{Prefix: cfg.objectName("")}) {
+		if obj.Err != nil {
+			t.Fatal(obj.Err)
+		}
+		keys = append(keys, path.Base(obj.Key))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func putS3(t *testing.T, cfg S3Config, name string) {
+	t.Helper()
+	mc, err := cfg.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.PutObject(context.Background(), cfg.Bucket, cfg.objectName(name), strings.NewReader("old"), 3, minio.PutObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getS3(t *testing.T, cfg S3Config, name string) []byte {
+	t.Helper()
+	mc, err := cfg.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := mc.GetObject(context.Background(), cfg.Bucket, cfg.objectName(name), minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUploadBackupPrunesAndKeepsS3SecretOut(t *testing.T) {
+	setupBackupPaths(t)
+	cfg := testS3Config(t)
+	putS3(t, cfg, "auto-20200101-020000.tar.gz")
+	putS3(t, cfg, "auto-20200102-020000.tar.gz")
+	putS3(t, cfg, "notes.txt")
+
+	file := filepath.Join(BACKUP_DIR, "auto-"+time.Now().Format("20060102-150405")+".tar.gz")
+	pruned, err := uploadBackup(cfg, file, PanelSettings{BackupDailyKeep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Errorf("pruned = %d, want 1", pruned)
+	}
+	want := []string{"auto-20200102-020000.tar.gz", filepath.Base(file), "notes.txt"}
+	if got := listS3(t, cfg); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("objects = %v, want %v", got, want)
+	}
+
+	b, err := ReadBackup(bytes.NewReader(getS3(t, cfg, filepath.Base(file))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.files["backup_s3.json"]; ok {
+		t.Error("S3 credentials uploaded")
+	}
+	if _, ok := b.files["bot.json"]; !ok || b.Manifest.Clients != 1 {
+		t.Errorf("uploaded backup incomplete: %+v", b.Manifest)
+	}
+}
+
+func TestUploadBackupEncrypted(t *testing.T) {
+	setupBackupPaths(t)
+	cfg := testS3Config(t)
+	cfg.Passphrase = "correct horse"
+
+	if _, err := uploadBackup(cfg, filepath.Join(BACKUP_DIR, "auto-20240301-020000.tar.gz"), PanelSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	name := "auto-20240301-020000.tar.gz" + encryptedBackupExt
+	if got := listS3(t, cfg); len(got) != 1 || got[0] != name {
+		t.Fatalf("objects = %v, want [%s]", got, name)
+	}
+	data := getS3(t, cfg, name)
+	if bytes.Contains(data, []byte("bot-token")) || !IsEncryptedBackup(data) {
+		t.Fatal("upload is not encrypted")
+	}
+	token, b, err := StagePendingRestore(data, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Manifest.Clients != 1 {
+		t.Errorf("manifest = %+v", b.Manifest)
+	}
+	if _, err := TakePendingRestore(token); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := StagePendingRestore(data, "wrong"); err == nil {
+		t.Error("wrong passphrase accepted")
+	}
+}
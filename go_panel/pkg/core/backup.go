@@ -0,0 +1,767 @@
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Backup archive (tar.gz):
+//
+//	manifest.json  version, host, counts and name/path/size/sha256 of each file
+//	SHA256SUMS     sha256sum compatible list of manifest.json and every file
+//	files/<name>   clients.db, inbounds.db, config.json, web_admin.json, ...
+//	               trash.json, usage.db and config.d/<fragment>.json for
+//	               every config overlay
+//
+// clients.db and inbounds.db are rendered from the active store, so a
+// backup taken with the bolt store restores into the file store and back.
+// Unparsable lines are kept in both and restored as they are.
+// audit.log is not part of a backup: it stays on the server and also
+// records the restore itself.
+const BackupVersion = 1
+
+var BACKUP_DIR = "/etc/xray/backups"
+
+// Batas ukuran archive yang dibaca saat restore
+const backupMaxBytes = 64 << 20
+
+const (
+	backupManifest = "manifest.json"
+	backupSums     = "SHA256SUMS"
+	backupFilesDir = "files/"
+	backupOverlay  = "config.d/"
+)
+
+// BackupFile describes one file inside the archive
+type BackupFile struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"` // where it lives on the server that made the backup
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupManifest is manifest.json
+type BackupManifest struct {
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"created_at"`
+	Hostname  string       `json:"hostname"`
+	Clients   int          `json:"clients"`
+	Inbounds  int          `json:"inbounds"`
+	Files     []BackupFile `json:"files"`
+}
+
+// Backup is a read and validated archive
+type Backup struct {
+	Manifest BackupManifest
+	files    map[string][]byte
+	clients  []Client
+	inbounds []InboundDet
+
+	badClients  []BadLine
+	badInbounds []BadLine
+}
+
+type backupItem struct {
+	name string
+	path string
+	perm os.FileMode
+}
+
+// backupItems lists what goes into a backup; paths are read at call time
+// because main may change them with flags
+func backupItems() []backupItem {
+	items := []backupItem{
+		{"clients.db", DB_CLIENTS, 0644},
+		{"inbounds.db", DB_INBOUNDS, inboundsPerm},
+		{"config.json", CONFIG_XRAY, 0644},
+		{"web_admin.json", ADMIN_CONFIG, 0600},
+		{"bot.json", CONFIG_BOT, 0600},
+		{"domain", DOMAIN_FILE, 0644},
+		{"xray.crt", TLS_CERT, 0644},
+		{"xray.key", TLS_KEY, 0600},
+		{"plans.json", CONFIG_PLANS, 0644},
+		{"panel.json", CONFIG_PANEL, 0644},
+		{"backup_s3.json", BACKUP_S3, 0600},
+		{"trash.json", DB_TRASH, 0600},
+		{"usage.db", DB_USAGE, 0600},
+	}
+	paths, _ := filepath.Glob(filepath.Join(CONFIG_OVERLAY_DIR, "*.json"))
+	sort.Strings(paths)
+	for _, path := range paths {
+		items = append(items, overlayBackupItem(filepath.Base(path)))
+	}
+	return items
+}
+
+func overlayBackupItem(file string) backupItem {
+	return backupItem{backupOverlay + file, filepath.Join(CONFIG_OVERLAY_DIR, file), 0644}
+}
+
+func findBackupItem(name string) (backupItem, bool) {
+	for _, it := range backupItems() {
+		if it.name == name {
+			return it, true
+		}
+	}
+	// Fragment overlay yang belum ada di server ini
+	if file, ok := strings.CutPrefix(name, backupOverlay); ok &&
+		file == filepath.Base(file) && !strings.HasPrefix(file, ".") && strings.HasSuffix(file, ".json") {
+		return overlayBackupItem(file), true
+	}
+	return backupItem{}, false
+}
+
+// items is backupItems plus the overlay fragments only the archive has
+func (b *Backup) items() []backupItem {
+	items := backupItems()
+	known := make(map[string]bool)
+	for _, it := range items {
+		known[it.name] = true
+	}
+	for _, f := range b.Manifest.Files {
+		if !known[f.Name] {
+			if it, ok := findBackupItem(f.Name); ok {
+				items = append(items, it)
+			}
+		}
+	}
+	return items
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// storeFiles renders the clients and inbounds of the active store in the
+// flat file formats. The file store is copied as is and the bolt store
+// adds the lines it carried over, so unparsable lines are kept.
+func storeFiles() (clients, inbounds []byte, nc, ni int, err error) {
+	cl, err := LoadClients()
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+	inb, err := LoadAllInbounds()
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+	if _, ok := activeStore.(*FileStore); ok {
+		clients, err = readOptional(DB_CLIENTS)
+		if err == nil {
+			inbounds, err = readOptional(DB_INBOUNDS)
+		}
+		return clients, inbounds, len(cl), len(inb), err
+	}
+	cf := &clientsFile{Version: ClientsDBVersion, Clients: cl}
+	inf := &inboundsFile{Inbounds: inb}
+	if st, ok := activeStore.(*BoltStore); ok {
+		if cf.Bad, inf.Bad, err = st.BadLines(); err != nil {
+			return nil, nil, 0, 0, err
+		}
+	}
+	return cf.encode(), inf.encode(), len(cl), len(inb), nil
+}
+
+// readOptional returns nil for a missing file
+func readOptional(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// currentFiles returns the live content of every backup item (missing = absent)
+func currentFiles() (map[string][]byte, int, int, error) {
+	files := make(map[string][]byte)
+	clients, inbounds, nc, ni, err := storeFiles()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	files["clients.db"] = clients
+	files["inbounds.db"] = inbounds
+	for _, it := range backupItems() {
+		if _, ok := files[it.name]; ok {
+			continue
+		}
+		read := readOptional
+		if it.name == "usage.db" {
+			read = func(string) ([]byte, error) { return readUsageDB() }
+		}
+		data, err := read(it.path)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if data != nil {
+			files[it.name] = data
+		}
+	}
+	return files, nc, ni, nil
+}
+
+// WriteBackup writes a backup archive of the current panel state to w
+func WriteBackup(w io.Writer) (BackupManifest, error) {
+	return writeBackup(w)
+}
+
+// writeBackup is WriteBackup without the named items
+func writeBackup(w io.Writer, exclude ...string) (BackupManifest, error) {
+	files, nc, ni, err := currentFiles()
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	for _, name := range exclude {
+		delete(files, name)
+	}
+	m := BackupManifest{
+		Version:   BackupVersion,
+		CreatedAt: time.Now(),
+		Hostname:  GetHostname(),
+		Clients:   nc,
+		Inbounds:  ni,
+	}
+	for _, it := range backupItems() {
+		data, ok := files[it.name]
+		if !ok {
+			continue
+		}
+		m.Files = append(m.Files, BackupFile{Name: it.name, Path: it.path, Size: int64(len(data)), SHA256: sha256Hex(data)})
+	}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, err
+	}
+
+	var sums strings.Builder
+	fmt.Fprintf(&sums, "%s  %s\n", sha256Hex(manifest), backupManifest)
+	for _, f := range m.Files {
+		fmt.Fprintf(&sums, "%s  %s%s\n", f.SHA256, backupFilesDir, f.Name)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte, perm os.FileMode) error {
+		hdr := &tar.Header{Name: name, Mode: int64(perm), Size: int64(len(data)), ModTime: m.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(backupManifest, manifest, 0644); err != nil {
+		return m, err
+	}
+	if err := add(backupSums, []byte(sums.String()), 0644); err != nil {
+		return m, err
+	}
+	for _, it := range backupItems() {
+		if data, ok := files[it.name]; ok {
+			if err := add(backupFilesDir+it.name, data, it.perm); err != nil {
+				return m, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return m, err
+	}
+	return m, gz.Close()
+}
+
+// SaveBackupFile writes a backup archive into dir and returns its path
+func SaveBackupFile(dir, prefix string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if _, err := WriteBackup(&buf); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s", prefix, time.Now().Format("20060102-150405"))
+	path := filepath.Join(dir, name+".tar.gz")
+	// Jangan timpa backup lain dari detik yang sama
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d.tar.gz", name, i))
+	}
+	return path, WriteFileAtomic(path, buf.Bytes(), 0600)
+}
+
+// ReadBackup reads an archive and validates checksums and every file.
+// Nothing on disk is touched.
+func ReadBackup(r io.Reader) (*Backup, error) {
+	gz, err := gzip.NewReader(io.LimitReader(r, backupMaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %v", err)
+	}
+	raw := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("archive is damaged: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry %s in archive", hdr.Name)
+		}
+		if total += hdr.Size; total > backupMaxBytes {
+			return nil, fmt.Errorf("archive is larger than %d MB", backupMaxBytes>>20)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("archive is damaged: %v", err)
+		}
+		if _, dup := raw[hdr.Name]; dup {
+			return nil, fmt.Errorf("duplicate entry %s in archive", hdr.Name)
+		}
+		raw[hdr.Name] = data
+	}
+
+	b := &Backup{files: make(map[string][]byte)}
+	manifest, ok := raw[backupManifest]
+	if !ok {
+		return nil, fmt.Errorf("archive has no %s", backupManifest)
+	}
+	if err := json.Unmarshal(manifest, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("%s: %v", backupManifest, err)
+	}
+	if b.Manifest.Version < 1 || b.Manifest.Version > BackupVersion {
+		return nil, fmt.Errorf("backup version %d is not supported (max %d)", b.Manifest.Version, BackupVersion)
+	}
+
+	// Semua entry (kecuali SHA256SUMS sendiri) harus tercatat dan cocok
+	sums, ok := raw[backupSums]
+	if !ok {
+		return nil, fmt.Errorf("archive has no %s", backupSums)
+	}
+	listed := make(map[string]bool)
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		sum, name, ok := strings.Cut(sc.Text(), "  ")
+		if !ok {
+			continue
+		}
+		data, exists := raw[name]
+		if !exists {
+			return nil, fmt.Errorf("%s is listed in %s but missing", name, backupSums)
+		}
+		if sha256Hex(data) != sum {
+			return nil, fmt.Errorf("checksum mismatch for %s", name)
+		}
+		listed[name] = true
+	}
+	for name := range raw {
+		if name != backupSums && !listed[name] {
+			return nil, fmt.Errorf("%s is not covered by %s", name, backupSums)
+		}
+	}
+
+	for _, f := range b.Manifest.Files {
+		if _, known := findBackupItem(f.Name); !known {
+			return nil, fmt.Errorf("unknown file %s in manifest", f.Name)
+		}
+		data, ok := raw[backupFilesDir+f.Name]
+		if !ok || sha256Hex(data) != f.SHA256 {
+			return nil, fmt.Errorf("%s does not match the manifest", f.Name)
+		}
+		b.files[f.Name] = data
+	}
+	if len(b.files) != len(raw)-2 {
+		return nil, fmt.Errorf("archive contains files that are not in the manifest")
+	}
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// validate parses every file so a broken backup is rejected before restore.
+// Unparsable clients.db / inbounds.db lines are accepted: the panel keeps
+// them too (see -db-check), so they were already there when the backup was
+// made and a pre-restore backup must stay restorable.
+func (b *Backup) validate() error {
+	if data, ok := b.files["clients.db"]; ok {
+		cf, err := parseClientsFile(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("clients.db: %v", err)
+		}
+		b.clients, b.badClients = cf.Clients, cf.Bad
+	}
+	if data, ok := b.files["inbounds.db"]; ok {
+		inf, err := parseInboundsFile(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("inbounds.db: %v", err)
+		}
+		b.inbounds, b.badInbounds = inf.Inbounds, inf.Bad
+	}
+	if data, ok := b.files["config.json"]; ok {
+		var cfg XrayConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("config.json: %v", err)
+		}
+	}
+	for _, name := range []string{"web_admin.json", "bot.json", "plans.json", "panel.json", "backup_s3.json", "trash.json"} {
+		if data, ok := b.files[name]; ok && !json.Valid(data) {
+			return fmt.Errorf("%s is not valid JSON", name)
+		}
+	}
+	if data, ok := b.files["usage.db"]; ok {
+		if err := checkUsageDB(data); err != nil {
+			return fmt.Errorf("usage.db: %v", err)
+		}
+	}
+	for name, data := range b.files {
+		if !strings.HasPrefix(name, backupOverlay) {
+			continue
+		}
+		if v, err := decodeJSON(data); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		} else if _, ok := v.(map[string]interface{}); !ok {
+			return fmt.Errorf("%s: not a JSON object", name)
+		}
+	}
+	cert, hasCert := b.files["xray.crt"]
+	key, hasKey := b.files["xray.key"]
+	if hasCert != hasKey {
+		return fmt.Errorf("TLS certificate and key must be restored together")
+	}
+	if hasCert {
+		if _, err := tls.X509KeyPair(cert, key); err != nil {
+			return fmt.Errorf("TLS certificate/key: %v", err)
+		}
+	}
+	return nil
+}
+
+// BackupChange is one line of the restore preview
+type BackupChange struct {
+	Name    string
+	Status  string // unchanged, changed, new (not on this server), kept (not in backup)
+	Details []string
+}
+
+// Diff compares the backup with the live state
+func (b *Backup) Diff() ([]BackupChange, error) {
+	cur, _, _, err := currentFiles()
+	if err != nil {
+		return nil, err
+	}
+	var changes []BackupChange
+	for _, it := range b.items() {
+		data, inBackup := b.files[it.name]
+		live, onServer := cur[it.name]
+		ch := BackupChange{Name: it.name}
+		switch {
+		case !inBackup:
+			ch.Status = "kept"
+		case !onServer:
+			ch.Status = "new"
+		case bytes.Equal(data, live):
+			ch.Status = "unchanged"
+		default:
+			ch.Status = "changed"
+		}
+		if inBackup && ch.Status != "unchanged" {
+			switch it.name {
+			case "clients.db":
+				cl, err := LoadClients()
+				if err != nil {
+					return nil, err
+				}
+				ch.Details = append(diffClients(cl, b.clients), badLineDetails(b.badClients)...)
+			case "inbounds.db":
+				inb, err := LoadAllInbounds()
+				if err != nil {
+					return nil, err
+				}
+				ch.Details = append(diffInbounds(inb, b.inbounds), badLineDetails(b.badInbounds)...)
+			}
+		}
+		changes = append(changes, ch)
+	}
+	return changes, nil
+}
+
+func badLineDetails(bad []BadLine) []string {
+	var lines []string
+	for _, bl := range bad {
+		lines = append(lines, fmt.Sprintf("! unparsable %v, restored as is (see -db-check)", bl))
+	}
+	return lines
+}
+
+func diffClients(cur, next []Client) []string {
+	old := make(map[string]Client)
+	for _, c := range cur {
+		old[c.Username] = c
+	}
+	var lines []string
+	seen := make(map[string]bool)
+	for _, c := range next {
+		seen[c.Username] = true
+		o, ok := old[c.Username]
+		if !ok {
+			lines = append(lines, "+ "+c.Username)
+			continue
+		}
+		// Dibandingkan dalam format clients.db supaya presisi waktu bolt tidak dianggap beda
+		ov, nv := clientValues(o), clientValues(c)
+		var fields []string
+		for _, col := range clientColumns[1:] {
+			if ov[col] == nv[col] {
+				continue
+			}
+			switch col {
+			case "quota", "expiry", "status", "plan", "inbounds":
+				fields = append(fields, fmt.Sprintf("%s %s → %s", col, ov[col], nv[col]))
+			case "used":
+				fields = append(fields, fmt.Sprintf("used %s → %s", FormatBytes(o.Used), FormatBytes(c.Used)))
+			default:
+				fields = append(fields, col)
+			}
+		}
+		if len(fields) > 0 {
+			lines = append(lines, "~ "+c.Username+": "+strings.Join(fields, ", "))
+		}
+	}
+	for _, c := range cur {
+		if !seen[c.Username] {
+			lines = append(lines, "- "+c.Username)
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i][0] < lines[j][0] })
+	return lines
+}
+
+func diffInbounds(cur, next []InboundDet) []string {
+	key := func(inb InboundDet) string { return fmt.Sprintf("%s:%d", inb.Tag, inb.Port) }
+	old := make(map[string]bool)
+	for _, inb := range cur {
+		old[key(inb)] = true
+	}
+	var lines []string
+	seen := make(map[string]bool)
+	for _, inb := range next {
+		seen[key(inb)] = true
+		if !old[key(inb)] {
+			lines = append(lines, "+ "+key(inb))
+		}
+	}
+	for _, inb := range cur {
+		if !seen[key(inb)] {
+			lines = append(lines, "- "+key(inb))
+		}
+	}
+	return lines
+}
+
+// RestoreBackup replaces the live state with the backup. A backup of the
+// current state is written to BACKUP_DIR first; if any step fails that
+// backup is put back so the panel is never left half restored.
+// The caller syncs and restarts Xray afterwards.
+func RestoreBackup(a Actor, b *Backup) (string, error) {
+	changes, err := b.Diff()
+	if err != nil {
+		return "", err
+	}
+	pre, err := SaveBackupFile(BACKUP_DIR, "pre-restore")
+	if err != nil {
+		return "", fmt.Errorf("could not save the current state, nothing changed: %v", err)
+	}
+
+	if err := b.apply(); err != nil {
+		f, oerr := os.Open(pre)
+		if oerr == nil {
+			defer f.Close()
+			var old *Backup
+			if old, oerr = ReadBackup(f); oerr == nil {
+				oerr = old.apply()
+			}
+		}
+		if oerr != nil {
+			log.Printf("restore: rollback from %s failed: %v", pre, oerr)
+			return pre, fmt.Errorf("restore failed (%v) and rollback failed (%v); previous state is in %s", err, oerr, pre)
+		}
+		return pre, fmt.Errorf("restore failed, previous state put back: %v", err)
+	}
+
+	var summary []string
+	for _, ch := range changes {
+		if ch.Status == "changed" || ch.Status == "new" {
+			summary = append(summary, ch.Name)
+		}
+	}
+	Audit(a, "backup_restore", b.Manifest.Hostname, map[string]string{"pre_restore": pre},
+		map[string]interface{}{"created_at": b.Manifest.CreatedAt, "files": summary})
+	return pre, nil
+}
+
+// apply writes every file of the backup; the stores are swapped as a whole
+func (b *Backup) apply() error {
+	if err := b.applyStore(); err != nil {
+		return err
+	}
+	for _, it := range b.items() {
+		if it.name == "clients.db" || it.name == "inbounds.db" {
+			continue
+		}
+		data, ok := b.files[it.name]
+		if !ok {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(it.path), 0755); err != nil {
+			return err
+		}
+		err := withFileLock(it.path, func() error {
+			return WriteFileAtomic(it.path, data, it.perm)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", it.name, err)
+		}
+	}
+	return nil
+}
+
+func (b *Backup) applyStore() error {
+	clients, hasClients := b.files["clients.db"]
+	inbounds, hasInbounds := b.files["inbounds.db"]
+	if !hasClients && !hasInbounds {
+		return nil
+	}
+	switch st := activeStore.(type) {
+	case *FileStore:
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		for _, path := range []string{DB_CLIENTS, DB_INBOUNDS} {
+			unlock, err := flockPath(path)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+		}
+		if hasClients {
+			if err := WriteFileAtomic(DB_CLIENTS, clients, 0644); err != nil {
+				return err
+			}
+		}
+		if hasInbounds {
+			return WriteFileAtomic(DB_INBOUNDS, inbounds, inboundsPerm)
+		}
+		return nil
+	case *BoltStore:
+		// Bagian yang tidak ada di backup tetap seperti sekarang
+		cl, inb := b.clients, b.inbounds
+		badCl, badInb := b.badClients, b.badInbounds
+		var err error
+		if !hasClients {
+			cl, err = st.LoadClients()
+		} else if !hasInbounds {
+			inb, err = st.LoadAllInbounds()
+		}
+		if err != nil {
+			return err
+		}
+		curBadCl, curBadInb, err := st.BadLines()
+		if err != nil {
+			return err
+		}
+		if !hasClients {
+			badCl = curBadCl
+		}
+		if !hasInbounds {
+			badInb = curBadInb
+		}
+		return st.update(func(tx *bolt.Tx) error {
+			if err := putBadLines(tx, badCl, badInb); err != nil {
+				return err
+			}
+			return st.replaceAll(tx, cl, inb)
+		})
+	}
+	return fmt.Errorf("restore is not supported for %T", activeStore)
+}
+
+// Upload web yang menunggu konfirmasi disimpan di BACKUP_DIR dengan token acak
+const pendingRestoreTTL = time.Hour
+
+func pendingRestorePath(token string) (string, error) {
+	if len(token) != 32 {
+		return "", fmt.Errorf("invalid restore token")
+	}
+	if _, err := hex.DecodeString(token); err != nil {
+		return "", fmt.Errorf("invalid restore token")
+	}
+	return filepath.Join(BACKUP_DIR, "pending-"+token+".tar.gz"), nil
+}
+
+// StagePendingRestore validates an uploaded archive and keeps it until the
+// admin confirms the restore. Returns the token and the parsed backup.
+// Encrypted archives are decrypted first (see OpenBackupData).
+func StagePendingRestore(data []byte, passphrase string) (string, *Backup, error) {
+	data, err := OpenBackupData(data, passphrase)
+	if err != nil {
+		return "", nil, err
+	}
+	b, err := ReadBackup(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(BACKUP_DIR, 0700); err != nil {
+		return "", nil, err
+	}
+	// Upload lama yang tidak pernah dikonfirmasi dibuang
+	old, _ := filepath.Glob(filepath.Join(BACKUP_DIR, "pending-*.tar.gz"))
+	for _, p := range old {
+		if st, err := os.Stat(p); err == nil && time.Since(st.ModTime()) > pendingRestoreTTL {
+			os.Remove(p)
+		}
+	}
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	token := hex.EncodeToString(raw)
+	path, _ := pendingRestorePath(token)
+	if err := WriteFileAtomic(path, data, 0600); err != nil {
+		return "", nil, err
+	}
+	return token, b, nil
+}
+
+// TakePendingRestore reads and removes a staged upload
+func TakePendingRestore(token string) (*Backup, error) {
+	path, err := pendingRestorePath(token)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("the uploaded backup expired, upload it again")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+	defer f.Close()
+	return ReadBackup(f)
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted backup archive, used for the S3 copy when a passphrase is set:
+//
+//	magic | salt (16) | nonce (12) | AES-256-GCM(tar.gz)
+//
+// The key is scrypt(passphrase, salt). Restore asks for the passphrase, or
+// uses the one saved with the S3 settings of this server.
+var encryptedBackupMagic = []byte("SCRIPTXRAY-BACKUP-ENC1\n")
+
+const encryptedBackupExt = ".enc"
+
+func backupKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// IsEncryptedBackup reports whether data is an encrypted archive
+func IsEncryptedBackup(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedBackupMagic)
+}
+
+// EncryptBackup encrypts a backup archive with passphrase
+func EncryptBackup(data []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := backupKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, encryptedBackupMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, encryptedBackupMagic), nil
+}
+
+// DecryptBackup returns the archive inside an encrypted backup
+func DecryptBackup(data []byte, passphrase string) ([]byte, error) {
+	if !IsEncryptedBackup(data) {
+		return nil, fmt.Errorf("not an encrypted backup")
+	}
+	rest := data[len(encryptedBackupMagic):]
+	if len(rest) < 16+12 {
+		return nil, fmt.Errorf("encrypted backup is truncated")
+	}
+	key, err := backupKey(passphrase, rest[:16])
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := rest[16 : 16+gcm.NonceSize()]
+	plain, err := gcm.Open(nil, nonce, rest[16+gcm.NonceSize():], encryptedBackupMagic)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or damaged backup")
+	}
+	return plain, nil
+}
+
+// OpenBackupData returns the plain archive. An encrypted one is decrypted
+// with passphrase, or with the S3 passphrase of this server when empty.
+func OpenBackupData(data []byte, passphrase string) ([]byte, error) {
+	if !IsEncryptedBackup(data) {
+		return data, nil
+	}
+	if passphrase == "" {
+		cfg, _ := LoadS3Config()
+		passphrase = cfg.Passphrase
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("the backup is encrypted, enter its passphrase")
+	}
+	return DecryptBackup(data, passphrase)
+}
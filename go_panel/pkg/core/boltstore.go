@@ -0,0 +1,406 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketClients  = []byte("clients")
+	bucketInbounds = []byte("inbounds")
+	bucketMeta     = []byte("meta")
+	bucketBadLines = []byte("bad_lines")
+)
+
+// badLineRecord is an unparsable clients.db / inbounds.db line carried over
+// into the bolt store, so -db-check can still show it and move it out
+type badLineRecord struct {
+	File    string   `json:"file"` // clients.db or inbounds.db
+	Line    int      `json:"line"`
+	Text    string   `json:"text"`
+	Err     string   `json:"err"`
+	Columns []string `json:"columns,omitempty"` // header clients.db waktu baris dibaca
+}
+
+// BoltStore keeps clients and inbounds in an embedded bbolt database.
+// The file is opened per call so the web panel, the bot, the CLI menu and
+// the timer runs (separate processes) can share it through bolt's file lock.
+type BoltStore struct {
+	Path string
+}
+
+func (s *BoltStore) open(readOnly bool) (*bolt.DB, error) {
+	return bolt.Open(s.Path, 0600, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: readOnly})
+}
+
+func (s *BoltStore) view(fn func(tx *bolt.Tx) error) error {
+	if _, err := os.Stat(s.Path); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := s.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+func (s *BoltStore) update(fn func(tx *bolt.Tx) error) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketClients, bucketInbounds, bucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+func inboundKey(port int) []byte {
+	return []byte(fmt.Sprintf("%05d", port))
+}
+
+func putClient(b *bolt.Bucket, c Client) error {
+	c.IsOnline = false
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(c.Username), data)
+}
+
+func decodeClient(v []byte) (Client, error) {
+	var c Client
+	if err := json.Unmarshal(v, &c); err != nil {
+		return c, err
+	}
+	if c.Status == "" {
+		c.Status = StatusActive
+	}
+	c.IsExpired = time.Now().After(c.Expiry)
+	return c, nil
+}
+
+func (s *BoltStore) LoadClients() ([]Client, error) {
+	var clients []Client
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketClients)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			c, err := decodeClient(v)
+			if err != nil {
+				return fmt.Errorf("client %s: %v", k, err)
+			}
+			clients = append(clients, c)
+			return nil
+		})
+	})
+	return clients, err
+}
+
+func (s *BoltStore) SaveClient(c Client) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketClients)
+		if err := checkBoltConflict(b, c, ""); err != nil {
+			return err
+		}
+		return putClient(b, c)
+	})
+}
+
+func (s *BoltStore) UpdateClient(username string, modifier func(*Client)) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketClients)
+		v := b.Get([]byte(username))
+		if v == nil {
+			return fmt.Errorf("user not found")
+		}
+		c, err := decodeClient(v)
+		if err != nil {
+			return err
+		}
+		before := c
+		modifier(&c)
+		if c.Username != before.Username || c.UUID != before.UUID {
+			if err := checkBoltConflict(b, c, username); err != nil {
+				return err
+			}
+		}
+		// Username adalah key, kalau diganti pindahkan record
+		if c.Username != username {
+			if err := b.Delete([]byte(username)); err != nil {
+				return err
+			}
+		}
+		return putClient(b, c)
+	})
+}
+
+// checkBoltConflict runs clientConflict against every stored client except
+// self, inside the caller's transaction
+func checkBoltConflict(b *bolt.Bucket, c Client, self string) error {
+	return b.ForEach(func(k, v []byte) error {
+		if string(k) == self {
+			return nil
+		}
+		cur, err := decodeClient(v)
+		if err != nil {
+			return fmt.Errorf("client %s: %v", k, err)
+		}
+		return clientConflict(c, cur)
+	})
+}
+
+func (s *BoltStore) UpdateClients(modifier func(*Client) bool) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketClients)
+		// Tidak boleh Put di dalam ForEach, kumpulkan dulu
+		var changed []Client
+		err := b.ForEach(func(k, v []byte) error {
+			c, err := decodeClient(v)
+			if err != nil {
+				return fmt.Errorf("client %s: %v", k, err)
+			}
+			if modifier(&c) {
+				if c.Username != string(k) {
+					return fmt.Errorf("client %s: username cannot change here", k)
+				}
+				changed = append(changed, c)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, c := range changed {
+			if err := putClient(b, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) DeleteClient(username string, before func(Client) error) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketClients)
+		v := b.Get([]byte(username))
+		if v == nil {
+			return errUserNotFound(username)
+		}
+		c, err := decodeClient(v)
+		if err != nil {
+			return err
+		}
+		if err := before(c); err != nil {
+			return err
+		}
+		return b.Delete([]byte(username))
+	})
+}
+
+func (s *BoltStore) LoadAllInbounds() ([]InboundDet, error) {
+	var inbounds []InboundDet
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketInbounds)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var inb InboundDet
+			if err := json.Unmarshal(v, &inb); err != nil {
+				return fmt.Errorf("inbound %s: %v", k, err)
+			}
+			inbounds = append(inbounds, inb)
+			return nil
+		})
+	})
+	return inbounds, err
+}
+
+func putInbound(b *bolt.Bucket, inb InboundDet) error {
+	data, err := json.Marshal(inb)
+	if err != nil {
+		return err
+	}
+	return b.Put(inboundKey(inb.Port), data)
+}
+
+func (s *BoltStore) SaveInbound(inb InboundDet) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketInbounds)
+		if v := b.Get(inboundKey(inb.Port)); v != nil {
+			var cur InboundDet
+			if err := json.Unmarshal(v, &cur); err != nil {
+				return fmt.Errorf("inbound %d: %v", inb.Port, err)
+			}
+			if err := inboundConflict(inb, cur); err != nil {
+				return err
+			}
+		}
+		return putInbound(b, inb)
+	})
+}
+
+func (s *BoltStore) DeleteInbound(port int) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketInbounds).Delete(inboundKey(port))
+	})
+}
+
+func (s *BoltStore) isMigrated() (bool, error) {
+	done := false
+	err := s.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bucketMeta); b != nil {
+			done = b.Get([]byte("migrated_at")) != nil
+		}
+		return nil
+	})
+	return done, err
+}
+
+// importAll writes the legacy records and the migration marker in one transaction
+func (s *BoltStore) importAll(clients []Client, inbounds []InboundDet, badClients, badInbounds []BadLine) error {
+	return s.update(func(tx *bolt.Tx) error {
+		// Proses lain mungkin sudah migrasi duluan
+		if tx.Bucket(bucketMeta).Get([]byte("migrated_at")) != nil {
+			return nil
+		}
+		if err := putBadLines(tx, badClients, badInbounds); err != nil {
+			return err
+		}
+		cb := tx.Bucket(bucketClients)
+		for _, c := range clients {
+			if err := putClient(cb, c); err != nil {
+				return err
+			}
+		}
+		ib := tx.Bucket(bucketInbounds)
+		for _, inb := range inbounds {
+			if err := putInbound(ib, inb); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketMeta).Put([]byte("migrated_at"), []byte(time.Now().Format(time.RFC3339)))
+	})
+}
+
+// replaceAll swaps the content of the clients and inbounds buckets inside tx
+func (s *BoltStore) replaceAll(tx *bolt.Tx, clients []Client, inbounds []InboundDet) error {
+	for _, name := range [][]byte{bucketClients, bucketInbounds} {
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	cb := tx.Bucket(bucketClients)
+	for _, c := range clients {
+		if err := putClient(cb, c); err != nil {
+			return err
+		}
+	}
+	ib := tx.Bucket(bucketInbounds)
+	for _, inb := range inbounds {
+		if err := putInbound(ib, inb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putBadLines replaces the kept unparsable lines inside tx
+func putBadLines(tx *bolt.Tx, clients, inbounds []BadLine) error {
+	if tx.Bucket(bucketBadLines) != nil {
+		if err := tx.DeleteBucket(bucketBadLines); err != nil {
+			return err
+		}
+	}
+	if len(clients) == 0 && len(inbounds) == 0 {
+		return nil
+	}
+	b, err := tx.CreateBucket(bucketBadLines)
+	if err != nil {
+		return err
+	}
+	for _, set := range []struct {
+		file  string
+		lines []BadLine
+	}{{"clients.db", clients}, {"inbounds.db", inbounds}} {
+		for _, bl := range set.lines {
+			rec := badLineRecord{File: set.file, Line: bl.Line, Text: bl.Text, Err: bl.Err.Error(), Columns: bl.columns}
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			seq, _ := b.NextSequence()
+			if err := b.Put([]byte(fmt.Sprintf("%08d", seq)), data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadBadLines returns the kept unparsable lines of clients.db and inbounds.db
+func loadBadLines(tx *bolt.Tx) (clients, inbounds []BadLine, err error) {
+	b := tx.Bucket(bucketBadLines)
+	if b == nil {
+		return nil, nil, nil
+	}
+	err = b.ForEach(func(k, v []byte) error {
+		var rec badLineRecord
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return fmt.Errorf("bad line %s: %v", k, err)
+		}
+		bl := BadLine{Line: rec.Line, Text: rec.Text, Err: fmt.Errorf("%s", rec.Err), columns: rec.Columns}
+		if rec.File == "inbounds.db" {
+			inbounds = append(inbounds, bl)
+		} else {
+			clients = append(clients, bl)
+		}
+		return nil
+	})
+	return clients, inbounds, err
+}
+
+// BadLines returns the unparsable lines carried over from the flat files
+func (s *BoltStore) BadLines() (clients, inbounds []BadLine, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		clients, inbounds, err = loadBadLines(tx)
+		return err
+	})
+	return clients, inbounds, err
+}
+
+// CopyTo writes a consistent snapshot of the database file to w
+func (s *BoltStore) CopyTo(w io.Writer) error {
+	if _, err := os.Stat(s.Path); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := s.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
@@ -0,0 +1,322 @@
+package core
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bulk export / import of clients for moving customers between servers.
+// CSV uses the clients.db v2 columns (same value formats, no escaping);
+// JSON is the Client struct as the panel stores it in bolt.
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// What to do with an imported client whose username already exists
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictRename    = "rename"
+)
+
+// ImportOptions controls ImportClients
+type ImportOptions struct {
+	KeepUUID bool   // false = every imported client gets a fresh UUID
+	Conflict string // ConflictSkip, ConflictOverwrite or ConflictRename
+	DryRun   bool   // only build the report
+}
+
+// ImportResult is the outcome for one imported record
+type ImportResult struct {
+	Username string // name it is stored under
+	Source   string // name in the file
+	Action   string // created, overwritten, renamed, skipped, failed
+	Note     string
+}
+
+// ImportReport lists every record of an import
+type ImportReport struct {
+	DryRun  bool
+	Results []ImportResult
+}
+
+// Count returns how many records ended with action
+func (r ImportReport) Count(action string) int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Changed reports whether the import wrote anything (config must be synced)
+func (r ImportReport) Changed() bool {
+	return !r.DryRun && r.Count("created")+r.Count("overwritten")+r.Count("renamed") > 0
+}
+
+// ExportClients writes clients as CSV or JSON
+func ExportClients(w io.Writer, clients []Client, format string) error {
+	switch format {
+	case FormatJSON:
+		if clients == nil {
+			clients = []Client{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(clients)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(clientColumns)
+		for _, c := range clients {
+			values := clientValues(c)
+			row := make([]string, len(clientColumns))
+			for i, col := range clientColumns {
+				row[i] = values[col]
+			}
+			cw.Write(row)
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown format %q (csv|json)", format)
+}
+
+// DetectImportFormat guesses the format from the file name, then the content
+func DetectImportFormat(name string, data []byte) string {
+	switch {
+	case strings.HasSuffix(strings.ToLower(name), ".json"):
+		return FormatJSON
+	case strings.HasSuffix(strings.ToLower(name), ".csv"):
+		return FormatCSV
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return FormatJSON
+	}
+	return FormatCSV
+}
+
+// ParseClientImport reads an export. A record that does not parse fails
+// the whole file so nothing is half imported.
+func ParseClientImport(data []byte, format string) ([]Client, error) {
+	switch format {
+	case FormatJSON:
+		var clients []Client
+		if err := json.Unmarshal(data, &clients); err != nil {
+			return nil, fmt.Errorf("json: %v", err)
+		}
+		for i := range clients {
+			c := &clients[i]
+			st, err := ParseStatus(string(c.Status))
+			if err != nil {
+				return nil, fmt.Errorf("record %d (%s): %v", i+1, c.Username, err)
+			}
+			c.Status = st
+			if c.ResetPeriod, err = ParseResetPeriod(c.ResetPeriod); err != nil {
+				return nil, fmt.Errorf("record %d (%s): %v", i+1, c.Username, err)
+			}
+			c.SetInbounds(c.InboundTags())
+		}
+		return clients, nil
+	case FormatCSV:
+		cr := csv.NewReader(bytes.NewReader(data))
+		rows, err := cr.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("csv: %v", err)
+		}
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		header := rows[0]
+		var clients []Client
+		for i, row := range rows[1:] {
+			fields := make(map[string]string, len(header))
+			for j, col := range header {
+				fields[strings.TrimSpace(col)] = strings.TrimSpace(row[j])
+			}
+			c, err := clientFromFields(fields)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+2, err)
+			}
+			clients = append(clients, c)
+		}
+		return clients, nil
+	}
+	return nil, fmt.Errorf("unknown format %q (csv|json)", format)
+}
+
+// ImportClients adds the clients according to opt and reports every record.
+// It never syncs the Xray config; the caller does that once when
+// report.Changed() is true.
+func ImportClients(a Actor, clients []Client, opt ImportOptions) (ImportReport, error) {
+	return importClients(a, clients, opt, nil)
+}
+
+// importClients treats pendingTags as existing inbounds; a dry run of a
+// panel import uses it for the inbounds it would create
+func importClients(a Actor, clients []Client, opt ImportOptions, pendingTags []string) (ImportReport, error) {
+	report := ImportReport{DryRun: opt.DryRun}
+	switch opt.Conflict {
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+	default:
+		return report, fmt.Errorf("unknown conflict mode %q (skip|overwrite|rename)", opt.Conflict)
+	}
+
+	existing, err := LoadClients()
+	if err != nil {
+		return report, err
+	}
+	tags, err := InboundTagList()
+	if err != nil {
+		return report, err
+	}
+	knownTag := make(map[string]bool)
+	for _, t := range append(tags, pendingTags...) {
+		knownTag[t] = true
+	}
+	// Nama dan UUID yang sudah terpakai, termasuk dari baris sebelumnya di file
+	byName := make(map[string]Client)
+	byUUID := make(map[string]string)
+	for _, c := range existing {
+		byName[strings.ToLower(c.Username)] = c
+		byUUID[c.UUID] = c.Username
+	}
+
+	for _, in := range clients {
+		c := cloneClient(in)
+		c.Username = strings.TrimSpace(c.Username)
+		res := ImportResult{Username: c.Username, Source: c.Username}
+		fail := func(format string, args ...interface{}) {
+			res.Action = "failed"
+			res.Note = fmt.Sprintf(format, args...)
+			report.Results = append(report.Results, res)
+		}
+
+		if err := CheckUsernameFormat(c.Username); err != nil {
+			fail("%v", err)
+			continue
+		}
+
+		// Inbound yang tidak ada di server ini dilepas
+		var attach, dropped []string
+		for _, t := range c.InboundTags() {
+			if knownTag[t] {
+				attach = append(attach, t)
+			} else {
+				dropped = append(dropped, t)
+			}
+		}
+		if len(attach) == 0 {
+			if len(dropped) == 0 {
+				fail("no inbound")
+			} else {
+				fail("no inbound of this server matches %s", strings.Join(dropped, ", "))
+			}
+			continue
+		}
+		c.SetInbounds(attach)
+		var notes []string
+		if len(dropped) > 0 {
+			notes = append(notes, "dropped unknown inbounds "+strings.Join(dropped, ", "))
+		}
+
+		action := "created"
+		var replaced *Client
+		if old, ok := byName[strings.ToLower(c.Username)]; ok {
+			switch opt.Conflict {
+			case ConflictSkip:
+				res.Action = "skipped"
+				res.Note = "username exists"
+				report.Results = append(report.Results, res)
+				continue
+			case ConflictOverwrite:
+				action = "overwritten"
+				replaced = &old
+			case ConflictRename:
+				action = "renamed"
+				c.Username = freeUsername(c.Username, byName)
+				res.Username = c.Username
+			}
+		}
+
+		if opt.KeepUUID {
+			c.UUID = NormalizeUUID(c.UUID)
+			if err := CheckUUIDFormat(c.UUID); err != nil {
+				fail("%v", err)
+				continue
+			}
+			if owner, ok := byUUID[c.UUID]; ok && (replaced == nil || owner != replaced.Username) {
+				if opt.Conflict != ConflictRename {
+					fail("UUID is already used by %s", owner)
+					continue
+				}
+				c.UUID = GenerateUUID()
+				notes = append(notes, "UUID used by "+owner+", new UUID generated")
+			}
+		} else {
+			c.UUID = GenerateUUID()
+			c.Password = ""
+		}
+
+		if c.CreatedAt.IsZero() {
+			c.CreatedAt = time.Now()
+		}
+		if c.StatusChangedAt.IsZero() {
+			c.StatusChangedAt = time.Now()
+		}
+		c.IsExpired = time.Now().After(c.Expiry)
+		c.IsOnline = false
+
+		if !opt.DryRun {
+			var err error
+			if replaced != nil {
+				err = activeStore.UpdateClient(replaced.Username, func(cur *Client) { *cur = c })
+			} else {
+				err = activeStore.SaveClient(c)
+			}
+			if err != nil {
+				fail("%v", err)
+				continue
+			}
+			var before interface{}
+			if replaced != nil {
+				before = *replaced
+			}
+			Audit(a, "import", c.Username, before, c)
+		}
+
+		if replaced != nil {
+			delete(byUUID, replaced.UUID)
+		}
+		byName[strings.ToLower(c.Username)] = c
+		byUUID[c.UUID] = c.Username
+		res.Action = action
+		res.Note = strings.Join(notes, "; ")
+		report.Results = append(report.Results, res)
+	}
+	return report, nil
+}
+
+// freeUsername appends -2, -3, ... until the name is unused; used is keyed
+// by lowercase username
+func freeUsername(name string, used map[string]Client) string {
+	for i := 2; ; i++ {
+		suffix := "-" + strconv.Itoa(i)
+		base := name
+		if len(base)+len(suffix) > UsernameMaxLen {
+			base = base[:UsernameMaxLen-len(suffix)]
+		}
+		if _, ok := used[strings.ToLower(base+suffix)]; !ok {
+			return base + suffix
+		}
+	}
+}
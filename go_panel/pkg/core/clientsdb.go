@@ -0,0 +1,394 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clients.db record format
+//
+// v1 (no header): username;quota;used;expiry;protocol;uuid[;status;reason;changed_at]
+// v2: a header line "#clientsdb v2 <col>;<col>;..." names the columns of every
+// following line. Values are percent-escaped so notes may contain ';'.
+// "inbounds" lists every attached inbound tag; "protocol" repeats the first.
+// Columns this version does not know are kept in Client.Extra and written back.
+// A later "#clientsdb v1" line switches back to the positional format; it is
+// used to keep unparsable legacy lines readable after a migration.
+const ClientsDBVersion = 2
+
+const clientsDBHeader = "#clientsdb v"
+
+// Dates are stored as local wall clock time, like the shell scripts did
+const dbTimeLayout = "2006-01-02 15:04:05"
+
+var clientColumns = []string{
+	"username", "quota", "used", "expiry", "protocol", "uuid",
+	"status", "status_reason", "status_changed_at",
+	"created_at", "notes", "tags", "owner", "telegram_id", "inbounds",
+	"plan", "device_limit", "reset_period", "last_reset", "prev_used",
+	"password",
+}
+
+// BadLine is a clients.db line that could not be parsed. It is never
+// dropped: writers put it back into the file unchanged.
+type BadLine struct {
+	Line int
+	Text string
+	Err  error
+
+	columns []string // header in effect when the line was read (nil = v1)
+}
+
+func (b BadLine) Error() string {
+	return fmt.Sprintf("line %d: %v", b.Line, b.Err)
+}
+
+// clientsFile is the parsed content of clients.db
+type clientsFile struct {
+	Version int
+	Columns []string // columns of the header (v2) or nil (v1)
+	Clients []Client
+	Lines   []int // line number of each client, only valid right after parsing
+	Bad     []BadLine
+}
+
+func readClientsFile(path string) (*clientsFile, error) {
+	f := &clientsFile{Version: 1}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		f.Version = ClientsDBVersion
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseClientsFile(file)
+}
+
+func parseClientsFile(r io.Reader) (*clientsFile, error) {
+	f := &clientsFile{Version: 1}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, clientsDBHeader) {
+			// Header yang tidak bisa dibaca berarti semua baris ikut tidak
+			// bisa ditafsirkan, jadi berhenti total daripada menulis ulang
+			version, cols, err := parseClientsHeader(line)
+			if err != nil {
+				return nil, BadLine{Line: lineNo, Text: line, Err: err}
+			}
+			if len(f.Clients) == 0 && len(f.Bad) == 0 {
+				f.Version = version
+			}
+			f.Columns = cols
+			continue
+		}
+
+		var c Client
+		var err error
+		if f.Columns == nil {
+			c, err = decodeClientV1(line)
+		} else {
+			c, err = decodeClientRow(f.Columns, line)
+		}
+		if err != nil {
+			f.Bad = append(f.Bad, BadLine{Line: lineNo, Text: line, Err: err, columns: f.Columns})
+			continue
+		}
+		f.Clients = append(f.Clients, c)
+		f.Lines = append(f.Lines, lineNo)
+	}
+	return f, scanner.Err()
+}
+
+func parseClientsHeader(line string) (int, []string, error) {
+	rest := strings.TrimPrefix(line, clientsDBHeader)
+	verStr, colStr, _ := strings.Cut(rest, " ")
+	version, err := strconv.Atoi(verStr)
+	if err != nil {
+		return 0, nil, fmt.Errorf("bad header version %q", verStr)
+	}
+	if version > ClientsDBVersion {
+		return 0, nil, fmt.Errorf("clients.db v%d is newer than this panel (v%d)", version, ClientsDBVersion)
+	}
+	if version == 1 {
+		return 1, nil, nil
+	}
+	cols := strings.Split(colStr, ";")
+	seen := make(map[string]bool)
+	for _, col := range cols {
+		if col == "" || seen[col] {
+			return 0, nil, fmt.Errorf("bad header column %q", col)
+		}
+		seen[col] = true
+	}
+	for _, req := range []string{"username", "expiry", "protocol", "uuid"} {
+		if !seen[req] {
+			return 0, nil, fmt.Errorf("header is missing column %q", req)
+		}
+	}
+	return version, cols, nil
+}
+
+// decodeClientV1 parses the positional legacy format
+func decodeClientV1(line string) (Client, error) {
+	parts := strings.Split(line, ";")
+	if len(parts) != 6 && len(parts) != 9 {
+		return Client{}, fmt.Errorf("expected 6 or 9 fields, got %d", len(parts))
+	}
+	cols := clientColumns[:len(parts)]
+	fields := make(map[string]string, len(parts))
+	for i, col := range cols {
+		fields[col] = parts[i]
+	}
+	return clientFromFields(fields)
+}
+
+func decodeClientRow(cols []string, line string) (Client, error) {
+	parts := strings.Split(line, ";")
+	if len(parts) != len(cols) {
+		return Client{}, fmt.Errorf("expected %d fields, got %d", len(cols), len(parts))
+	}
+	fields := make(map[string]string, len(parts))
+	for i, col := range cols {
+		v, err := url.PathUnescape(parts[i])
+		if err != nil {
+			return Client{}, fmt.Errorf("column %s: %v", col, err)
+		}
+		fields[col] = v
+	}
+	return clientFromFields(fields)
+}
+
+// clientFromFields builds a client from column values; any value that does
+// not parse fails the whole record instead of being replaced by a default.
+func clientFromFields(fields map[string]string) (Client, error) {
+	var c Client
+	var err error
+
+	c.Username = fields["username"]
+	if c.Username == "" {
+		return c, fmt.Errorf("empty username")
+	}
+	c.Protocol = fields["protocol"]
+	c.UUID = fields["uuid"]
+	c.Password = fields["password"]
+	if c.Quota, err = parseFloatField(fields, "quota"); err != nil {
+		return c, err
+	}
+	if c.Used, err = parseFloatField(fields, "used"); err != nil {
+		return c, err
+	}
+	if c.Expiry, err = time.ParseInLocation(dbTimeLayout, fields["expiry"], time.Local); err != nil {
+		return c, fmt.Errorf("column expiry: bad date %q", fields["expiry"])
+	}
+	if c.Status, err = ParseStatus(fields["status"]); err != nil {
+		return c, err
+	}
+	c.StatusReason = fields["status_reason"]
+	if c.StatusChangedAt, err = parseTimeField(fields, "status_changed_at"); err != nil {
+		return c, err
+	}
+	if c.CreatedAt, err = parseTimeField(fields, "created_at"); err != nil {
+		return c, err
+	}
+	c.Notes = fields["notes"]
+	if t := fields["tags"]; t != "" {
+		c.Tags = strings.Split(t, ",")
+	}
+	c.Owner = fields["owner"]
+	c.Plan = fields["plan"]
+	if v := fields["device_limit"]; v != "" {
+		if c.DeviceLimit, err = strconv.Atoi(v); err != nil {
+			return c, fmt.Errorf("column device_limit: bad number %q", v)
+		}
+	}
+	if c.ResetPeriod, err = ParseResetPeriod(fields["reset_period"]); err != nil {
+		return c, fmt.Errorf("column reset_period: %v", err)
+	}
+	if c.LastReset, err = parseTimeField(fields, "last_reset"); err != nil {
+		return c, err
+	}
+	if c.PrevUsed, err = parseFloatField(fields, "prev_used"); err != nil {
+		return c, err
+	}
+	if v := fields["inbounds"]; v != "" {
+		c.SetInbounds(strings.Split(v, ","))
+	}
+	if v := fields["telegram_id"]; v != "" {
+		if c.TelegramID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return c, fmt.Errorf("column telegram_id: bad number %q", v)
+		}
+	}
+
+	known := make(map[string]bool, len(clientColumns))
+	for _, col := range clientColumns {
+		known[col] = true
+	}
+	for col, v := range fields {
+		if known[col] {
+			continue
+		}
+		if c.Extra == nil {
+			c.Extra = make(map[string]string)
+		}
+		c.Extra[col] = v
+	}
+
+	c.IsExpired = time.Now().After(c.Expiry)
+	return c, nil
+}
+
+func parseFloatField(fields map[string]string, col string) (float64, error) {
+	v := fields[col]
+	if v == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("column %s: bad number %q", col, v)
+	}
+	return f, nil
+}
+
+func parseTimeField(fields map[string]string, col string) (time.Time, error) {
+	v := fields[col]
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(dbTimeLayout, v, time.Local)
+	if err != nil {
+		return t, fmt.Errorf("column %s: bad date %q", col, v)
+	}
+	return t, nil
+}
+
+func formatTimeField(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(dbTimeLayout)
+}
+
+var fieldEscaper = strings.NewReplacer("%", "%25", ";", "%3B", "\n", "%0A", "\r", "%0D")
+
+// clientValues renders the known columns of a client, unescaped
+func clientValues(c Client) map[string]string {
+	if c.Status == "" {
+		c.Status = StatusActive
+	}
+	values := map[string]string{
+		"username":          c.Username,
+		"quota":             fmt.Sprintf("%.2f", c.Quota),
+		"used":              fmt.Sprintf("%.0f", c.Used),
+		"expiry":            c.Expiry.Format(dbTimeLayout),
+		"protocol":          c.Protocol,
+		"uuid":              c.UUID,
+		"password":          c.Password,
+		"status":            string(c.Status),
+		"status_reason":     c.StatusReason,
+		"status_changed_at": formatTimeField(c.StatusChangedAt),
+		"created_at":        formatTimeField(c.CreatedAt),
+		"notes":             c.Notes,
+		"tags":              strings.Join(c.Tags, ","),
+		"owner":             c.Owner,
+		"inbounds":          strings.Join(c.Inbounds, ","),
+		"plan":              c.Plan,
+		"reset_period":      c.ResetPeriod,
+		"last_reset":        formatTimeField(c.LastReset),
+	}
+	if c.PrevUsed != 0 {
+		values["prev_used"] = fmt.Sprintf("%.0f", c.PrevUsed)
+	}
+	if c.TelegramID != 0 {
+		values["telegram_id"] = strconv.FormatInt(c.TelegramID, 10)
+	}
+	if c.DeviceLimit != 0 {
+		values["device_limit"] = strconv.Itoa(c.DeviceLimit)
+	}
+	return values
+}
+
+func encodeClientRow(c Client, cols []string) string {
+	values := clientValues(c)
+	parts := make([]string, len(cols))
+	for i, col := range cols {
+		v, ok := values[col]
+		if !ok {
+			v = c.Extra[col]
+		}
+		parts[i] = fieldEscaper.Replace(v)
+	}
+	return strings.Join(parts, ";")
+}
+
+// encode renders the file in the current version. Unknown columns from the
+// old header or from Client.Extra are appended after the known ones, and
+// unparsable lines are written back verbatim at the end.
+func (f *clientsFile) encode() []byte {
+	cols := append([]string{}, clientColumns...)
+	known := make(map[string]bool)
+	for _, col := range cols {
+		known[col] = true
+	}
+	var extra []string
+	addExtra := func(col string) {
+		if !known[col] {
+			known[col] = true
+			extra = append(extra, col)
+		}
+	}
+	for _, col := range f.Columns {
+		addExtra(col)
+	}
+	for _, c := range f.Clients {
+		keys := make([]string, 0, len(c.Extra))
+		for k := range c.Extra {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			addExtra(k)
+		}
+	}
+	cols = append(cols, extra...)
+
+	header := func(cols []string) string {
+		if cols == nil {
+			return fmt.Sprintf("%s1", clientsDBHeader)
+		}
+		return fmt.Sprintf("%s%d %s", clientsDBHeader, ClientsDBVersion, strings.Join(cols, ";"))
+	}
+
+	var sb strings.Builder
+	current := header(cols)
+	sb.WriteString(current + "\n")
+	for _, c := range f.Clients {
+		sb.WriteString(encodeClientRow(c, cols))
+		sb.WriteString("\n")
+	}
+	// Bad lines go back under the header they were read with
+	for _, b := range f.Bad {
+		if h := header(b.columns); h != current {
+			current = h
+			sb.WriteString(current + "\n")
+		}
+		sb.WriteString(b.Text)
+		sb.WriteString("\n")
+	}
+	return []byte(sb.String())
+}
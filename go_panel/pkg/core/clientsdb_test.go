@@ -0,0 +1,168 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dbTime is a date as clients.db stores it (local time, whole seconds)
+func dbTime(s string) time.Time {
+	t, err := time.ParseInLocation(dbTimeLayout, s, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestClientsFileRoundTrip(t *testing.T) {
+	expiry := dbTime("2030-01-02 03:04:05")
+	tests := []struct {
+		name string
+		c    Client
+	}{
+		{"minimal", Client{Username: "alice", UUID: GenerateUUID(), Expiry: expiry, Status: StatusActive}},
+		{"escaped notes", Client{
+			Username: "bob", UUID: GenerateUUID(), Expiry: expiry, Status: StatusActive,
+			Notes: "paid 50%; renew\r\nnext month %3B",
+		}},
+		{"every column", Client{
+			Username: "carol", Quota: 12.5, Used: 1024, Expiry: expiry, UUID: GenerateUUID(),
+			Password:     "tr0jan;pass",
+			Status:       StatusQuotaExceeded,
+			StatusReason: "quota 12.50 GB used", StatusChangedAt: dbTime("2029-12-01 10:00:00"),
+			CreatedAt: dbTime("2029-01-01 00:00:00"),
+			Tags:      []string{"vip", "reseller"}, Owner: "admin", TelegramID: 123456789,
+			Inbounds: []string{"vless-ws", "trojan-grpc"}, Protocol: "vless-ws",
+			Plan: "monthly", DeviceLimit: 3, ResetPeriod: ResetMonthly,
+			LastReset: dbTime("2029-12-01 00:00:00"), PrevUsed: 2048,
+		}},
+		{"unknown column", Client{
+			Username: "dave", UUID: GenerateUUID(), Expiry: expiry, Status: StatusDisabled,
+			Extra: map[string]string{"referrer": "x;y%z"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &clientsFile{Clients: []Client{tt.c}}
+			got, err := parseClientsFile(strings.NewReader(string(f.encode())))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Version != ClientsDBVersion || len(got.Bad) != 0 || len(got.Clients) != 1 {
+				t.Fatalf("version %d, %d clients, bad %v", got.Version, len(got.Clients), got.Bad)
+			}
+			want := tt.c
+			want.IsExpired = time.Now().After(want.Expiry)
+			if !reflect.DeepEqual(got.Clients[0], want) {
+				t.Errorf("round trip\n got %+v\nwant %+v", got.Clients[0], want)
+			}
+		})
+	}
+}
+
+func TestParseClientsFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		check func(t *testing.T, f *clientsFile)
+	}{
+		{
+			name: "named columns in any order",
+			input: "#clientsdb v2 uuid;expiry;username;protocol;notes;referrer\n" +
+				"11111111-2222-3333-4444-555555555555;2030-01-02 03:04:05;alice;vless-ws;a%3Bb%25;friend\n",
+			check: func(t *testing.T, f *clientsFile) {
+				c := f.Clients[0]
+				if c.Username != "alice" || c.UUID != "11111111-2222-3333-4444-555555555555" ||
+					c.Notes != "a;b%" || !c.Expiry.Equal(dbTime("2030-01-02 03:04:05")) {
+					t.Errorf("client = %+v", c)
+				}
+				if c.Extra["referrer"] != "friend" {
+					t.Errorf("extra = %v", c.Extra)
+				}
+				if c.Status != StatusActive {
+					t.Errorf("status = %q, want the default", c.Status)
+				}
+			},
+		},
+		{
+			name: "legacy v1 lines",
+			input: "alice;10.00;500;2030-01-02 03:04:05;vless-ws;11111111-2222-3333-4444-555555555555\n" +
+				"bob;0.00;0;2020-01-02 03:04:05;trojan-ws;66666666-7777-8888-9999-000000000000;expired;expired on 2020-01-02;2020-01-02 03:05:00\n",
+			check: func(t *testing.T, f *clientsFile) {
+				if f.Version != 1 || f.Columns != nil {
+					t.Errorf("version %d columns %v, want v1", f.Version, f.Columns)
+				}
+				if c := f.Clients[0]; c.Quota != 10 || c.Used != 500 || c.Status != StatusActive {
+					t.Errorf("alice = %+v", c)
+				}
+				if c := f.Clients[1]; c.Status != StatusExpired || c.StatusReason != "expired on 2020-01-02" || !c.IsExpired {
+					t.Errorf("bob = %+v", c)
+				}
+				// Ditulis ulang sebagai v2 tanpa kehilangan isi
+				again, err := parseClientsFile(strings.NewReader(string(f.encode())))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if again.Version != ClientsDBVersion || !reflect.DeepEqual(again.Clients, f.Clients) {
+					t.Errorf("v1 -> v2\n got %+v\nwant %+v", again.Clients, f.Clients)
+				}
+			},
+		},
+		{
+			name: "bad lines are kept verbatim",
+			input: "#clientsdb v2 username;expiry;protocol;uuid\n" +
+				"alice;2030-01-02 03:04:05;vless-ws;11111111-2222-3333-4444-555555555555\n" +
+				"bob;tomorrow;vless-ws;66666666-7777-8888-9999-000000000000\n" +
+				"#clientsdb v1\n" +
+				"carol;10;0;2030-01-02\n",
+			check: func(t *testing.T, f *clientsFile) {
+				if len(f.Clients) != 1 || len(f.Bad) != 2 {
+					t.Fatalf("%d clients, bad %v", len(f.Clients), f.Bad)
+				}
+				if f.Bad[0].Line != 3 || !strings.Contains(f.Bad[0].Error(), "expiry") {
+					t.Errorf("bad line = %v", f.Bad[0])
+				}
+				out := string(f.encode())
+				for _, line := range []string{
+					"\nbob;tomorrow;vless-ws;66666666-7777-8888-9999-000000000000\n",
+					"\n#clientsdb v1\ncarol;10;0;2030-01-02\n",
+				} {
+					if !strings.Contains(out, line) {
+						t.Errorf("encoded file lost %q:\n%s", line, out)
+					}
+				}
+				again, err := parseClientsFile(strings.NewReader(out))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(again.Clients) != 1 || len(again.Bad) != 2 {
+					t.Errorf("after rewrite: %d clients, bad %v", len(again.Clients), again.Bad)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseClientsFile(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, f)
+		})
+	}
+}
+
+func TestParseClientsFileBadHeader(t *testing.T) {
+	for _, header := range []string{
+		"#clientsdb vX username;expiry;protocol;uuid",
+		"#clientsdb v9 username;expiry;protocol;uuid",
+		"#clientsdb v2 username;expiry;protocol",
+		"#clientsdb v2 username;expiry;protocol;uuid;uuid",
+	} {
+		if _, err := parseClientsFile(strings.NewReader(header + "\n")); err == nil {
+			t.Errorf("%q: no error", header)
+		}
+	}
+}
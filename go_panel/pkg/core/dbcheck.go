@@ -0,0 +1,491 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// API_PORT is the dokodemo-door inbound SyncConfig always adds
+const API_PORT = 10085
+
+type IssueKind string
+
+const (
+	IssueParse             IssueKind = "parse_error"
+	IssueDuplicateUsername IssueKind = "duplicate_username"
+	IssueDuplicateUUID     IssueKind = "duplicate_uuid"
+	IssueUnknownInbound    IssueKind = "unknown_inbound"
+	IssuePortCollision     IssueKind = "port_collision"
+)
+
+// DBIssue is one problem found by CheckDatabases
+type DBIssue struct {
+	Kind    IssueKind
+	File    string // database the issue was found in
+	Line    int    // 0 when the store has no line numbers (bolt)
+	Subject string // username, port or raw line
+	Detail  string
+	Fix     string // what RepairDatabases will do
+
+	idx int // index into the clients / inbounds / bad lines of the snapshot
+}
+
+func (i DBIssue) String() string {
+	loc := i.File
+	if i.Line > 0 {
+		loc = fmt.Sprintf("%s:%d", i.File, i.Line)
+	}
+	return fmt.Sprintf("[%s] %s %s: %s", i.Kind, loc, i.Subject, i.Detail)
+}
+
+func (i DBIssue) key() string {
+	return fmt.Sprintf("%s|%s|%d|%s", i.Kind, i.File, i.Line, i.Subject)
+}
+
+// DBReport is the result of CheckDatabases
+type DBReport struct {
+	Clients  int
+	Inbounds int
+	Issues   []DBIssue
+}
+
+// RepairResult lists the fixes RepairDatabases applied
+type RepairResult struct {
+	Backups []string
+	Applied []DBIssue
+}
+
+// dbSnapshot holds both databases of the active store
+type dbSnapshot struct {
+	clientsFile  string
+	inboundsFile string
+
+	clients      []Client
+	clientLines  []int
+	badClients   []BadLine
+	inbounds     []InboundDet
+	inboundLines []int
+	badInbounds  []BadLine
+}
+
+func loadFileSnapshot() (*dbSnapshot, error) {
+	cf, err := readClientsFile(DB_CLIENTS)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", DB_CLIENTS, err)
+	}
+	inf, err := readInboundsFile(DB_INBOUNDS)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", DB_INBOUNDS, err)
+	}
+	return &dbSnapshot{
+		clientsFile:  DB_CLIENTS,
+		inboundsFile: DB_INBOUNDS,
+		clients:      cf.Clients,
+		clientLines:  cf.Lines,
+		badClients:   cf.Bad,
+		inbounds:     inf.Inbounds,
+		inboundLines: inf.Lines,
+		badInbounds:  inf.Bad,
+	}, nil
+}
+
+func loadBoltSnapshot(tx *bolt.Tx, path string) (*dbSnapshot, error) {
+	snap := &dbSnapshot{clientsFile: path, inboundsFile: path}
+	if b := tx.Bucket(bucketClients); b != nil {
+		err := b.ForEach(func(k, v []byte) error {
+			c, err := decodeClient(v)
+			if err != nil {
+				return fmt.Errorf("client %s: %v", k, err)
+			}
+			snap.clients = append(snap.clients, c)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b := tx.Bucket(bucketInbounds); b != nil {
+		err := b.ForEach(func(k, v []byte) error {
+			var inb InboundDet
+			if err := json.Unmarshal(v, &inb); err != nil {
+				return fmt.Errorf("inbound %s: %v", k, err)
+			}
+			snap.inbounds = append(snap.inbounds, inb)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if snap.badClients, snap.badInbounds, err = loadBadLines(tx); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+func (s *dbSnapshot) clientLine(i int) int {
+	if i < len(s.clientLines) {
+		return s.clientLines[i]
+	}
+	return 0
+}
+
+func (s *dbSnapshot) inboundLine(i int) int {
+	if i < len(s.inboundLines) {
+		return s.inboundLines[i]
+	}
+	return 0
+}
+
+func (s *dbSnapshot) check() []DBIssue {
+	var issues []DBIssue
+
+	for i, b := range s.badClients {
+		issues = append(issues, DBIssue{
+			Kind: IssueParse, File: s.clientsFile, Line: b.Line,
+			Subject: strings.SplitN(b.Text, ";", 2)[0],
+			Detail:  b.Err.Error(),
+			Fix:     "move the line to " + s.clientsFile + ".rejected",
+			idx:     i,
+		})
+	}
+	for i, b := range s.badInbounds {
+		issues = append(issues, DBIssue{
+			Kind: IssueParse, File: s.inboundsFile, Line: b.Line,
+			Subject: b.Text,
+			Detail:  b.Err.Error(),
+			Fix:     "move the line to " + s.inboundsFile + ".rejected",
+			idx:     len(s.badClients) + i,
+		})
+	}
+
+	ports := make(map[int]int)
+	for i, inb := range s.inbounds {
+		if inb.Port == API_PORT {
+			issues = append(issues, DBIssue{
+				Kind: IssuePortCollision, File: s.inboundsFile, Line: s.inboundLine(i),
+				Subject: fmt.Sprintf("port %d", inb.Port),
+				Detail:  fmt.Sprintf("%s uses the Xray API port", inb.Tag),
+				Fix:     "remove inbound " + inb.Tag,
+				idx:     i,
+			})
+			continue
+		}
+		if first, dup := ports[inb.Port]; dup {
+			issues = append(issues, DBIssue{
+				Kind: IssuePortCollision, File: s.inboundsFile, Line: s.inboundLine(i),
+				Subject: fmt.Sprintf("port %d", inb.Port),
+				Detail:  fmt.Sprintf("%s collides with %s", inb.Tag, s.inbounds[first].Tag),
+				Fix:     "remove inbound " + inb.Tag,
+				idx:     i,
+			})
+			continue
+		}
+		ports[inb.Port] = i
+	}
+
+	names := make(map[string]int)
+	uuids := make(map[string]int)
+	for i, c := range s.clients {
+		line := s.clientLine(i)
+		// Sama seperti store: "Budi" dan "budi" dianggap satu username
+		key := strings.ToLower(c.Username)
+		if first, dup := names[key]; dup {
+			issues = append(issues, DBIssue{
+				Kind: IssueDuplicateUsername, File: s.clientsFile, Line: line,
+				Subject: c.Username,
+				Detail:  fmt.Sprintf("username also used on line %d", s.clientLine(first)),
+				Fix:     "rename to " + s.freeUsername(c.Username),
+				idx:     i,
+			})
+		} else {
+			names[key] = i
+		}
+		if first, dup := uuids[c.UUID]; dup {
+			issues = append(issues, DBIssue{
+				Kind: IssueDuplicateUUID, File: s.clientsFile, Line: line,
+				Subject: c.Username,
+				Detail:  fmt.Sprintf("UUID %s also used by %s", c.UUID, s.clients[first].Username),
+				Fix:     "generate a new UUID",
+				idx:     i,
+			})
+		} else {
+			uuids[c.UUID] = i
+		}
+		// Client yang sudah nonaktif tidak ikut SyncConfig, jadi tidak perlu dilaporkan
+		if known, unknown := s.splitTags(c); len(unknown) > 0 && c.Status == StatusActive {
+			fix := "disable the client"
+			if len(known) > 0 {
+				fix = "detach " + strings.Join(unknown, ", ")
+			}
+			issues = append(issues, DBIssue{
+				Kind: IssueUnknownInbound, File: s.clientsFile, Line: line,
+				Subject: c.Username,
+				Detail:  fmt.Sprintf("inbound %q does not exist", strings.Join(unknown, ",")),
+				Fix:     fix,
+				idx:     i,
+			})
+		}
+	}
+	return issues
+}
+
+// splitTags separates the client's attachments into existing and unknown tags
+func (s *dbSnapshot) splitTags(c Client) (known, unknown []string) {
+	exists := make(map[string]bool)
+	for _, inb := range s.inbounds {
+		exists[inb.Tag] = true
+	}
+	for _, t := range c.InboundTags() {
+		if exists[t] {
+			known = append(known, t)
+		} else {
+			unknown = append(unknown, t)
+		}
+	}
+	return known, unknown
+}
+
+func (s *dbSnapshot) freeUsername(name string) string {
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s_dup%d", name, n)
+		taken := false
+		for _, c := range s.clients {
+			if strings.EqualFold(c.Username, candidate) {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return candidate
+		}
+	}
+}
+
+// apply performs the approved fixes and returns the rejected raw lines per file
+func (s *dbSnapshot) apply(issues []DBIssue) map[string][]string {
+	rejected := make(map[string][]string)
+	dropBad := make(map[int]bool)
+	dropInbound := make(map[int]bool)
+
+	for _, is := range issues {
+		switch is.Kind {
+		case IssueParse:
+			dropBad[is.idx] = true
+		case IssueDuplicateUsername:
+			s.clients[is.idx].Username = s.freeUsername(s.clients[is.idx].Username)
+		case IssueDuplicateUUID:
+			s.clients[is.idx].UUID = GenerateUUID()
+		case IssueUnknownInbound:
+			c := &s.clients[is.idx]
+			known, unknown := s.splitTags(*c)
+			if len(known) > 0 {
+				c.SetInbounds(known)
+			} else {
+				c.SetStatus(StatusDisabled, fmt.Sprintf("unknown inbound %s", strings.Join(unknown, ",")))
+			}
+		case IssuePortCollision:
+			dropInbound[is.idx] = true
+		}
+	}
+
+	var badClients, badInbounds []BadLine
+	for i, b := range s.badClients {
+		if dropBad[i] {
+			rejected[s.clientsFile] = append(rejected[s.clientsFile], b.Text)
+			continue
+		}
+		badClients = append(badClients, b)
+	}
+	for i, b := range s.badInbounds {
+		if dropBad[len(s.badClients)+i] {
+			rejected[s.inboundsFile] = append(rejected[s.inboundsFile], b.Text)
+			continue
+		}
+		badInbounds = append(badInbounds, b)
+	}
+	var inbounds []InboundDet
+	for i, inb := range s.inbounds {
+		if dropInbound[i] {
+			rejected[s.inboundsFile] = append(rejected[s.inboundsFile], inboundLine(inb))
+			continue
+		}
+		inbounds = append(inbounds, inb)
+	}
+	s.badClients, s.badInbounds, s.inbounds = badClients, badInbounds, inbounds
+	return rejected
+}
+
+// CheckDatabases validates the clients and inbounds of the active store:
+// unparsable lines, duplicate usernames and UUIDs, clients pointing at
+// unknown inbound tags and colliding ports.
+func CheckDatabases() (*DBReport, error) {
+	var snap *dbSnapshot
+	var err error
+	switch st := activeStore.(type) {
+	case *FileStore:
+		snap, err = loadFileSnapshot()
+	case *BoltStore:
+		err = st.view(func(tx *bolt.Tx) error {
+			snap, err = loadBoltSnapshot(tx, st.Path)
+			return err
+		})
+		if snap == nil && err == nil {
+			snap = &dbSnapshot{clientsFile: st.Path, inboundsFile: st.Path}
+		}
+	default:
+		return nil, fmt.Errorf("db check is not supported for %T", activeStore)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &DBReport{
+		Clients:  len(snap.clients),
+		Inbounds: len(snap.inbounds),
+		Issues:   snap.check(),
+	}, nil
+}
+
+// RepairDatabases asks approve for every issue, backs up the databases and
+// then applies the approved fixes. The data is re-read and re-checked under
+// the write lock, so only issues that still exist are fixed.
+func RepairDatabases(a Actor, issues []DBIssue, approve func(DBIssue) bool) (*RepairResult, error) {
+	res, err := repairDatabases(issues, approve)
+	if err != nil {
+		return nil, err
+	}
+	for _, is := range res.Applied {
+		Audit(a, "db_repair", is.Subject, nil, map[string]string{
+			"kind": string(is.Kind), "file": is.File, "detail": is.Detail, "fix": is.Fix,
+		})
+	}
+	return res, nil
+}
+
+func repairDatabases(issues []DBIssue, approve func(DBIssue) bool) (*RepairResult, error) {
+	approved := make(map[string]bool)
+	for _, is := range issues {
+		if approve(is) {
+			approved[is.key()] = true
+		}
+	}
+	res := &RepairResult{}
+	if len(approved) == 0 {
+		return res, nil
+	}
+
+	stamp := time.Now().Format("20060102-150405")
+	pick := func(all []DBIssue) []DBIssue {
+		var out []DBIssue
+		for _, is := range all {
+			if approved[is.key()] {
+				out = append(out, is)
+			}
+		}
+		return out
+	}
+
+	switch st := activeStore.(type) {
+	case *FileStore:
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		for _, path := range []string{DB_CLIENTS, DB_INBOUNDS} {
+			unlock, err := flockPath(path)
+			if err != nil {
+				return nil, err
+			}
+			defer unlock()
+		}
+		// Backup di dalam lock: isinya sama dengan yang diperbaiki
+		for _, path := range []string{DB_CLIENTS, DB_INBOUNDS} {
+			dst := fmt.Sprintf("%s.check-bak-%s", path, stamp)
+			if err := backupFile(path, dst); err != nil {
+				return nil, fmt.Errorf("backup failed, nothing changed: %v", err)
+			}
+			res.Backups = append(res.Backups, dst)
+		}
+
+		snap, err := loadFileSnapshot()
+		if err != nil {
+			return nil, err
+		}
+		res.Applied = pick(snap.check())
+		rejected := snap.apply(res.Applied)
+
+		cf, err := readClientsFile(DB_CLIENTS)
+		if err != nil {
+			return nil, err
+		}
+		cf.Clients, cf.Bad = snap.clients, snap.badClients
+		inf := &inboundsFile{Inbounds: snap.inbounds, Bad: snap.badInbounds}
+		if err := appendRejected(rejected); err != nil {
+			return nil, err
+		}
+		if err := WriteFileAtomic(DB_CLIENTS, cf.encode(), 0644); err != nil {
+			return nil, err
+		}
+		if err := WriteFileAtomic(DB_INBOUNDS, inf.encode(), inboundsPerm); err != nil {
+			return nil, err
+		}
+		return res, nil
+
+	case *BoltStore:
+		dst := fmt.Sprintf("%s.check-bak-%s", st.Path, stamp)
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		err = st.CopyTo(out)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("backup failed, nothing changed: %v", err)
+		}
+		res.Backups = append(res.Backups, dst)
+
+		err = st.update(func(tx *bolt.Tx) error {
+			snap, err := loadBoltSnapshot(tx, st.Path)
+			if err != nil {
+				return err
+			}
+			res.Applied = pick(snap.check())
+			// Baris rusak yang dibuang masuk panel.db.rejected, seperti di file store
+			if err := appendRejected(snap.apply(res.Applied)); err != nil {
+				return err
+			}
+			if err := putBadLines(tx, snap.badClients, snap.badInbounds); err != nil {
+				return err
+			}
+			return st.replaceAll(tx, snap.clients, snap.inbounds)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("db repair is not supported for %T", activeStore)
+}
+
+func appendRejected(rejected map[string][]string) error {
+	for path, lines := range rejected {
+		f, err := os.OpenFile(path+".rejected", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(strings.Join(lines, "\n") + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileStore is the original semicolon separated clients.db / inbounds.db backend
+type FileStore struct{}
+
+// inboundsPerm keeps inbounds.db readable by root only, it holds the REALITY private keys
+const inboundsPerm os.FileMode = 0600
+
+// LoadClients returns every record that parses. Lines that do not parse are
+// reported in the log and kept untouched in the file by the writers below.
+func (s *FileStore) LoadClients() ([]Client, error) {
+	f, err := readClientsFile(DB_CLIENTS)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range f.Bad {
+		log.Printf("clients.db: skipping %v (kept in file)", b)
+	}
+	return f.Clients, nil
+}
+
+// SaveClient appends a client. Like every mutation below it runs under the
+// clients.db lock and rewrites the file through WriteFileAtomic.
+func (s *FileStore) SaveClient(c Client) error {
+	return s.mutateClients(func(f *clientsFile) error {
+		for _, cur := range f.Clients {
+			if err := clientConflict(c, cur); err != nil {
+				return err
+			}
+		}
+		f.Clients = append(f.Clients, c)
+		return nil
+	})
+}
+
+func (s *FileStore) UpdateClient(username string, modifier func(*Client)) error {
+	return s.mutateClients(func(f *clientsFile) error {
+		for i := range f.Clients {
+			if f.Clients[i].Username != username {
+				continue
+			}
+			before := f.Clients[i]
+			modifier(&f.Clients[i])
+			c := f.Clients[i]
+			if c.Username == before.Username && c.UUID == before.UUID {
+				return nil
+			}
+			for j, cur := range f.Clients {
+				if j == i {
+					continue
+				}
+				if err := clientConflict(c, cur); err != nil {
+					return err // tidak ditulis
+				}
+			}
+			return nil
+		}
+		return fmt.Errorf("user not found")
+	})
+}
+
+func (s *FileStore) UpdateClients(modifier func(*Client) bool) error {
+	return s.mutateClients(func(f *clientsFile) error {
+		for i := range f.Clients {
+			modifier(&f.Clients[i])
+		}
+		return nil
+	})
+}
+
+func (s *FileStore) DeleteClient(username string, before func(Client) error) error {
+	return s.mutateClients(func(f *clientsFile) error {
+		for i, c := range f.Clients {
+			if c.Username != username {
+				continue
+			}
+			if err := before(c); err != nil {
+				return err
+			}
+			f.Clients = append(f.Clients[:i], f.Clients[i+1:]...)
+			return nil
+		}
+		return errUserNotFound(username)
+	})
+}
+
+// mutateClients reads, modifies and atomically rewrites clients.db under its lock.
+// The file is always written back in the current format version.
+func (s *FileStore) mutateClients(fn func(f *clientsFile) error) error {
+	return withFileLock(DB_CLIENTS, func() error {
+		f, err := readClientsFile(DB_CLIENTS)
+		if err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+		return WriteFileAtomic(DB_CLIENTS, f.encode(), 0644)
+	})
+}
+
+// Migrate rewrites an older clients.db in the current format, keeping a
+// backup of the original. Unparsable lines are carried over unchanged.
+func (s *FileStore) Migrate() error {
+	f, err := readClientsFile(DB_CLIENTS)
+	if err != nil || f.Version >= ClientsDBVersion {
+		return err
+	}
+	backup := fmt.Sprintf("%s.v%d.bak-%s", DB_CLIENTS, f.Version, time.Now().Format("20060102-150405"))
+	if err := backupFile(DB_CLIENTS, backup); err != nil {
+		return err
+	}
+	log.Printf("Migrating %s from v%d to v%d (backup: %s)", DB_CLIENTS, f.Version, ClientsDBVersion, backup)
+	return s.mutateClients(func(*clientsFile) error { return nil })
+}
+
+// inboundsFile is the parsed content of inbounds.db
+// Format per line: active;protocol-trans;port, vless-reality lines carry
+// their settings as extra key=value fields (see reality.go)
+type inboundsFile struct {
+	Inbounds []InboundDet
+	Lines    []int // line number of each inbound, only valid right after parsing
+	Bad      []BadLine
+}
+
+func readInboundsFile(path string) (*inboundsFile, error) {
+	f := &inboundsFile{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseInboundsFile(file)
+}
+
+func parseInboundsFile(r io.Reader) (*inboundsFile, error) {
+	f := &inboundsFile{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		inb, err := parseInboundLine(line)
+		if err != nil {
+			f.Bad = append(f.Bad, BadLine{Line: lineNo, Text: line, Err: err})
+			continue
+		}
+		f.Inbounds = append(f.Inbounds, inb)
+		f.Lines = append(f.Lines, lineNo)
+	}
+	return f, scanner.Err()
+}
+
+// inboundLine renders one inbounds.db line
+func inboundLine(inb InboundDet) string {
+	if inb.Reality != nil {
+		return fmt.Sprintf("active;%s;%d;%s", inb.Tag, inb.Port, inb.Reality.encodeFields())
+	}
+	return fmt.Sprintf("active;%s;%d", inb.Tag, inb.Port)
+}
+
+func parseInboundLine(line string) (InboundDet, error) {
+	parts := strings.Split(line, ";")
+	if len(parts) < 3 {
+		return InboundDet{}, fmt.Errorf("expected 3 fields, got %d", len(parts))
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+	if err != nil || port < 1 || port > 65535 {
+		return InboundDet{}, fmt.Errorf("bad port %q", parts[2])
+	}
+	tagFull := strings.TrimSpace(parts[1])
+
+	// Split Tag (vless-xtls) -> vless, xtls
+	tagParts := strings.Split(tagFull, "-")
+	if len(tagParts) != 2 || tagParts[0] == "" || tagParts[1] == "" {
+		return InboundDet{}, fmt.Errorf("bad tag %q (want protocol-transport)", tagFull)
+	}
+	inb := InboundDet{
+		Tag:       tagFull,
+		Protocol:  tagParts[0],
+		Transport: tagParts[1],
+		Port:      port,
+	}
+	if inb.Transport == RealityTransport {
+		if inb.Protocol != "vless" {
+			return InboundDet{}, fmt.Errorf("reality is only supported for vless")
+		}
+		if inb.Reality, err = parseRealityFields(parts[3:]); err != nil {
+			return InboundDet{}, err
+		}
+	}
+	return inb, nil
+}
+
+func (f *inboundsFile) encode() []byte {
+	var sb strings.Builder
+	for _, inb := range f.Inbounds {
+		sb.WriteString(inboundLine(inb) + "\n")
+	}
+	for _, b := range f.Bad {
+		sb.WriteString(b.Text + "\n")
+	}
+	return []byte(sb.String())
+}
+
+func (s *FileStore) LoadAllInbounds() ([]InboundDet, error) {
+	f, err := readInboundsFile(DB_INBOUNDS)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range f.Bad {
+		log.Printf("inbounds.db: skipping %v (kept in file)", b)
+	}
+	return f.Inbounds, nil
+}
+
+func (s *FileStore) SaveInbound(inb InboundDet) error {
+	return s.mutateInbounds(func(f *inboundsFile) error {
+		for _, cur := range f.Inbounds {
+			if err := inboundConflict(inb, cur); err != nil {
+				return err
+			}
+		}
+		f.Inbounds = append(f.Inbounds, inb)
+		return nil
+	})
+}
+
+func (s *FileStore) DeleteInbound(targetPort int) error {
+	return s.mutateInbounds(func(f *inboundsFile) error {
+		var kept []InboundDet
+		for _, inb := range f.Inbounds {
+			if inb.Port == targetPort {
+				continue // Skip deleted
+			}
+			kept = append(kept, inb)
+		}
+		f.Inbounds = kept
+		return nil
+	})
+}
+
+// mutateInbounds reads, modifies and atomically rewrites inbounds.db under its lock
+func (s *FileStore) mutateInbounds(fn func(f *inboundsFile) error) error {
+	return withFileLock(DB_INBOUNDS, func() error {
+		f, err := readInboundsFile(DB_INBOUNDS)
+		if err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+		return WriteFileAtomic(DB_INBOUNDS, f.encode(), inboundsPerm)
+	})
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// writeMu makes every database mutation in this process go through a single writer.
+// The flock in withFileLock extends that to the other processes (web, bot, CLI, timers).
+var writeMu sync.Mutex
+
+// withFileLock runs fn while holding the process mutex and an exclusive flock on path.lock
+func withFileLock(path string, fn func() error) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	unlock, err := flockPath(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fn()
+}
+
+// flockPath takes the inter-process lock for path; the caller must hold writeMu
+func flockPath(path string) (func(), error) {
+	lf, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lf.Fd()), syscall.LOCK_EX); err != nil {
+		lf.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(lf.Fd()), syscall.LOCK_UN)
+		lf.Close()
+	}, nil
+}
+
+// WriteFileAtomic writes data to a temp file in the same directory, fsyncs it
+// and renames it over path, so readers never see a half-written file.
+// An existing file keeps its mode unless perm is private (e.g. 0600).
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	// Kalau gagal di tengah jalan, hapus file temp
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Pertahankan permission file lama (mis. chmod 666 dari installer),
+	// kecuali file rahasia (perm tanpa bit group/other) yang selalu dipaksa
+	if st, err := os.Stat(path); err == nil && perm&0077 != 0 {
+		perm = st.Mode().Perm()
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	// fsync directory so the rename itself survives a crash
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Notes, tags and the client search shared by the dashboard, the CLI user
+// list and the bot /find command. The search syntax is free text plus
+// key:value words, e.g. "budi tag:vip status:active expires:7 usage:>80".
+
+const (
+	NotesMaxLen = 500
+	TagMaxLen   = 24
+	TagsMax     = 10
+)
+
+var tagRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ParseTags reads a comma or space separated tag list. Tags are lowercased
+// and duplicates dropped; clients.db stores them comma separated.
+func ParseTags(s string) ([]string, error) {
+	var tags []string
+	seen := make(map[string]bool)
+	split := func(r rune) bool { return r == ',' || unicode.IsSpace(r) }
+	for _, t := range strings.FieldsFunc(strings.ToLower(s), split) {
+		if len(t) > TagMaxLen || !tagRe.MatchString(t) {
+			return nil, fmt.Errorf("invalid tag %q: letters, digits, '_' and '-' only, max %d characters", t, TagMaxLen)
+		}
+		if !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) > TagsMax {
+		return nil, fmt.Errorf("at most %d tags per client", TagsMax)
+	}
+	return tags, nil
+}
+
+// ParseNotes trims notes typed by a user and checks the length
+func ParseNotes(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if n := len([]rune(s)); n > NotesMaxLen {
+		return "", fmt.Errorf("notes are too long (%d characters, max %d)", n, NotesMaxLen)
+	}
+	return s, nil
+}
+
+// SetClientNotes replaces the notes of one client
+func SetClientNotes(a Actor, username, notes string) error {
+	notes, err := ParseNotes(notes)
+	if err != nil {
+		return err
+	}
+	return updateClient(a, "notes", username, func(c *Client) {
+		c.Notes = notes
+	})
+}
+
+// SetClientTags replaces the tags of one client
+func SetClientTags(a Actor, username string, tags []string) error {
+	return updateClient(a, "tags", username, func(c *Client) {
+		c.Tags = tags
+	})
+}
+
+// TagList returns every tag used by the clients, sorted
+func TagList(clients []Client) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, c := range clients {
+		for _, t := range c.Tags {
+			if !seen[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// ClientFilter selects clients; zero fields match everything
+type ClientFilter struct {
+	Text          string       // words that must all appear in username, notes or tags
+	Tag           string       // exact tag
+	Status        ClientStatus // "expired" also matches active clients past their expiry
+	Inbound       string       // attached inbound tag
+	ExpiresWithin int          // days; only clients expiring between now and then
+	UsageMin      int          // percent of quota, at least
+	UsageMax      int          // percent of quota, below
+	NeverUsed     bool         // no traffic at all (usage:0)
+}
+
+// FilterKeys lists the key:value words understood by ParseClientFilter
+var FilterKeys = []string{"tag", "status", "inbound", "expires", "usage"}
+
+// ParseClientFilter reads a search. Words starting with one of FilterKeys
+// and a colon are filters, every other word (also "note:x" or a URL) is
+// free text.
+func ParseClientFilter(q string) (ClientFilter, error) {
+	var f ClientFilter
+	var words []string
+	for _, part := range strings.Fields(q) {
+		key, val, ok := strings.Cut(part, ":")
+		if !ok || !slices.Contains(FilterKeys, strings.ToLower(key)) {
+			words = append(words, part)
+			continue
+		}
+		if err := f.Set(key, val); err != nil {
+			return f, err
+		}
+	}
+	f.Text = strings.Join(words, " ")
+	return f, nil
+}
+
+// Set applies one key of the search syntax; an empty value is ignored
+func (f *ClientFilter) Set(key, val string) error {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return nil
+	}
+	switch strings.ToLower(key) {
+	case "tag":
+		f.Tag = strings.ToLower(val)
+	case "status":
+		st, err := ParseStatus(strings.ToLower(val))
+		if err != nil {
+			return err
+		}
+		f.Status = st
+	case "inbound":
+		f.Inbound = val
+	case "expires":
+		days, err := strconv.Atoi(strings.TrimSuffix(val, "d"))
+		if err != nil || days <= 0 {
+			return fmt.Errorf("expires: number of days, e.g. expires:7")
+		}
+		f.ExpiresWithin = days
+	case "usage":
+		below := strings.HasPrefix(val, "<")
+		pct, err := strconv.Atoi(strings.TrimLeft(val, "<>="))
+		if err != nil || pct < 0 || (pct == 0 && val != "0") {
+			return fmt.Errorf("usage: percent of quota, e.g. usage:80 (at least), usage:<10 (below) or usage:0 (never used)")
+		}
+		switch {
+		case pct == 0:
+			f.NeverUsed = true
+		case below:
+			f.UsageMax = pct
+		default:
+			f.UsageMin = pct
+		}
+	default:
+		return fmt.Errorf("unknown filter %q (use %s)", key, strings.Join(FilterKeys, ", "))
+	}
+	return nil
+}
+
+// IsZero reports whether the filter matches every client
+func (f ClientFilter) IsZero() bool {
+	return f == ClientFilter{}
+}
+
+// String renders the filter back in the search syntax
+func (f ClientFilter) String() string {
+	var parts []string
+	if f.Text != "" {
+		parts = append(parts, f.Text)
+	}
+	if f.Tag != "" {
+		parts = append(parts, "tag:"+f.Tag)
+	}
+	if f.Status != "" {
+		parts = append(parts, "status:"+string(f.Status))
+	}
+	if f.Inbound != "" {
+		parts = append(parts, "inbound:"+f.Inbound)
+	}
+	if f.ExpiresWithin > 0 {
+		parts = append(parts, fmt.Sprintf("expires:%d", f.ExpiresWithin))
+	}
+	if f.UsageMin > 0 {
+		parts = append(parts, fmt.Sprintf("usage:%d", f.UsageMin))
+	}
+	if f.UsageMax > 0 {
+		parts = append(parts, fmt.Sprintf("usage:<%d", f.UsageMax))
+	}
+	if f.NeverUsed {
+		parts = append(parts, "usage:0")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Match reports whether c passes every set field of the filter
+func (f ClientFilter) Match(c Client, now time.Time) bool {
+	if f.Tag != "" && !slices.Contains(c.Tags, f.Tag) {
+		return false
+	}
+	if f.Status != "" {
+		status := c.Status
+		if status == StatusActive && now.After(c.Expiry) {
+			status = StatusExpired // cron belum sempat mengubah status
+		}
+		if status != f.Status {
+			return false
+		}
+	}
+	if f.Inbound != "" && !slices.Contains(c.InboundTags(), f.Inbound) {
+		return false
+	}
+	if f.ExpiresWithin > 0 && (!c.Expiry.After(now) || c.Expiry.After(now.AddDate(0, 0, f.ExpiresWithin))) {
+		return false
+	}
+	// Client tanpa quota tidak punya persentase pemakaian
+	if (f.UsageMin > 0 || f.UsageMax > 0) && c.Quota <= 0 {
+		return false
+	}
+	if f.UsageMin > 0 && c.UsagePercent() < f.UsageMin {
+		return false
+	}
+	if f.UsageMax > 0 && c.UsagePercent() >= f.UsageMax {
+		return false
+	}
+	if f.NeverUsed && c.Used > 0 {
+		return false
+	}
+	for _, w := range strings.Fields(strings.ToLower(f.Text)) {
+		if !c.containsText(w) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Client) containsText(w string) bool {
+	if strings.Contains(strings.ToLower(c.Username), w) || strings.Contains(strings.ToLower(c.Notes), w) {
+		return true
+	}
+	for _, t := range c.Tags {
+		if strings.Contains(t, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterClients returns the clients matching f, in their original order
+func FilterClients(clients []Client, f ClientFilter) []Client {
+	if f.IsZero() {
+		return clients
+	}
+	now := time.Now()
+	var out []Client
+	for _, c := range clients {
+		if f.Match(c, now) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
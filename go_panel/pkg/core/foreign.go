@@ -0,0 +1,734 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Import from another panel running on the server we take over:
+//
+//	3x-ui / x-ui   sqlite database (/etc/x-ui/x-ui.db)
+//	Marzban        sqlite database (/var/lib/marzban/db.sqlite3) or the
+//	               JSON of GET /api/users
+//	Xray           a hand-written config.json (adopt.go)
+//
+// Inbounds are mapped to protocol-transport tags of this panel, clients keep
+// their UUID or trojan password, quota, usage and expiry. Everything that cannot be carried
+// over is listed in Unmapped instead of being dropped silently.
+
+const (
+	Source3XUI    = "3x-ui"
+	SourceXUI     = "x-ui"
+	SourceMarzban = "marzban"
+)
+
+// Klien tanpa tanggal expired di panel lama diberi expired sejauh ini
+const foreignNoExpiryYears = 10
+
+// ForeignImport is what ReadForeignPanel mapped from another panel
+type ForeignImport struct {
+	Source      string
+	Inbounds    []ForeignInbound
+	Clients     []Client
+	ClientNotes map[string][]string // username -> what changed while mapping
+	Unmapped    []string
+	Overlay     map[string]interface{} // parts of an adopted config.json kept as a config overlay
+}
+
+// ForeignInbound is one inbound of the other panel and its mapping
+type ForeignInbound struct {
+	Remark string
+	InboundDet
+}
+
+// ForeignReport is the outcome of ApplyForeignImport
+type ForeignReport struct {
+	Source   string
+	Inbounds []ImportResult // Username holds "tag:port"
+	Clients  ImportReport
+	Unmapped []string
+	Overlay  string // path of the written config overlay, if any
+}
+
+func (fi *ForeignImport) unmapped(format string, args ...interface{}) {
+	fi.Unmapped = append(fi.Unmapped, fmt.Sprintf(format, args...))
+}
+
+func (fi *ForeignImport) note(username, format string, args ...interface{}) {
+	fi.ClientNotes[username] = append(fi.ClientNotes[username], fmt.Sprintf(format, args...))
+}
+
+// ReadForeignPanel reads a 3x-ui/x-ui or Marzban database, a Marzban JSON
+// export or an Xray config.json
+func ReadForeignPanel(path string) (*ForeignImport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fi := &ForeignImport{ClientNotes: make(map[string][]string)}
+	if !bytes.HasPrefix(data, []byte("SQLite format 3\x00")) {
+		if isXrayConfig(data) {
+			fi.Source = SourceXrayConfig
+			if err := fi.readXrayConfig(data); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			return fi, nil
+		}
+		fi.Source = SourceMarzban
+		if err := fi.readMarzbanJSON(data); err != nil {
+			return nil, err
+		}
+		return fi, nil
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	tables, err := sqliteTables(db)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	switch {
+	case tables["inbounds"] && tables["client_traffics"]:
+		fi.Source = Source3XUI
+		err = fi.readXUI(db, true)
+	case tables["inbounds"]:
+		fi.Source = SourceXUI
+		err = fi.readXUI(db, false)
+	case tables["users"] && tables["proxies"]:
+		fi.Source = SourceMarzban
+		err = fi.readMarzbanDB(db)
+	default:
+		return nil, fmt.Errorf("%s is not a 3x-ui, x-ui or Marzban database", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fi.Source, err)
+	}
+	return fi, nil
+}
+
+func sqliteTables(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables[name] = true
+	}
+	return tables, rows.Err()
+}
+
+// --- 3x-ui / x-ui ---
+
+type xuiClient struct {
+	ID         string          `json:"id"`
+	Password   string          `json:"password"`
+	Email      string          `json:"email"`
+	Flow       string          `json:"flow"`
+	TotalGB    int64           `json:"totalGB"` // bytes, despite the name
+	ExpiryTime int64           `json:"expiryTime"`
+	Enable     *bool           `json:"enable"`
+	LimitIP    int             `json:"limitIp"`
+	TgID       json.RawMessage `json:"tgId"` // string or number depending on version
+	Comment    string          `json:"comment"`
+	Reset      int             `json:"reset"` // renew every N days
+}
+
+func (fi *ForeignImport) readXUI(db *sql.DB, perClient bool) error {
+	traffic := make(map[string]float64)
+	if perClient {
+		rows, err := db.Query(`SELECT email, up, down FROM client_traffics`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var email string
+			var up, down int64
+			if err := rows.Scan(&email, &up, &down); err != nil {
+				rows.Close()
+				return err
+			}
+			traffic[email] = float64(up + down)
+		}
+		rows.Close()
+	}
+
+	rows, err := db.Query(`SELECT up, down, total, remark, enable, expiry_time, port, protocol, settings, stream_settings FROM inbounds ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	used := make(map[string]bool)
+	for rows.Next() {
+		var up, down, total, expiry int64
+		var port int
+		var enable bool
+		var remark, protocol, settings, stream string
+		if err := rows.Scan(&up, &down, &total, &remark, &enable, &expiry, &port, &protocol, &settings, &stream); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("inbound %q (%s port %d)", remark, protocol, port)
+
+		var cfg struct {
+			Clients []xuiClient `json:"clients"`
+		}
+		if err := json.Unmarshal([]byte(settings), &cfg); err != nil {
+			fi.unmapped("%s: settings: %v", name, err)
+			continue
+		}
+		// stream_settings kosong = TCP tanpa TLS, rusak = tidak bisa dipetakan
+		var st StreamSettings
+		if strings.TrimSpace(stream) != "" {
+			if err := json.Unmarshal([]byte(stream), &st); err != nil {
+				fi.unmapped("%s: stream settings: %v, %d client(s) not imported", name, err, len(cfg.Clients))
+				continue
+			}
+		}
+		protocol = strings.ToLower(protocol)
+		det, ok := fi.mapInbound(name, protocol, st)
+		tag := det.Tag
+		if !ok {
+			if len(cfg.Clients) > 0 {
+				fi.unmapped("%s: %d client(s) not imported", name, len(cfg.Clients))
+			}
+			continue
+		}
+		det.Port = port
+		fi.addInbound(name, ForeignInbound{Remark: remark, InboundDet: det})
+
+		for i, xc := range cfg.Clients {
+			source := xc.Email
+			if source == "" {
+				source = fmt.Sprintf("%s-%d", remark, i+1)
+			}
+			c := Client{
+				Username:  foreignUsername(source, used),
+				CreatedAt: time.Now(),
+				Notes:     xc.Comment,
+			}
+			c.SetInbounds([]string{tag})
+			if c.Username != source {
+				fi.note(c.Username, "renamed from %s", source)
+			}
+			if protocol == "trojan" {
+				fi.trojanCredential(&c, xc.Password)
+			} else if c.UUID = NormalizeUUID(xc.ID); CheckUUIDFormat(c.UUID) != nil {
+				fi.note(c.Username, "%s credential is not a UUID, a new UUID is generated", protocol)
+				c.UUID = GenerateUUID()
+			}
+
+			// x-ui lama: quota, expired dan usage ada di inbound, bukan di client
+			quota, expiryMs, usage := xc.TotalGB, xc.ExpiryTime, traffic[xc.Email]
+			if !perClient {
+				quota, expiryMs = total, expiry
+				if i == 0 {
+					usage = float64(up + down)
+				}
+				if len(cfg.Clients) > 1 && i == 0 {
+					fi.note(c.Username, "x-ui counts traffic per inbound; all usage of %q is assigned to this client", remark)
+				}
+			}
+			c.Quota = foreignQuota(quota)
+			c.Used = usage
+			c.Expiry = fi.foreignExpiry(c.Username, expiryMs)
+			if xc.LimitIP > 0 {
+				c.DeviceLimit = xc.LimitIP
+			}
+			if id := strings.Trim(string(xc.TgID), `" `); id != "" && id != "0" && id != "null" {
+				if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+					c.TelegramID = n
+				} else {
+					fi.note(c.Username, "telegram id %q not imported", id)
+				}
+			}
+			if xc.Reset > 0 {
+				if period, ok := foreignResetDays(xc.Reset); ok {
+					c.ResetPeriod = period
+					c.LastReset = time.Now()
+				} else {
+					fi.note(c.Username, "renew every %d days is not supported, no quota reset set", xc.Reset)
+				}
+			}
+			switch {
+			case !enable:
+				c.SetStatus(StatusDisabled, "inbound disabled in "+fi.Source)
+			case xc.Enable != nil && !*xc.Enable:
+				c.SetStatus(StatusDisabled, "disabled in "+fi.Source)
+			default:
+				c.SetStatus(StatusActive, "imported from "+fi.Source)
+			}
+			fi.Clients = append(fi.Clients, c)
+		}
+	}
+	return rows.Err()
+}
+
+// addInbound records a mapped inbound. Clients are attached to a tag, not a
+// port, so a second inbound with the same protocol and transport is merged
+// into the first instead of serving every client of both on both ports.
+// Reports whether the inbound was added.
+func (fi *ForeignImport) addInbound(name string, fin ForeignInbound) bool {
+	for _, cur := range fi.Inbounds {
+		if cur.Tag != fin.Tag {
+			continue
+		}
+		if cur.Port != fin.Port {
+			fi.unmapped("%s: same protocol and transport as %q on port %d, merged into it; its clients move to port %d and need new share links",
+				name, cur.Remark, cur.Port, cur.Port)
+		}
+		return false
+	}
+	fi.Inbounds = append(fi.Inbounds, fin)
+	return true
+}
+
+// mapInbound returns the inbound of this panel (without port) for a
+// foreign inbound
+func (fi *ForeignImport) mapInbound(name, protocol string, st StreamSettings) (InboundDet, bool) {
+	switch protocol {
+	case "vless", "vmess", "trojan":
+	default:
+		fi.unmapped("%s: protocol %s is not supported", name, protocol)
+		return InboundDet{}, false
+	}
+	var transport string
+	var reality *InboundReality
+	switch st.Network {
+	case "tcp", "":
+		switch st.Security {
+		case "tls", "xtls":
+			transport = "xtls"
+		case "reality":
+			if protocol != "vless" {
+				fi.unmapped("%s: REALITY is only supported for VLESS", name)
+				return InboundDet{}, false
+			}
+			r, err := realityFromXray(st.RealitySettings)
+			if err != nil {
+				fi.unmapped("%s: %v", name, err)
+				return InboundDet{}, false
+			}
+			transport, reality = RealityTransport, r
+		default:
+			fi.unmapped("%s: TCP without TLS is not supported", name)
+			return InboundDet{}, false
+		}
+		if protocol == "vmess" {
+			fi.unmapped("%s: VMess over TCP is not offered by this panel", name)
+			return InboundDet{}, false
+		}
+	case "ws":
+		transport = "ws"
+		if st.WSSettings != nil && st.WSSettings.Path != "" && st.WSSettings.Path != "/"+protocol+"-ws" {
+			fi.unmapped("%s: path %s becomes /%s-ws, share links must be sent again", name, st.WSSettings.Path, protocol)
+		}
+	case "grpc":
+		transport = "grpc"
+		if st.GRPCSettings != nil && st.GRPCSettings.ServiceName != "" && st.GRPCSettings.ServiceName != protocol+"-grpc" {
+			fi.unmapped("%s: service name %s becomes %s-grpc, share links must be sent again", name, st.GRPCSettings.ServiceName, protocol)
+		}
+	default:
+		fi.unmapped("%s: transport %s is not supported", name, st.Network)
+		return InboundDet{}, false
+	}
+	if transport != "xtls" && transport != RealityTransport && st.Security != "tls" {
+		fi.unmapped("%s: served with TLS by this panel (was %q)", name, st.Security)
+	}
+	return InboundDet{
+		Tag:       protocol + "-" + transport,
+		Protocol:  protocol,
+		Transport: transport,
+		Reality:   reality,
+	}, true
+}
+
+// --- Marzban ---
+
+type marzbanUser struct {
+	Username      string                     `json:"username"`
+	Status        string                     `json:"status"`
+	UsedTraffic   int64                      `json:"used_traffic"`
+	DataLimit     *int64                     `json:"data_limit"`
+	Expire        *int64                     `json:"expire"` // unix seconds
+	Note          string                     `json:"note"`
+	ResetStrategy string                     `json:"data_limit_reset_strategy"`
+	CreatedAt     string                     `json:"created_at"`
+	Proxies       map[string]json.RawMessage `json:"proxies"`
+	Inbounds      map[string][]string        `json:"inbounds"`
+	OnHoldSeconds *int64                     `json:"on_hold_expire_duration"`
+}
+
+func (fi *ForeignImport) readMarzbanJSON(data []byte) error {
+	var export struct {
+		Users []marzbanUser `json:"users"`
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &export.Users); err != nil {
+			return fmt.Errorf("marzban export: %v", err)
+		}
+	} else if err := json.Unmarshal(data, &export); err != nil {
+		return fmt.Errorf("not a 3x-ui/x-ui/Marzban database or Marzban export: %v", err)
+	}
+	return fi.addMarzbanUsers(export.Users)
+}
+
+func (fi *ForeignImport) readMarzbanDB(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id, username, status, used_traffic, data_limit, expire, COALESCE(note, ''),
+		COALESCE(data_limit_reset_strategy, ''), COALESCE(created_at, '') FROM users ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var users []marzbanUser
+	byID := make(map[int64]int)
+	for rows.Next() {
+		var id int64
+		var u marzbanUser
+		var limit, expire sql.NullInt64
+		if err := rows.Scan(&id, &u.Username, &u.Status, &u.UsedTraffic, &limit, &expire, &u.Note, &u.ResetStrategy, &u.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if limit.Valid {
+			u.DataLimit = &limit.Int64
+		}
+		if expire.Valid {
+			u.Expire = &expire.Int64
+		}
+		u.Proxies = make(map[string]json.RawMessage)
+		byID[id] = len(users)
+		users = append(users, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(`SELECT user_id, type, settings FROM proxies`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uid int64
+		var typ, settings string
+		if err := rows.Scan(&uid, &typ, &settings); err != nil {
+			return err
+		}
+		if i, ok := byID[uid]; ok {
+			users[i].Proxies[strings.ToLower(typ)] = json.RawMessage(settings)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return fi.addMarzbanUsers(users)
+}
+
+// addMarzbanUsers maps Marzban users. Marzban keeps its inbound definitions
+// in its own xray config, so clients are attached to every inbound of this
+// panel with a protocol the user has a proxy for.
+func (fi *ForeignImport) addMarzbanUsers(users []marzbanUser) error {
+	inbounds, err := LoadAllInbounds()
+	if err != nil {
+		return err
+	}
+	byProto := make(map[string][]string)
+	for _, inb := range inbounds {
+		byProto[inb.Protocol] = append(byProto[inb.Protocol], inb.Tag)
+	}
+	fi.unmapped("Marzban inbound definitions are not part of the export; clients are attached to the existing inbounds of their protocols")
+
+	seenTags := make(map[string]bool)
+	used := make(map[string]bool)
+	for _, u := range users {
+		c := Client{Username: foreignUsername(u.Username, used), Notes: u.Note, CreatedAt: time.Now()}
+		if c.Username != u.Username {
+			fi.note(c.Username, "renamed from %s", u.Username)
+		}
+		created := strings.Replace(strings.SplitN(u.CreatedAt, ".", 2)[0], " ", "T", 1)
+		if t, err := time.Parse("2006-01-02T15:04:05", strings.TrimSuffix(created, "Z")); err == nil {
+			c.CreatedAt = t
+		}
+
+		// Satu UUID untuk vless dan vmess (vless dulu); password trojan disimpan apa adanya
+		var tags []string
+		for _, proto := range []string{"vless", "vmess", "trojan"} {
+			raw, ok := u.Proxies[proto]
+			if !ok {
+				continue
+			}
+			var p struct {
+				ID       string `json:"id"`
+				Password string `json:"password"`
+			}
+			json.Unmarshal(raw, &p)
+			cred := NormalizeUUID(p.ID)
+			switch {
+			case proto == "trojan":
+				fi.trojanCredential(&c, p.Password)
+			case cred == "":
+			case CheckUUIDFormat(cred) != nil:
+				fi.note(c.Username, "%s credential is not a UUID", proto)
+			case c.UUID == "":
+				c.UUID = cred
+			case cred != c.UUID:
+				fi.note(c.Username, "%s UUID differs, this panel uses one UUID for VLESS and VMess", proto)
+			}
+			if len(byProto[proto]) == 0 {
+				fi.note(c.Username, "no %s inbound on this server", proto)
+			}
+			tags = append(tags, byProto[proto]...)
+		}
+		for proto := range u.Proxies {
+			if proto != "vless" && proto != "vmess" && proto != "trojan" {
+				fi.note(c.Username, "%s proxy is not supported", proto)
+			}
+		}
+		for _, names := range u.Inbounds {
+			for _, n := range names {
+				seenTags[n] = true
+			}
+		}
+		if c.UUID == "" {
+			fi.note(c.Username, "no usable UUID, a new UUID is generated")
+			c.UUID = GenerateUUID()
+		}
+		c.SetInbounds(tags)
+
+		if u.DataLimit != nil {
+			c.Quota = foreignQuota(*u.DataLimit)
+		}
+		c.Used = float64(u.UsedTraffic)
+		var expiryMs int64
+		if u.Expire != nil {
+			expiryMs = *u.Expire * 1000
+		}
+		switch strings.ToLower(u.ResetStrategy) {
+		case "", "no_reset":
+		case "day":
+			c.ResetPeriod = ResetDaily
+		case "week":
+			c.ResetPeriod = ResetWeekly
+		case "month":
+			c.ResetPeriod = ResetMonthly
+		default:
+			fi.note(c.Username, "reset strategy %s is not supported", u.ResetStrategy)
+		}
+		if c.ResetPeriod != ResetNone {
+			c.LastReset = time.Now()
+		}
+
+		switch strings.ToLower(u.Status) {
+		case "disabled":
+			c.SetStatus(StatusDisabled, "disabled in Marzban")
+		case "limited":
+			c.SetStatus(StatusQuotaExceeded, "limited in Marzban")
+		case "expired":
+			c.SetStatus(StatusExpired, "expired in Marzban")
+		case "on_hold":
+			// Masa aktif on hold dihitung sejak pemakaian pertama; di sini sejak import
+			if u.OnHoldSeconds != nil && *u.OnHoldSeconds > 0 {
+				expiryMs = time.Now().Add(time.Duration(*u.OnHoldSeconds) * time.Second).UnixMilli()
+			}
+			fi.note(c.Username, "on hold in Marzban, the validity period starts now")
+			c.SetStatus(StatusActive, "imported from Marzban (on hold)")
+		default:
+			c.SetStatus(StatusActive, "imported from Marzban")
+		}
+		c.Expiry = fi.foreignExpiry(c.Username, expiryMs)
+		fi.Clients = append(fi.Clients, c)
+	}
+
+	var names []string
+	for n := range seenTags {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		fi.unmapped("Marzban inbounds in use: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// --- helpers ---
+
+var foreignNameRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// foreignUsername turns an email / remark into a valid, unused username
+func foreignUsername(source string, used map[string]bool) string {
+	name := foreignNameRe.ReplaceAllString(strings.TrimSpace(source), "_")
+	name = strings.TrimLeft(name, "._-")
+	if len(name) > UsernameMaxLen {
+		name = name[:UsernameMaxLen]
+	}
+	for len(name) < UsernameMinLen {
+		name += "_"
+	}
+	if used[strings.ToLower(name)] {
+		byName := make(map[string]Client, len(used))
+		for n := range used {
+			byName[n] = Client{}
+		}
+		name = freeUsername(name, byName)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// trojanCredential keeps an imported trojan password as it is: trojan
+// passwords are free text, not UUIDs. A client without a UUID yet takes
+// the password as UUID when it is one, otherwise gets a new UUID for the
+// other protocols.
+func (fi *ForeignImport) trojanCredential(c *Client, password string) {
+	if password == "" {
+		if c.UUID == "" {
+			c.UUID = GenerateUUID()
+		}
+		fi.note(c.Username, "trojan password is empty, the UUID is used as password")
+		return
+	}
+	if c.UUID == "" {
+		if id := NormalizeUUID(password); CheckUUIDFormat(id) == nil {
+			c.UUID = id
+		} else {
+			c.UUID = GenerateUUID()
+		}
+	}
+	if password != c.UUID {
+		c.Password = password
+	}
+}
+
+// foreignExpiry converts a millisecond timestamp; 0 = never, negative =
+// 3x-ui "start after first use" duration
+func (fi *ForeignImport) foreignExpiry(username string, ms int64) time.Time {
+	switch {
+	case ms > 0:
+		return time.UnixMilli(ms)
+	case ms < 0:
+		fi.note(username, "validity starts on first use in %s, counted from now", fi.Source)
+		return time.Now().Add(time.Duration(-ms) * time.Millisecond)
+	}
+	t := time.Now().AddDate(foreignNoExpiryYears, 0, 0)
+	fi.note(username, "never expires in %s, expiry set to %s", fi.Source, t.Format("2006-01-02"))
+	return t
+}
+
+// foreignQuota converts bytes to GB; a limit below the 0.01 GB clients.db
+// keeps must not turn into 0 (= unlimited)
+func foreignQuota(bytes int64) float64 {
+	if bytes <= 0 {
+		return 0
+	}
+	return max(float64(bytes)/(1<<30), 0.01)
+}
+
+func foreignResetDays(days int) (string, bool) {
+	switch days {
+	case 1:
+		return ResetDaily, true
+	case 7:
+		return ResetWeekly, true
+	case 30, 31:
+		return ResetMonthly, true
+	}
+	return "", false
+}
+
+// ApplyForeignImport creates the mapped inbounds and imports the clients.
+// Like ImportClients it never syncs the Xray config.
+func ApplyForeignImport(a Actor, fi *ForeignImport, opt ImportOptions) (ForeignReport, error) {
+	report := ForeignReport{Source: fi.Source, Unmapped: fi.Unmapped}
+	current, err := LoadAllInbounds()
+	if err != nil {
+		return report, err
+	}
+	byPort := make(map[int]InboundDet)
+	byTag := make(map[string]InboundDet)
+	for _, inb := range current {
+		byPort[inb.Port] = inb
+		byTag[inb.Tag] = inb
+	}
+
+	var pending []string
+	for _, fin := range fi.Inbounds {
+		res := ImportResult{Username: fmt.Sprintf("%s:%d", fin.Tag, fin.Port), Source: fin.Remark}
+		if cur, ok := byPort[fin.Port]; ok {
+			if cur.Tag == fin.Tag {
+				res.Action = "skipped"
+				res.Note = "already exists"
+			} else {
+				res.Action = "failed"
+				res.Note = "port is used by " + cur.Tag
+			}
+			report.Inbounds = append(report.Inbounds, res)
+			continue
+		}
+		// Tag yang sama di port lain: client ikut inbound yang sudah ada
+		if cur, ok := byTag[fin.Tag]; ok {
+			res.Action = "skipped"
+			res.Note = fmt.Sprintf("merged into %s on port %d, clients need new share links", cur.Tag, cur.Port)
+			report.Inbounds = append(report.Inbounds, res)
+			continue
+		}
+		if !opt.DryRun {
+			if err := activeStore.SaveInbound(fin.InboundDet); err != nil {
+				return report, err
+			}
+			Audit(a, "inbound_add", res.Username, nil, fin.InboundDet.forAudit())
+		}
+		byPort[fin.Port] = fin.InboundDet
+		byTag[fin.Tag] = fin.InboundDet
+		pending = append(pending, fin.Tag)
+		res.Action = "created"
+		report.Inbounds = append(report.Inbounds, res)
+	}
+
+	report.Clients, err = importClients(a, fi.Clients, opt, pending)
+	if err != nil {
+		return report, err
+	}
+	for i := range report.Clients.Results {
+		res := &report.Clients.Results[i]
+		if notes := fi.ClientNotes[res.Source]; len(notes) > 0 {
+			if res.Note != "" {
+				notes = append(notes, res.Note)
+			}
+			res.Note = strings.Join(notes, "; ")
+		}
+	}
+	if len(fi.Overlay) > 0 && !opt.DryRun {
+		if report.Overlay, err = SaveOverlay(a, "50-adopted", fi.Overlay); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// InboundsChanged reports whether inbounds were created (Xray must restart)
+func (r ForeignReport) InboundsChanged() bool {
+	for _, res := range r.Inbounds {
+		if res.Action == "created" {
+			return !r.Clients.DryRun
+		}
+	}
+	return false
+}
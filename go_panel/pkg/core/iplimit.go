@@ -0,0 +1,307 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Device limit: the xray-iplimit timer reads the new lines of the Xray
+// access log every minute and counts the distinct source IPs per email over
+// a sliding window. IPv6 addresses count per /64 (see sharingKey), since one
+// device rotates through many addresses of its prefix. Clients above their
+// DeviceLimit are warned or disabled for a while, depending on the policy
+// in panel.json.
+
+var ACCESS_LOG = "/var/log/xray/access.log"
+var IPLIMIT_STATE = "/etc/xray/iplimit.json"
+
+const (
+	IPLimitWarn    = "warn"
+	IPLimitDisable = "disable"
+)
+
+// Batas baca access log per run, log lama di belakangnya dilewati
+const accessLogMaxRead = 32 << 20
+
+const ipViolationsKeep = 200
+
+// IPViolation is one client seen from more IPs than its limit
+type IPViolation struct {
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	IPs      []string  `json:"ips"`
+	Limit    int       `json:"limit"`
+	Action   string    `json:"action"` // warned or disabled
+}
+
+// IPLimitState is kept between runs in IPLIMIT_STATE
+type IPLimitState struct {
+	Offset     int64                           `json:"offset"`  // bytes of the access log already read
+	Seen       map[string]map[string]time.Time `json:"seen"`    // email -> ip or IPv6 /64 -> last seen
+	Limited    map[string]time.Time            `json:"limited"` // username -> disabled until
+	Warned     map[string]time.Time            `json:"warned"`  // username -> last warning
+	Violations []IPViolation                   `json:"violations"`
+}
+
+// IPLimitResult is the outcome of one CheckIPLimits run
+type IPLimitResult struct {
+	Violations []IPViolation
+	Restored   []string
+	Changed    bool // a client was disabled or restored, config.json must be synced
+}
+
+// LoadIPLimitState returns the saved state; a missing file means empty
+func LoadIPLimitState() (IPLimitState, error) {
+	st := IPLimitState{}
+	data, err := os.ReadFile(IPLIMIT_STATE)
+	if err != nil && !os.IsNotExist(err) {
+		return st, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &st); err != nil {
+			return IPLimitState{}, fmt.Errorf("%s: %v", IPLIMIT_STATE, err)
+		}
+	}
+	if st.Seen == nil {
+		st.Seen = make(map[string]map[string]time.Time)
+	}
+	if st.Limited == nil {
+		st.Limited = make(map[string]time.Time)
+	}
+	if st.Warned == nil {
+		st.Warned = make(map[string]time.Time)
+	}
+	return st, nil
+}
+
+func saveIPLimitState(st IPLimitState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(IPLIMIT_STATE, data, 0644)
+}
+
+// IPCount returns how many distinct IPs (IPv6: /64 prefixes) a client used in
+// the last window
+func (st IPLimitState) IPCount(username string) int {
+	return len(st.Seen[username])
+}
+
+// RecentViolations returns violations since `since`, newest first
+func (st IPLimitState) RecentViolations(since time.Time, n int) []IPViolation {
+	var out []IPViolation
+	for i := len(st.Violations) - 1; i >= 0 && len(out) < n; i-- {
+		if st.Violations[i].Time.Before(since) {
+			break
+		}
+		out = append(out, st.Violations[i])
+	}
+	return out
+}
+
+// CheckIPLimits reads the access log, lifts finished temporary disables and
+// applies the policy to clients above their device limit. The result is
+// filled in even when an error is returned: status changes that did go
+// through are in it and still have to be applied to Xray.
+func CheckIPLimits(a Actor, now time.Time) (IPLimitResult, error) {
+	var res IPLimitResult
+	settings, err := LoadPanelSettings()
+	if err != nil {
+		return res, err
+	}
+	// Hanya dijalankan oleh timer (oneshot, tidak pernah paralel) jadi
+	// iplimit.json tidak perlu lock; status client dikunci oleh store
+	st, err := LoadIPLimitState()
+	if err != nil {
+		return res, err
+	}
+	window := time.Duration(settings.IPLimitWindow) * time.Minute
+	if err := readAccessLog(&st, now.Add(-window)); err != nil {
+		return res, err
+	}
+	for email, ips := range st.Seen {
+		for ip, t := range ips {
+			// State lama masih berisi alamat IPv6 penuh
+			if key := sharingKey(ip); key != ip {
+				delete(ips, ip)
+				if t.After(ips[key]) {
+					ips[key] = t
+				}
+			}
+		}
+		for ip, t := range ips {
+			if now.Sub(t) > window {
+				delete(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			delete(st.Seen, email)
+		}
+	}
+
+	clients, err := LoadClients()
+	if err != nil {
+		return res, err
+	}
+	byName := make(map[string]Client, len(clients))
+	for _, c := range clients {
+		byName[c.Username] = c
+	}
+
+	var failed []string
+	// Masa disable habis: aktif lagi, kecuali status sudah diubah admin
+	for name, until := range st.Limited {
+		if now.Before(until) {
+			continue
+		}
+		if c, ok := byName[name]; ok && c.Status == StatusIPLimited {
+			// Dicek ulang di dalam update, admin bisa sudah mengubah status
+			restored, err := setStatusIf(a, name, StatusActive, func(c Client) (string, bool) {
+				return "device limit block ended", c.Status == StatusIPLimited
+			})
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+				continue // dicoba lagi run berikutnya
+			}
+			if restored {
+				res.Restored = append(res.Restored, name)
+				res.Changed = true
+			}
+		}
+		delete(st.Limited, name)
+	}
+
+	for _, c := range clients {
+		ips := st.Seen[c.Username]
+		if c.DeviceLimit <= 0 || c.Status != StatusActive || len(ips) <= c.DeviceLimit {
+			continue
+		}
+		if last, ok := st.Warned[c.Username]; ok && now.Sub(last) < window {
+			continue // sudah diperingatkan di window ini
+		}
+		v := IPViolation{Time: now, Username: c.Username, Limit: c.DeviceLimit, Action: "warned"}
+		for ip := range ips {
+			v.IPs = append(v.IPs, ip)
+		}
+		sort.Strings(v.IPs)
+
+		if settings.IPLimitPolicy == IPLimitDisable {
+			ban := time.Duration(settings.IPLimitBanMinutes) * time.Minute
+			reason := fmt.Sprintf("%d IPs in %d min (limit %d), until %s",
+				len(ips), settings.IPLimitWindow, c.DeviceLimit, now.Add(ban).Format("15:04"))
+			limited, err := setStatusIf(a, c.Username, StatusIPLimited, func(c Client) (string, bool) {
+				return reason, c.Status == StatusActive
+			})
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", c.Username, err))
+				continue
+			}
+			if !limited {
+				continue // dinonaktifkan / diubah admin sejak LoadClients
+			}
+			st.Limited[c.Username] = now.Add(ban)
+			delete(st.Seen, c.Username)
+			v.Action = "disabled"
+			res.Changed = true
+		} else {
+			Audit(a, "ip_limit_warn", c.Username, nil, v)
+		}
+		st.Warned[c.Username] = now
+		st.Violations = append(st.Violations, v)
+		res.Violations = append(res.Violations, v)
+	}
+	for name, t := range st.Warned {
+		if now.Sub(t) > window {
+			delete(st.Warned, name)
+		}
+	}
+	if len(st.Violations) > ipViolationsKeep {
+		st.Violations = st.Violations[len(st.Violations)-ipViolationsKeep:]
+	}
+	if err := saveIPLimitState(st); err != nil {
+		return res, err
+	}
+	if len(failed) > 0 {
+		return res, fmt.Errorf("status update failed: %s", strings.Join(failed, "; "))
+	}
+	return res, nil
+}
+
+// 2024/01/02 15:04:05.123456 from 1.2.3.4:5678 accepted tcp:example.com:443 [vless-ws-443 >> direct] email: alice
+var accessLineRe = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})(?:\.\d+)? from (?:tcp:|udp:)?(\S+) accepted .*email: (\S+)`)
+
+// parseAccessLine returns time, source IP and email of an accepted connection
+func parseAccessLine(line string) (time.Time, string, string, bool) {
+	m := accessLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return time.Time{}, "", "", false
+	}
+	t, err := time.ParseInLocation("2006/01/02 15:04:05", m[1], time.Local)
+	if err != nil {
+		return time.Time{}, "", "", false
+	}
+	ip := m[2]
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return t, ip, m[3], true
+}
+
+// readAccessLog adds the lines written since the last run to st.Seen. A
+// file smaller than the saved offset was rotated and is read from the start.
+func readAccessLog(st *IPLimitState, since time.Time) error {
+	f, err := os.Open(ACCESS_LOG)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < st.Offset {
+		st.Offset = 0
+	}
+	if info.Size()-st.Offset > accessLogMaxRead {
+		st.Offset = info.Size() - accessLogMaxRead
+	}
+	if _, err := f.Seek(st.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			break // baris terakhir belum lengkap, dibaca lagi run berikutnya
+		}
+		if err != nil {
+			return err
+		}
+		st.Offset += int64(len(line))
+		t, ip, email, ok := parseAccessLine(strings.TrimRight(line, "\r\n"))
+		if !ok || t.Before(since) {
+			continue
+		}
+		if st.Seen[email] == nil {
+			st.Seen[email] = make(map[string]time.Time)
+		}
+		key := sharingKey(ip)
+		if t.After(st.Seen[email][key]) {
+			st.Seen[email][key] = t
+		}
+	}
+	return nil
+}
@@ -1,12 +1,11 @@
 package core
 
 import (
-	"bufio"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"time"
@@ -17,6 +16,9 @@ var (
 	DB_INBOUNDS = "/etc/xray/inbounds.db"
 	CONFIG_XRAY = "/usr/local/etc/xray/config.json"
 	CONFIG_BOT  = "/etc/xray/bot.json"
+	DOMAIN_FILE = "/etc/xray/domain"
+	TLS_CERT    = "/etc/xray/xray.crt"
+	TLS_KEY     = "/etc/xray/xray.key"
 )
 
 // Struktur sederhana untuk Inbound di DB
@@ -25,6 +27,7 @@ type InboundDet struct {
 	Protocol  string
 	Transport string
 	Port      int
+	Reality   *InboundReality `json:",omitempty"` // only for vless-reality
 }
 
 type BotConfig struct {
@@ -41,199 +44,133 @@ func SetPaths(clients, inbounds, config string) {
 // --- CLIENT MANAGER ---
 
 func LoadClients() ([]Client, error) {
-	var clients []Client
-	file, err := os.Open(DB_CLIENTS)
-	if os.IsNotExist(err) {
-		return clients, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.Split(line, ";")
-		if len(parts) < 6 {
-			continue
-		}
-		quota, _ := strconv.ParseFloat(parts[1], 64)
-		used, _ := strconv.ParseFloat(parts[2], 64)
-		expTime, err := time.Parse("2006-01-02 15:04:05", parts[3])
-		if err != nil {
-			expTime = time.Now()
-		}
-		client := Client{
-			Username:  parts[0],
-			Quota:     quota,
-			Used:      used,
-			Expiry:    expTime,
-			Protocol:  parts[4],
-			UUID:      parts[5],
-			IsExpired: time.Now().After(expTime),
-		}
-		clients = append(clients, client)
-	}
-	return clients, scanner.Err()
+	return activeStore.LoadClients()
 }
 
-func SaveClient(c Client) error {
-	f, err := os.OpenFile(DB_CLIENTS, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	expStr := c.Expiry.Format("2006-01-02 15:04:05")
-	line := fmt.Sprintf("%s;%.2f;%.0f;%s;%s;%s\n", c.Username, c.Quota, c.Used, expStr, c.Protocol, c.UUID)
-	_, err = f.WriteString(line)
-	return err
-}
-
-func UpdateClient(username string, modifier func(*Client)) error {
-	clients, err := LoadClients()
-	if err != nil {
+// SaveClient validates and stores a new client
+func SaveClient(a Actor, c Client) error {
+	c.Username = strings.TrimSpace(c.Username)
+	c.UUID = NormalizeUUID(c.UUID)
+	if err := ValidateNewClient(c); err != nil {
 		return err
 	}
-	found := false
-	for i := range clients {
-		if clients[i].Username == username {
-			modifier(&clients[i])
-			found = true
-			break
-		}
+	if c.Status == "" {
+		c.SetStatus(StatusActive, "created")
 	}
-	if !found {
-		return fmt.Errorf("user not found")
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
 	}
-	f, err := os.OpenFile(DB_CLIENTS, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
+	if err := activeStore.SaveClient(c); err != nil {
 		return err
 	}
-	defer f.Close()
-	for _, c := range clients {
-		expStr := c.Expiry.Format("2006-01-02 15:04:05")
-		line := fmt.Sprintf("%s;%.2f;%.0f;%s;%s;%s\n", c.Username, c.Quota, c.Used, expStr, c.Protocol, c.UUID)
-		if _, err := f.WriteString(line); err != nil {
-			return err
-		}
-	}
+	Audit(a, "create", c.Username, nil, c)
 	return nil
 }
 
-func DeleteClient(username string) error {
-	clients, err := LoadClients()
-	if err != nil {
-		return err
-	}
-	f, err := os.OpenFile(DB_CLIENTS, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+// UpdateClient applies modifier to one client and audits it as "update"
+func UpdateClient(a Actor, username string, modifier func(*Client)) error {
+	return updateClient(a, "update", username, modifier)
+}
+
+// updateClient records the client before and after modifier under action
+func updateClient(a Actor, action, username string, modifier func(*Client)) error {
+	var before, after Client
+	err := activeStore.UpdateClient(username, func(c *Client) {
+		before = cloneClient(*c)
+		modifier(c)
+		after = cloneClient(*c)
+	})
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	for _, c := range clients {
-		if c.Username == username {
-			continue
-		}
-		expStr := c.Expiry.Format("2006-01-02 15:04:05")
-		line := fmt.Sprintf("%s;%.2f;%.0f;%s;%s;%s\n", c.Username, c.Quota, c.Used, expStr, c.Protocol, c.UUID)
-		if _, err := f.WriteString(line); err != nil {
-			return err
+	Audit(a, action, username, before, after)
+	return nil
+}
+
+func cloneClient(c Client) Client {
+	c.Tags = append([]string(nil), c.Tags...)
+	c.Inbounds = append([]string(nil), c.Inbounds...)
+	if c.Extra != nil {
+		extra := make(map[string]string, len(c.Extra))
+		for k, v := range c.Extra {
+			extra[k] = v
 		}
+		c.Extra = extra
 	}
-	return nil
+	return c
 }
 
 // --- MULTI-INBOUND MANAGER ---
 
 // LoadAllInbounds returns all configured inbounds
 func LoadAllInbounds() ([]InboundDet, error) {
-	var inbounds []InboundDet
-	file, err := os.Open(DB_INBOUNDS)
-	if os.IsNotExist(err) {
-		return inbounds, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.Split(line, ";")
-		// Format: active;protocol-trans;port
-		if len(parts) >= 3 {
-			port, _ := strconv.Atoi(strings.TrimSpace(parts[2]))
-			tagFull := strings.TrimSpace(parts[1])
-			
-			// Split Tag (vless-xtls) -> vless, xtls
-			tagParts := strings.Split(tagFull, "-")
-			proto := ""
-			trans := ""
-			if len(tagParts) == 2 {
-				proto = tagParts[0]
-				trans = tagParts[1]
-			}
-
-			if port > 0 && proto != "" {
-				inbounds = append(inbounds, InboundDet{
-					Tag:       tagFull,
-					Protocol:  proto,
-					Transport: trans,
-					Port:      port,
-				})
-			}
-		}
-	}
-	return inbounds, scanner.Err()
+	return activeStore.LoadAllInbounds()
 }
 
 // AddInbound appends new inbound (supports multiple ports)
-func AddInbound(protocol, transport string, port int) error {
-	// Cek apakah port sudah ada di DB
-	currents, _ := LoadAllInbounds()
-	for _, cur := range currents {
-		if cur.Port == port {
-			return fmt.Errorf("port %d already used by %s", port, cur.Tag)
-		}
-	}
+func AddInbound(a Actor, protocol, transport string, port int) error {
+	if transport == RealityTransport {
+		return fmt.Errorf("use AddRealityInbound for reality")
+	}
+	return addInbound(a, InboundDet{
+		Tag:       fmt.Sprintf("%s-%s", protocol, transport),
+		Protocol:  protocol,
+		Transport: transport,
+		Port:      port,
+	})
+}
 
-	line := fmt.Sprintf("active;%s-%s;%d\n", protocol, transport, port)
-	f, err := os.OpenFile(DB_INBOUNDS, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
+// AddRealityInbound adds a vless-reality inbound, see NewInboundReality
+func AddRealityInbound(a Actor, port int, r InboundReality) error {
+	if err := r.Validate(); err != nil {
 		return err
 	}
-	defer f.Close()
-	
-	if _, err := f.WriteString(line); err != nil {
+	return addInbound(a, InboundDet{
+		Tag:       "vless-" + RealityTransport,
+		Protocol:  "vless",
+		Transport: RealityTransport,
+		Port:      port,
+		Reality:   &r,
+	})
+}
+
+// addInbound stores the inbound (the store rejects a used port inside its
+// lock) and writes the Xray config. When the config cannot be written the
+// inbound is removed again, so inbounds.db never holds an unapplied inbound.
+func addInbound(a Actor, inb InboundDet) error {
+	if err := activeStore.SaveInbound(inb); err != nil {
 		return err
 	}
-	return SyncConfig()
+	target := fmt.Sprintf("%s:%d", inb.Tag, inb.Port)
+	if err := SyncConfig(); err != nil {
+		if rerr := activeStore.DeleteInbound(inb.Port); rerr != nil {
+			Audit(a, "inbound_add", target, nil, inb.forAudit())
+			return fmt.Errorf("inbound %s saved but not applied: %v (removing it failed: %v)", target, err, rerr)
+		}
+		return fmt.Errorf("inbound %s not added: %v", target, err)
+	}
+	Audit(a, "inbound_add", target, nil, inb.forAudit())
+	return nil
 }
 
 // DeleteInbound removes specific port
-func DeleteInbound(targetPort int) error {
-	inbounds, err := LoadAllInbounds()
-	if err != nil {
-		return err
+func DeleteInbound(a Actor, targetPort int) error {
+	inbounds, _ := LoadAllInbounds()
+	var before *InboundDet
+	for i := range inbounds {
+		if inbounds[i].Port == targetPort {
+			logged := inbounds[i].forAudit()
+			before = &logged
+		}
 	}
-	
-	f, err := os.OpenFile(DB_INBOUNDS, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
+	if err := activeStore.DeleteInbound(targetPort); err != nil {
 		return err
 	}
-	defer f.Close()
-
-	for _, inb := range inbounds {
-		if inb.Port == targetPort {
-			continue // Skip deleted
-		}
-		line := fmt.Sprintf("active;%s;%d\n", inb.Tag, inb.Port)
-		if _, err := f.WriteString(line); err != nil {
-			return err
-		}
+	target := strconv.Itoa(targetPort)
+	if before != nil {
+		target = fmt.Sprintf("%s:%d", before.Tag, targetPort)
 	}
+	Audit(a, "inbound_delete", target, before, nil)
 	return nil
 }
 
@@ -247,20 +184,12 @@ func GetActiveInbound() (string, int, error) {
 	return inbounds[0].Tag, inbounds[0].Port, nil
 }
 
-func SyncConfig() error {
-	clients, err := LoadClients()
-	if err != nil {
-		return err
-	}
-
-	inbounds, err := LoadAllInbounds()
-	if err != nil {
-		return err
-	}
-
-	conf := XrayConfig{
+// baseXrayConfig is everything in config.json except the user inbounds:
+// log, stats API, policy, outbounds and routing
+func baseXrayConfig() XrayConfig {
+	return XrayConfig{
 		Log: map[string]string{
-			"access":   "/var/log/xray/access.log",
+			"access":   ACCESS_LOG,
 			"error":    "/var/log/xray/error.log",
 			"loglevel": "warning",
 		},
@@ -307,6 +236,22 @@ func SyncConfig() error {
 			},
 		},
 	}
+}
+
+// generateXrayConfig builds the panel's part of the config: base sections
+// and one inbound per inbounds.db entry with its active clients
+func generateXrayConfig() (XrayConfig, error) {
+	clients, err := LoadClients()
+	if err != nil {
+		return XrayConfig{}, err
+	}
+
+	inbounds, err := LoadAllInbounds()
+	if err != nil {
+		return XrayConfig{}, err
+	}
+
+	conf := baseXrayConfig()
 
 	// LOOP SEMUA INBOUND DARI DB
 	for _, inb := range inbounds {
@@ -317,10 +262,11 @@ func SyncConfig() error {
 		settings := InboundSettings{
 			Clients: []XrayClient{},
 		}
-		
+
 		// Logic Fallback Khusus Port 443
 		// Ini mencegah Xray error jika diakses via browser biasa
-		if inb.Port == 443 {
+		// (REALITY meneruskan sendiri ke dest, tidak perlu fallback)
+		if inb.Port == 443 && trans != RealityTransport {
 			if trans == "xtls" {
 				settings.Fallbacks = []Fallback{{Dest: 80, Xver: 1}}
 			} else {
@@ -338,7 +284,7 @@ func SyncConfig() error {
 				Security: "tls",
 				TLSSettings: &TLSSettings{
 					Certificates: []Certificate{
-						{CertificateFile: "/etc/xray/xray.crt", KeyFile: "/etc/xray/xray.key"},
+						{CertificateFile: TLS_CERT, KeyFile: TLS_KEY},
 					},
 				},
 			},
@@ -362,21 +308,28 @@ func SyncConfig() error {
 				ServiceName: fmt.Sprintf("%s-%s", proto, trans),
 				MultiMode:   true,
 			}
+		} else if trans == RealityTransport {
+			if inb.Reality == nil {
+				return XrayConfig{}, fmt.Errorf("inbound %s:%d has no reality settings", inb.Tag, inb.Port)
+			}
+			userInbound.StreamSettings.Security = "reality"
+			userInbound.StreamSettings.TLSSettings = nil
+			userInbound.StreamSettings.RealitySettings = inb.Reality.xraySettings()
 		}
 
 		// Tambahkan User yang sesuai dengan Protocol Inbound ini
 		for _, c := range clients {
-			if c.Protocol == inb.Tag && !c.IsExpired {
+			if c.AttachedTo(inb.Tag) && c.IsActive() {
 				xc := XrayClient{
 					Email: c.Username,
 					Level: 0,
 				}
 				if proto == "trojan" {
-					xc.Password = c.UUID
+					xc.Password = c.TrojanPassword()
 				} else {
 					xc.ID = c.UUID
 				}
-				if trans == "xtls" && proto == "vless" {
+				if (trans == "xtls" || trans == RealityTransport) && proto == "vless" {
 					xc.Flow = "xtls-rprx-vision"
 				}
 				userInbound.Settings.Clients = append(userInbound.Settings.Clients, xc)
@@ -384,43 +337,67 @@ func SyncConfig() error {
 		}
 		conf.Inbounds = append(conf.Inbounds, userInbound)
 	}
+	return conf, nil
+}
 
-	newConfig, err := json.MarshalIndent(conf, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(CONFIG_XRAY, newConfig, 0644)
+// ClientLink is the share link of one inbound a client is attached to
+type ClientLink struct {
+	Tag string
+	URL string
 }
 
-func GenerateLink(c Client, domain string) string {
-	// Kita cari port dari inbound yang cocok dengan protocol client
+// GenerateLink returns one link per attached inbound
+func GenerateLink(c Client, domain string) []ClientLink {
 	inbounds, _ := LoadAllInbounds()
-	var targetPort int
-	
+	return GenerateLinkFor(c, domain, inbounds)
+}
+
+// GenerateLinkFor is GenerateLink with inbounds the caller already loaded,
+// so a page listing many clients reads the store once
+func GenerateLinkFor(c Client, domain string, inbounds []InboundDet) []ClientLink {
+	var links []ClientLink
+	tags := c.InboundTags()
+	for _, tag := range tags {
+		// Nama di aplikasi client dibedakan per inbound kalau lebih dari satu
+		named := c
+		if len(tags) > 1 {
+			named.Username = c.Username + "-" + tag
+		}
+		if url := generateLink(named, tag, inbounds, domain); url != "" {
+			links = append(links, ClientLink{Tag: tag, URL: url})
+		}
+	}
+	return links
+}
+
+func generateLink(c Client, tag string, inbounds []InboundDet, domain string) string {
+	// Kita cari inbound yang cocok dengan tag
+	var target *InboundDet
+
 	// Default cari port 443 dulu jika ada yang cocok
-	for _, inb := range inbounds {
-		if inb.Tag == c.Protocol && inb.Port == 443 {
-			targetPort = 443
+	for i, inb := range inbounds {
+		if inb.Tag == tag && inb.Port == 443 {
+			target = &inbounds[i]
 			break
 		}
 	}
 	// Jika tidak ada di 443, ambil port pertama yang cocok dengan protocol
-	if targetPort == 0 {
-		for _, inb := range inbounds {
-			if inb.Tag == c.Protocol {
-				targetPort = inb.Port
+	if target == nil {
+		for i, inb := range inbounds {
+			if inb.Tag == tag {
+				target = &inbounds[i]
 				break
 			}
 		}
 	}
-	
+
 	// Fallback jika tidak ditemukan, default ke 443
 	port := "443"
-	if targetPort > 0 {
-		port = strconv.Itoa(targetPort)
+	if target != nil {
+		port = strconv.Itoa(target.Port)
 	}
 
-	parts := strings.Split(c.Protocol, "-")
+	parts := strings.Split(tag, "-")
 	if len(parts) < 2 {
 		return ""
 	}
@@ -440,6 +417,13 @@ func GenerateLink(c Client, domain string) string {
 			service := fmt.Sprintf("%s-%s", proto, trans)
 			return fmt.Sprintf("vless://%s@%s:%s?security=tls&encryption=none&type=grpc&serviceName=%s&mode=multi&sni=%s&alpn=h2#%s",
 				uuid, domain, port, service, domain, c.Username)
+		} else if trans == RealityTransport {
+			if target == nil || target.Reality == nil {
+				return "" // tanpa kunci link tidak bisa dipakai
+			}
+			r := target.Reality
+			return fmt.Sprintf("vless://%s@%s:%s?security=reality&encryption=none&flow=xtls-rprx-vision&type=tcp&sni=%s&fp=%s&pbk=%s&sid=%s#%s",
+				uuid, domain, port, r.ServerNames[0], RealityFingerprint, r.PublicKey, r.ShortIDs[0], c.Username)
 		}
 	} else if proto == "vmess" {
 		vmessConfig := map[string]string{
@@ -456,6 +440,7 @@ func GenerateLink(c Client, domain string) string {
 		b64 := base64.StdEncoding.EncodeToString(jsonBytes)
 		return fmt.Sprintf("vmess://%s", b64)
 	} else if proto == "trojan" {
+		uuid = url.User(c.TrojanPassword()).String()
 		if trans == "ws" {
 			path := fmt.Sprintf("/%s-%s", proto, trans)
 			return fmt.Sprintf("trojan://%s@%s:%s?security=tls&type=ws&path=%s&host=%s&sni=%s&alpn=h2,http/1.1#%s",
@@ -472,48 +457,17 @@ func GenerateLink(c Client, domain string) string {
 	return ""
 }
 
-func RestartXray() error {
-	cmd := exec.Command("systemctl", "restart", "xray")
-	return cmd.Run()
-}
-
-func GetTraffic(email string) (int64, int64, error) {
-	fetch := func(direction string) int64 {
-		name := fmt.Sprintf("user>>>%s>>>traffic>>>%s", email, direction)
-		xrayPath := "/usr/local/bin/xray"
-		if _, err := os.Stat(xrayPath); os.IsNotExist(err) {
-			xrayPath = "/usr/bin/xray"
-		}
-		cmd := exec.Command(xrayPath, "api", "stats", "--server=127.0.0.1:10085", "-name", name, "-reset")
-		out, err := cmd.Output()
-		if err != nil {
-			return 0
-		}
-		var res struct {
-			Stat struct {
-				Value string `json:"value"`
-			} `json:"stat"`
-		}
-		if err := json.Unmarshal(out, &res); err != nil {
-			return 0
-		}
-		val, _ := strconv.ParseInt(res.Stat.Value, 10, 64)
-		return val
-	}
-	up := fetch("uplink")
-	down := fetch("downlink")
-	return up, down, nil
-}
-
-func IsUserOnline(email string) bool {
-	cmdStr := fmt.Sprintf("tail -n 300 /var/log/xray/access.log | grep 'email: %s ' | grep -v 'rejected' | wc -l", email)
-	cmd := exec.Command("bash", "-c", cmdStr)
-	out, err := cmd.Output()
-	if err != nil {
-		return false
-	}
-	count, _ := strconv.Atoi(strings.TrimSpace(string(out)))
-	return count > 0
+// Ekor access log untuk status online, kira-kira 300 baris terakhir
+const onlineMaxRead = 64 << 10
+
+// OnlineUsers returns the emails with an accepted connection near the end of
+// the access log, read once for every client
+func OnlineUsers() map[string]bool {
+	online := make(map[string]bool)
+	scanAccessLog(ACCESS_LOG, onlineMaxRead, func(_ time.Time, _, email string) {
+		online[email] = true
+	})
+	return online
 }
 
 func LoadBotConfig() (BotConfig, error) {
@@ -526,7 +480,7 @@ func LoadBotConfig() (BotConfig, error) {
 	return cfg, err
 }
 
-func SaveBotConfig(token string, adminID int64) error {
+func SaveBotConfig(a Actor, token string, adminID int64) error {
 	cfg := BotConfig{
 		BotToken: token,
 		AdminID:  adminID,
@@ -535,9 +489,18 @@ func SaveBotConfig(token string, adminID int64) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(CONFIG_BOT, data, 0644)
+	if err := WriteFileAtomic(CONFIG_BOT, data, 0600); err != nil {
+		return err
+	}
+	// Token tidak pernah masuk audit log
+	Audit(a, "bot_config", "bot", nil, map[string]interface{}{"admin_id": adminID})
+	return nil
 }
 
-func RemoveBotConfig() error {
-	return os.Remove(CONFIG_BOT)
+func RemoveBotConfig(a Actor) error {
+	if err := os.Remove(CONFIG_BOT); err != nil {
+		return err
+	}
+	Audit(a, "bot_remove", "bot", nil, nil)
+	return nil
 }
@@ -0,0 +1,574 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Config overlay: SyncConfig only owns the inbounds and clients it manages
+// plus the base sections it needs for stats. The rest of config.json
+// belongs to the admin:
+//
+//   - sections SyncConfig does not generate (dns, reverse, ...), inbounds
+//     that are not the panel's, tagged outbounds other than direct/blocked
+//     and routing rules other than the panel's are kept from the current
+//     config.json. What the panel and the overlays wrote on the last sync is
+//     listed in config.json.owned, so deleting an inbound or a fragment
+//     still removes its entries.
+//   - a hand-written inbound with a panel style tag or on the port of a
+//     panel inbound is an error, not silently dropped
+//   - generated sections listed in PanelSettings.UserSections are kept whole
+//   - *.json fragments in CONFIG_OVERLAY_DIR are merged last, in name order.
+//     Objects are merged key by key, inbounds and outbounds replace the entry
+//     with the same tag or are appended, routing rules go right after the
+//     API rule, any other value replaces the generated one.
+var CONFIG_OVERLAY_DIR = "/etc/xray/config.d"
+
+// Bagian config.json yang dibuat ulang oleh SyncConfig
+var managedXraySections = map[string]bool{
+	"log": true, "api": true, "stats": true, "policy": true,
+	"inbounds": true, "outbounds": true, "routing": true,
+}
+
+// ownedConfig is what the last sync wrote for the panel and the overlays
+type ownedConfig struct {
+	Inbounds  []string      `json:"inbounds"`  // panel and overlay inbound tags
+	Outbounds []string      `json:"outbounds"` // overlay outbound tags
+	Rules     []interface{} `json:"rules"`     // overlay routing rules
+}
+
+func ownedConfigPath() string { return CONFIG_XRAY + ".owned" }
+
+// loadOwnedConfig returns nil when no sync recorded it yet
+func loadOwnedConfig() *ownedConfig {
+	data, err := os.ReadFile(ownedConfigPath())
+	if err != nil {
+		return nil
+	}
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil
+	}
+	m, _ := v.(map[string]interface{})
+	owned := &ownedConfig{}
+	for _, t := range jsonList(m, "inbounds") {
+		owned.Inbounds = append(owned.Inbounds, fmt.Sprint(t))
+	}
+	for _, t := range jsonList(m, "outbounds") {
+		owned.Outbounds = append(owned.Outbounds, fmt.Sprint(t))
+	}
+	owned.Rules = jsonList(m, "rules")
+	return owned
+}
+
+func jsonList(m map[string]interface{}, key string) []interface{} {
+	list, _ := m[key].([]interface{})
+	return list
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// UserSectionChoices are the generated sections an admin may take over.
+// Inbounds stay with the panel, custom inbounds go in an overlay.
+var UserSectionChoices = []string{"log", "api", "stats", "policy", "outbounds", "routing"}
+
+// Urutan bagian di config.json, bagian lain menyusul urut nama
+var configSectionOrder = []string{"log", "api", "dns", "stats", "policy", "inbounds", "outbounds", "routing"}
+
+// Tag inbound buatan SyncConfig: protocol-transport-port
+var panelInboundRe = regexp.MustCompile(`^(vless|vmess|trojan)-[a-z0-9]+-\d+$`)
+
+// isPanelInbound reports whether SyncConfig owns an inbound with this tag
+func isPanelInbound(tag string) bool {
+	return tag == "api" || panelInboundRe.MatchString(tag)
+}
+
+// OverlayFile is one fragment of CONFIG_OVERLAY_DIR
+type OverlayFile struct {
+	Name     string
+	Sections []string // top level keys
+	data     map[string]interface{}
+}
+
+// LoadOverlays reads every *.json fragment in name order; a missing
+// directory means no overlay
+func LoadOverlays() ([]OverlayFile, error) {
+	paths, err := filepath.Glob(filepath.Join(CONFIG_OVERLAY_DIR, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var files []OverlayFile
+	for _, path := range paths {
+		name := filepath.Base(path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("overlay %s: %v", name, err)
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("overlay %s: not a JSON object", name)
+		}
+		f := OverlayFile{Name: name, data: m}
+		for k := range m {
+			f.Sections = append(f.Sections, k)
+		}
+		sort.Strings(f.Sections)
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// PreviewConfig returns the config.json SyncConfig would write
+func PreviewConfig() ([]byte, error) {
+	data, _, err := previewConfig()
+	return data, err
+}
+
+func previewConfig() ([]byte, ownedConfig, error) {
+	conf, err := generateXrayConfig()
+	if err != nil {
+		return nil, ownedConfig{}, err
+	}
+	settings, err := LoadPanelSettings()
+	if err != nil {
+		return nil, ownedConfig{}, err
+	}
+	return mergeXrayConfig(conf, settings.UserSections)
+}
+
+// mergeXrayConfig puts the generated config together with the admin's parts
+// and returns what the panel and the overlays own in it
+func mergeXrayConfig(conf XrayConfig, userSections []string) ([]byte, ownedConfig, error) {
+	var owned ownedConfig
+	v, err := toJSONValue(conf)
+	if err != nil {
+		return nil, owned, err
+	}
+	out := v.(map[string]interface{})
+	for _, inb := range jsonList(out, "inbounds") {
+		owned.Inbounds = append(owned.Inbounds, jsonField(inb, "tag"))
+	}
+
+	current, err := readCurrentConfig()
+	if err != nil {
+		return nil, owned, err
+	}
+	if err := keepCurrentConfig(out, current, userSections, loadOwnedConfig()); err != nil {
+		return nil, owned, err
+	}
+
+	overlays, err := LoadOverlays()
+	if err != nil {
+		return nil, owned, err
+	}
+	for _, f := range overlays {
+		if err := applyOverlay(out, f.data); err != nil {
+			return nil, owned, fmt.Errorf("overlay %s: %v", f.Name, err)
+		}
+		for _, inb := range jsonList(f.data, "inbounds") {
+			owned.Inbounds = append(owned.Inbounds, jsonField(inb, "tag"))
+		}
+		for _, ob := range jsonList(f.data, "outbounds") {
+			if tag := jsonField(ob, "tag"); tag != "" {
+				owned.Outbounds = append(owned.Outbounds, tag)
+			}
+		}
+		if routing, ok := f.data["routing"].(map[string]interface{}); ok {
+			owned.Rules = append(owned.Rules, jsonList(routing, "rules")...)
+		}
+	}
+	data, err := encodeXrayConfig(out)
+	return data, owned, err
+}
+
+// readCurrentConfig returns the live config.json, nil if there is none
+func readCurrentConfig() (map[string]interface{}, error) {
+	return readConfigFile(CONFIG_XRAY)
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || (err == nil && len(bytes.TrimSpace(data)) == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Config rusak tidak ditimpa diam-diam, bagian milik admin bisa hilang
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v (fix or remove it)", path, err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: not a JSON object (fix or remove it)", path)
+	}
+	return m, nil
+}
+
+// keepCurrentConfig copies the admin's sections, inbounds, outbounds and
+// routing rules of the current config.json into out. owned is what the last
+// sync wrote for the panel and the overlays (nil = unknown): those entries
+// are not the admin's and are left to the generator and the overlays.
+func keepCurrentConfig(out, current map[string]interface{}, userSections []string, owned *ownedConfig) error {
+	for key, v := range current {
+		if !managedXraySections[key] {
+			out[key] = v
+		}
+	}
+	userOwned := make(map[string]bool)
+	for _, key := range userSections {
+		if v, ok := current[key]; ok {
+			out[key] = v
+			userOwned[key] = true
+		}
+	}
+	if owned == nil {
+		owned = &ownedConfig{}
+	}
+
+	ours := jsonList(out, "inbounds")
+	generated := make(map[string]bool)
+	for _, inb := range ours {
+		generated[jsonField(inb, "tag")] = true
+	}
+	ports := panelPorts(ours)
+	var errs []string
+	for _, inb := range jsonList(current, "inbounds") {
+		tag := jsonField(inb, "tag")
+		if generated[tag] || containsString(owned.Inbounds, tag) {
+			continue // ditulis panel / overlay, mungkin sudah dihapus
+		}
+		if isPanelInbound(tag) {
+			// Tanpa config.json.owned (sync pertama) dianggap inbound panel lama
+			if len(owned.Inbounds) > 0 {
+				errs = append(errs, fmt.Sprintf("inbound %q uses a panel tag, rename it", tag))
+			}
+			continue
+		}
+		if owner, ok := ports[jsonField(inb, "port")]; ok {
+			errs = append(errs, fmt.Sprintf("inbound %q uses the port of %s, move one of them", tag, owner))
+			continue
+		}
+		ours = append(ours, inb)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %s", CONFIG_XRAY, strings.Join(errs, "; "))
+	}
+	out["inbounds"] = ours
+
+	if !userOwned["outbounds"] {
+		obs := jsonList(out, "outbounds")
+		tags := make(map[string]bool)
+		for _, ob := range obs {
+			tags[jsonField(ob, "tag")] = true
+		}
+		for _, ob := range jsonList(current, "outbounds") {
+			// Outbound tanpa tag tidak bisa dirujuk, sisa config bawaan
+			tag := jsonField(ob, "tag")
+			if tag != "" && !tags[tag] && !containsString(owned.Outbounds, tag) {
+				obs = append(obs, ob)
+				tags[tag] = true
+			}
+		}
+		out["outbounds"] = obs
+	}
+
+	if !userOwned["routing"] {
+		routing, _ := out["routing"].(map[string]interface{})
+		cur, _ := current["routing"].(map[string]interface{})
+		var kept []interface{}
+		for _, r := range jsonList(cur, "rules") {
+			if !containsJSON(jsonList(routing, "rules"), r) && !containsJSON(owned.Rules, r) {
+				kept = append(kept, r)
+			}
+		}
+		if routing != nil && len(kept) > 0 {
+			if err := insertRules(routing, kept); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyOverlay merges one fragment into out
+func applyOverlay(out, frag map[string]interface{}) error {
+	for _, key := range sortedMapKeys(frag) {
+		v := frag[key]
+		switch key {
+		case "inbounds":
+			list, ok := v.([]interface{})
+			if !ok {
+				return fmt.Errorf("inbounds must be a list")
+			}
+			ours, _ := out["inbounds"].([]interface{})
+			ports := panelPorts(ours)
+			for _, inb := range list {
+				tag := jsonField(inb, "tag")
+				if isPanelInbound(tag) {
+					return fmt.Errorf("inbound %q is managed by the panel, use another tag", tag)
+				}
+				if owner, ok := ports[jsonField(inb, "port")]; ok {
+					return fmt.Errorf("inbound %q: port is used by %s", tag, owner)
+				}
+				ours = mergeByTag(ours, inb)
+			}
+			out["inbounds"] = ours
+		case "outbounds":
+			list, ok := v.([]interface{})
+			if !ok {
+				return fmt.Errorf("outbounds must be a list")
+			}
+			ours, _ := out["outbounds"].([]interface{})
+			for _, ob := range list {
+				ours = mergeByTag(ours, ob)
+			}
+			out["outbounds"] = ours
+		case "routing":
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("routing must be an object")
+			}
+			routing, _ := out["routing"].(map[string]interface{})
+			if routing == nil {
+				routing = make(map[string]interface{})
+				out["routing"] = routing
+			}
+			for k, rv := range m {
+				if k != "rules" {
+					routing[k] = mergeJSONValue(routing[k], rv)
+					continue
+				}
+				rules, ok := rv.([]interface{})
+				if !ok {
+					return fmt.Errorf("routing.rules must be a list")
+				}
+				if err := insertRules(routing, rules); err != nil {
+					return err
+				}
+			}
+		default:
+			out[key] = mergeJSONValue(out[key], v)
+		}
+	}
+	return nil
+}
+
+// insertRules puts rules after the API rule and before the panel's own
+// rules. Rules already present are skipped, so a routing section kept from
+// config.json does not grow on every sync.
+func insertRules(routing map[string]interface{}, rules []interface{}) error {
+	v, err := toJSONValue(baseXrayConfig().Routing.Rules)
+	if err != nil {
+		return err
+	}
+	var panelRules []interface{}
+	for _, r := range v.([]interface{}) {
+		if jsonField(r, "outboundTag") != "api" {
+			panelRules = append(panelRules, r)
+		}
+	}
+	cur, _ := routing["rules"].([]interface{})
+	pos := len(cur)
+	for i, r := range cur {
+		if containsJSON(panelRules, r) {
+			pos = i
+			break
+		}
+	}
+	var add []interface{}
+	for _, r := range rules {
+		if !containsJSON(cur, r) && !containsJSON(add, r) {
+			add = append(add, r)
+		}
+	}
+	merged := append(append(append([]interface{}{}, cur[:pos]...), add...), cur[pos:]...)
+	routing["rules"] = merged
+	return nil
+}
+
+// mergeJSONValue merges objects key by key; anything else is replaced
+func mergeJSONValue(dst, src interface{}) interface{} {
+	d, ok1 := dst.(map[string]interface{})
+	s, ok2 := src.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return src
+	}
+	for k, v := range s {
+		d[k] = mergeJSONValue(d[k], v)
+	}
+	return d
+}
+
+// mergeByTag replaces the entry with the same tag or appends item
+func mergeByTag(list []interface{}, item interface{}) []interface{} {
+	if tag := jsonField(item, "tag"); tag != "" {
+		for i, cur := range list {
+			if jsonField(cur, "tag") == tag {
+				list[i] = item
+				return list
+			}
+		}
+	}
+	return append(list, item)
+}
+
+// panelPorts maps the port of every panel inbound to its tag
+func panelPorts(inbounds []interface{}) map[string]string {
+	ports := make(map[string]string)
+	for _, inb := range inbounds {
+		if tag := jsonField(inb, "tag"); isPanelInbound(tag) {
+			ports[jsonField(inb, "port")] = tag
+		}
+	}
+	return ports
+}
+
+// jsonField returns a string or number field of a JSON object as text
+func jsonField(v interface{}, key string) string {
+	m, ok := v.(map[string]interface{})
+	if !ok || m[key] == nil {
+		return ""
+	}
+	return fmt.Sprint(m[key])
+}
+
+func containsJSON(list []interface{}, v interface{}) bool {
+	for _, x := range list {
+		if reflect.DeepEqual(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeJSON keeps numbers as json.Number so large values and the
+// formatting of the admin's sections survive a round trip
+func decodeJSON(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+func toJSONValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(data)
+}
+
+// encodeXrayConfig writes the sections in configSectionOrder, then the rest
+func encodeXrayConfig(conf map[string]interface{}) ([]byte, error) {
+	keys := make([]string, 0, len(conf))
+	seen := make(map[string]bool)
+	for _, k := range configSectionOrder {
+		if _, ok := conf[k]; ok {
+			keys = append(keys, k)
+			seen[k] = true
+		}
+	}
+	for _, k := range sortedMapKeys(conf) {
+		if !seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, k := range keys {
+		name, _ := json.Marshal(k)
+		value, err := json.MarshalIndent(conf[k], "  ", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", k, err)
+		}
+		fmt.Fprintf(&buf, "  %s: %s", name, value)
+		if i < len(keys)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+func sortedMapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CheckUserSections validates the sections an admin takes over
+func CheckUserSections(sections []string) error {
+	for _, s := range sections {
+		ok := false
+		for _, c := range UserSectionChoices {
+			ok = ok || s == c
+		}
+		if !ok {
+			return fmt.Errorf("section %q cannot be user-owned (choose from %s)", s, strings.Join(UserSectionChoices, ", "))
+		}
+	}
+	return nil
+}
+
+// SaveOverlay writes frag as a new fragment named base.json, or base-2.json,
+// base-3.json ... when the name is taken by a different file
+func SaveOverlay(a Actor, base string, frag map[string]interface{}) (string, error) {
+	data, err := json.MarshalIndent(frag, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(CONFIG_OVERLAY_DIR, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(CONFIG_OVERLAY_DIR, base+".json")
+	for i := 2; ; i++ {
+		old, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if bytes.Equal(old, data) {
+			return path, nil // isi sama, tidak perlu file baru
+		}
+		path = filepath.Join(CONFIG_OVERLAY_DIR, fmt.Sprintf("%s-%d.json", base, i))
+	}
+	if err := WriteFileAtomic(path, data, 0644); err != nil {
+		return "", err
+	}
+	Audit(a, "overlay_add", filepath.Base(path), nil, frag)
+	return path, nil
+}
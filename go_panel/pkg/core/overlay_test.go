@@ -0,0 +1,173 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupOverlayPaths(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	oldConfig, oldOverlay := CONFIG_XRAY, CONFIG_OVERLAY_DIR
+	t.Cleanup(func() { CONFIG_XRAY, CONFIG_OVERLAY_DIR = oldConfig, oldOverlay })
+	CONFIG_XRAY = filepath.Join(dir, "config.json")
+	CONFIG_OVERLAY_DIR = filepath.Join(dir, "config.d")
+	if err := os.MkdirAll(CONFIG_OVERLAY_DIR, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// panelConfig is the generated config with one panel inbound on 443
+func panelConfig() XrayConfig {
+	conf := baseXrayConfig()
+	conf.Inbounds = append(conf.Inbounds, Inbound{
+		Tag: "vless-ws-443", Port: 443, Protocol: "vless",
+		StreamSettings: StreamSettings{Network: "ws", Security: "tls"},
+	})
+	return conf
+}
+
+func writeJSONFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// syncOverlay merges like SyncConfig and writes config.json and
+// config.json.owned, without testing the config with xray
+func syncOverlay(t *testing.T) map[string]interface{} {
+	t.Helper()
+	data, owned, err := mergeXrayConfig(panelConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedData, _ := json.Marshal(owned)
+	writeJSONFile(t, CONFIG_XRAY, string(data))
+	writeJSONFile(t, ownedConfigPath(), string(ownedData))
+	v, err := decodeJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.(map[string]interface{})
+}
+
+func tagsOf(list []interface{}) []string {
+	var tags []string
+	for _, v := range list {
+		tags = append(tags, jsonField(v, "tag"))
+	}
+	return tags
+}
+
+// ruleOutbounds lists the outboundTag of every routing rule in order
+func ruleOutbounds(conf map[string]interface{}) []string {
+	routing, _ := conf["routing"].(map[string]interface{})
+	var out []string
+	for _, r := range jsonList(routing, "rules") {
+		out = append(out, jsonField(r, "outboundTag"))
+	}
+	return out
+}
+
+func TestMergeKeepsCustomOutboundAndRule(t *testing.T) {
+	setupOverlayPaths(t)
+	syncOverlay(t)
+
+	// Admin menambah outbound, rule dan section dns langsung di config.json
+	current, _ := readCurrentConfig()
+	current["outbounds"] = append(jsonList(current, "outbounds"),
+		map[string]interface{}{"protocol": "socks", "tag": "warp"})
+	routing := current["routing"].(map[string]interface{})
+	routing["rules"] = append(jsonList(routing, "rules"),
+		map[string]interface{}{"type": "field", "domain": []interface{}{"geosite:netflix"}, "outboundTag": "warp"})
+	current["dns"] = map[string]interface{}{"servers": []interface{}{"1.1.1.1"}}
+	data, _ := encodeXrayConfig(current)
+	writeJSONFile(t, CONFIG_XRAY, string(data))
+
+	for i := 0; i < 2; i++ { // sync kedua tidak boleh menggandakan apa pun
+		conf := syncOverlay(t)
+		if got := strings.Join(tagsOf(jsonList(conf, "outbounds")), ","); got != "direct,blocked,warp" {
+			t.Errorf("sync %d: outbounds = %s", i+1, got)
+		}
+		if got := strings.Join(ruleOutbounds(conf), ","); got != "api,warp,blocked" {
+			t.Errorf("sync %d: rules = %s, want the custom rule after the API rule", i+1, got)
+		}
+		if _, ok := conf["dns"]; !ok {
+			t.Errorf("sync %d: dns section dropped", i+1)
+		}
+	}
+}
+
+func TestMergeDeletedFragmentIsRemoved(t *testing.T) {
+	setupOverlayPaths(t)
+	frag := filepath.Join(CONFIG_OVERLAY_DIR, "10-proxy.json")
+	writeJSONFile(t, frag, `{
+		"inbounds": [{"tag": "socks-in", "port": 1080, "protocol": "socks"}],
+		"outbounds": [{"tag": "proxy", "protocol": "vmess"}],
+		"routing": {"rules": [{"type": "field", "inboundTag": ["socks-in"], "outboundTag": "proxy"}]}
+	}`)
+
+	conf := syncOverlay(t)
+	if got := strings.Join(tagsOf(jsonList(conf, "inbounds")), ","); got != "api,vless-ws-443,socks-in" {
+		t.Errorf("inbounds = %s", got)
+	}
+	if got := strings.Join(ruleOutbounds(conf), ","); got != "api,proxy,blocked" {
+		t.Errorf("rules = %s", got)
+	}
+
+	// Fragment dihapus: isinya tidak boleh dianggap milik admin
+	if err := os.Remove(frag); err != nil {
+		t.Fatal(err)
+	}
+	conf = syncOverlay(t)
+	if got := strings.Join(tagsOf(jsonList(conf, "inbounds")), ","); got != "api,vless-ws-443" {
+		t.Errorf("inbounds after delete = %s", got)
+	}
+	if got := strings.Join(tagsOf(jsonList(conf, "outbounds")), ","); got != "direct,blocked" {
+		t.Errorf("outbounds after delete = %s", got)
+	}
+	if got := strings.Join(ruleOutbounds(conf), ","); got != "api,blocked" {
+		t.Errorf("rules after delete = %s", got)
+	}
+}
+
+func TestMergeRejectsClashingInbounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string // inbound added by hand to config.json
+		frag    string // inbound in an overlay fragment
+		want    string
+	}{
+		{name: "panel tag in config.json", current: `{"tag": "trojan-ws-8443", "port": 8443, "protocol": "trojan"}`, want: "uses a panel tag"},
+		{name: "panel port in config.json", current: `{"tag": "my-in", "port": 443, "protocol": "socks"}`, want: "uses the port of vless-ws-443"},
+		{name: "panel tag in overlay", frag: `{"tag": "vmess-grpc-2083", "port": 2083, "protocol": "vmess"}`, want: "managed by the panel"},
+		{name: "panel port in overlay", frag: `{"tag": "my-in", "port": 443, "protocol": "socks"}`, want: "port is used by vless-ws-443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupOverlayPaths(t)
+			syncOverlay(t)
+			if tt.current != "" {
+				current, _ := readCurrentConfig()
+				var inb interface{}
+				if err := json.Unmarshal([]byte(tt.current), &inb); err != nil {
+					t.Fatal(err)
+				}
+				current["inbounds"] = append(jsonList(current, "inbounds"), inb)
+				data, _ := encodeXrayConfig(current)
+				writeJSONFile(t, CONFIG_XRAY, string(data))
+			}
+			if tt.frag != "" {
+				writeJSONFile(t, filepath.Join(CONFIG_OVERLAY_DIR, "20-in.json"), `{"inbounds": [`+tt.frag+`]}`)
+			}
+			_, _, err := mergeXrayConfig(panelConfig(), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var CONFIG_PLANS = "/etc/xray/plans.json"
+
+// Plan is a named package clients are created, renewed or upgraded from
+type Plan struct {
+	Name        string   `json:"name"`
+	Quota       float64  `json:"quota"` // GB, 0 = unlimited
+	Days        int      `json:"days"`
+	Inbounds    []string `json:"inbounds,omitempty"` // empty = every inbound
+	DeviceLimit int      `json:"device_limit"`       // 0 = unlimited
+	ResetPeriod string   `json:"reset_period,omitempty"`
+	Price       float64  `json:"price"`
+}
+
+// Summary is the one-line description used by the CLI and bot
+func (p Plan) Summary() string {
+	quota := "unlimited"
+	if p.Quota > 0 {
+		quota = fmt.Sprintf("%.0f GB", p.Quota)
+	}
+	s := fmt.Sprintf("%s: %s / %d days", p.Name, quota, p.Days)
+	if p.DeviceLimit > 0 {
+		s += fmt.Sprintf(" / %d devices", p.DeviceLimit)
+	}
+	if p.ResetPeriod != ResetNone {
+		s += " / resets " + p.ResetDescription()
+	}
+	if p.Price > 0 {
+		s += fmt.Sprintf(" / %.2f", p.Price)
+	}
+	return s
+}
+
+// ResetDescription describes the quota reset period of the plan
+func (p Plan) ResetDescription() string {
+	return DescribeResetPeriod(p.ResetPeriod)
+}
+
+// LoadPlans returns every plan sorted by name; a missing file means no plans
+func LoadPlans() ([]Plan, error) {
+	data, err := os.ReadFile(CONFIG_PLANS)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var plans []Plan
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, fmt.Errorf("%s: %v", CONFIG_PLANS, err)
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Name < plans[j].Name })
+	return plans, nil
+}
+
+// FindPlan looks up a plan by name
+func FindPlan(name string) (Plan, error) {
+	plans, err := LoadPlans()
+	if err != nil {
+		return Plan{}, err
+	}
+	for _, p := range plans {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Plan{}, fmt.Errorf("plan %s not found", name)
+}
+
+// SavePlan adds a plan or replaces the one with the same name
+func SavePlan(a Actor, p Plan) error {
+	p.Name = strings.TrimSpace(p.Name)
+	// Nama dipakai di callback data bot (maks 64 byte) dan URL
+	if p.Name == "" || len(p.Name) > 32 || strings.ContainsAny(p.Name, "/;\n") {
+		return fmt.Errorf("plan name must be 1-32 characters without '/' or ';'")
+	}
+	if p.Days <= 0 {
+		return fmt.Errorf("days must be positive")
+	}
+	if p.Quota < 0 || p.DeviceLimit < 0 || p.Price < 0 {
+		return fmt.Errorf("quota, device limit and price cannot be negative")
+	}
+	period, err := ParseResetPeriod(p.ResetPeriod)
+	if err != nil {
+		return err
+	}
+	p.ResetPeriod = period
+	if len(p.Inbounds) > 0 {
+		if err := CheckInboundTags(p.Inbounds); err != nil {
+			return err
+		}
+	}
+	var before *Plan
+	err = mutatePlans(func(plans []Plan) ([]Plan, error) {
+		for i := range plans {
+			if plans[i].Name == p.Name {
+				old := plans[i]
+				before = &old
+				plans[i] = p
+				return plans, nil
+			}
+		}
+		return append(plans, p), nil
+	})
+	if err != nil {
+		return err
+	}
+	Audit(a, "plan_save", p.Name, before, p)
+	return nil
+}
+
+// DeletePlan removes a plan; clients on it keep their current limits
+func DeletePlan(a Actor, name string) error {
+	var before Plan
+	err := mutatePlans(func(plans []Plan) ([]Plan, error) {
+		for i := range plans {
+			if plans[i].Name == name {
+				before = plans[i]
+				return append(plans[:i], plans[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("plan %s not found", name)
+	})
+	if err != nil {
+		return err
+	}
+	Audit(a, "plan_delete", name, before, nil)
+	return nil
+}
+
+func mutatePlans(fn func([]Plan) ([]Plan, error)) error {
+	return withFileLock(CONFIG_PLANS, func() error {
+		plans, err := LoadPlans()
+		if err != nil {
+			return err
+		}
+		if plans, err = fn(plans); err != nil {
+			return err
+		}
+		if plans == nil {
+			plans = []Plan{}
+		}
+		data, err := json.MarshalIndent(plans, "", "  ")
+		if err != nil {
+			return err
+		}
+		return WriteFileAtomic(CONFIG_PLANS, data, 0644)
+	})
+}
+
+// planInbounds resolves the plan's inbounds; empty means all current inbounds
+func planInbounds(p Plan) ([]string, error) {
+	if len(p.Inbounds) > 0 {
+		return p.Inbounds, CheckInboundTags(p.Inbounds)
+	}
+	tags, err := InboundTagList()
+	if err == nil && len(tags) == 0 {
+		err = fmt.Errorf("no inbounds")
+	}
+	return tags, err
+}
+
+// applyPlan copies the plan limits onto the client (expiry is left to the caller)
+func (c *Client) applyPlan(p Plan, tags []string) {
+	c.Plan = p.Name
+	c.Quota = p.Quota
+	c.DeviceLimit = p.DeviceLimit
+	c.SetResetPeriod(p.ResetPeriod)
+	c.SetInbounds(tags)
+}
+
+// NewClientFromPlan builds a new client with the plan's quota, duration,
+// inbounds and device limit. The caller still has to SaveClient it.
+func NewClientFromPlan(username, uuid, planName string) (Client, error) {
+	p, err := FindPlan(planName)
+	if err != nil {
+		return Client{}, err
+	}
+	tags, err := planInbounds(p)
+	if err != nil {
+		return Client{}, err
+	}
+	if uuid == "" {
+		uuid = GenerateUUID()
+	}
+	c := Client{
+		Username: username,
+		UUID:     uuid,
+		Expiry:   time.Now().Add(time.Duration(p.Days) * 24 * time.Hour),
+	}
+	c.applyPlan(p, tags)
+	return c, nil
+}
+
+// RenewFromPlan starts a new period of the plan: expiry is extended by the
+// plan's days, usage is reset and the plan limits are applied again.
+func RenewFromPlan(a Actor, username, planName string) error {
+	p, err := FindPlan(planName)
+	if err != nil {
+		return err
+	}
+	tags, err := planInbounds(p)
+	if err != nil {
+		return err
+	}
+	return updateClient(a, "renew_plan", username, func(c *Client) {
+		c.applyPlan(p, tags)
+		base := c.Expiry
+		if base.Before(time.Now()) {
+			base = time.Now()
+		}
+		c.Expiry = base.Add(time.Duration(p.Days) * 24 * time.Hour)
+		c.IsExpired = false
+		c.Used = 0
+		c.LiftLimitStatus()
+	})
+}
+
+// UpgradeToPlan moves the client to another plan for the rest of the
+// current period: limits change, expiry and usage stay.
+func UpgradeToPlan(a Actor, username, planName string) error {
+	p, err := FindPlan(planName)
+	if err != nil {
+		return err
+	}
+	tags, err := planInbounds(p)
+	if err != nil {
+		return err
+	}
+	return updateClient(a, "upgrade_plan", username, func(c *Client) {
+		c.applyPlan(p, tags)
+		c.LiftLimitStatus()
+	})
+}
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// VLESS REALITY: the inbound borrows the TLS handshake of Dest, clients
+// authenticate with the x25519 public key and one of the short IDs.
+// Stored in inbounds.db as extra fields of the vless-reality line:
+//
+//	active;vless-reality;443;dest=www.microsoft.com:443;sni=www.microsoft.com;pk=...;pbk=...;sid=6ba85179e30d4fc2
+//
+// An empty short ID ("sid=" or "sid=,6ba8...") lets clients connect without one.
+const (
+	RealityTransport   = "reality"
+	RealityFingerprint = "chrome" // uTLS fingerprint in share links
+	RealityDefaultDest = "www.microsoft.com:443"
+)
+
+// InboundReality is the REALITY part of an inbound
+type InboundReality struct {
+	Dest        string   `json:"dest"` // host:port of the site the handshake is forwarded to
+	ServerNames []string `json:"server_names"`
+	PrivateKey  string   `json:"private_key"` // x25519, base64url without padding like `xray x25519`
+	PublicKey   string   `json:"public_key"`
+	ShortIDs    []string `json:"short_ids"`
+}
+
+// NewRealityKeys generates an x25519 key pair in the encoding Xray uses
+func NewRealityKeys() (private, public string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(key.Bytes()), enc.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// RealityPublicKey derives the public key clients need from a private key
+func RealityPublicKey(private string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(private, "="))
+	if err != nil || len(raw) != 32 {
+		return "", fmt.Errorf("private key must be 32 bytes of base64url")
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// NewShortID returns a random 8 byte short ID in hex
+func NewShortID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewInboundReality fills in keys and a short ID for dest; serverNames
+// default to the host of dest
+func NewInboundReality(dest string, serverNames, shortIDs []string) (InboundReality, error) {
+	r := InboundReality{Dest: strings.TrimSpace(dest), ServerNames: serverNames, ShortIDs: shortIDs}
+	if r.Dest == "" {
+		r.Dest = RealityDefaultDest
+	}
+	if len(r.ServerNames) == 0 {
+		host, _, _ := net.SplitHostPort(r.Dest)
+		r.ServerNames = []string{host}
+	}
+	if len(r.ShortIDs) == 0 {
+		r.ShortIDs = []string{NewShortID()}
+	}
+	var err error
+	if r.PrivateKey, r.PublicKey, err = NewRealityKeys(); err != nil {
+		return r, err
+	}
+	return r, r.Validate()
+}
+
+// Validate checks every field; a missing public key is derived
+func (r *InboundReality) Validate() error {
+	host, port, err := net.SplitHostPort(r.Dest)
+	if n, perr := strconv.Atoi(port); err != nil || host == "" || perr != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("reality dest %q must be host:port", r.Dest)
+	}
+	if len(r.ServerNames) == 0 {
+		return fmt.Errorf("reality needs at least one server name")
+	}
+	for _, sn := range r.ServerNames {
+		if sn == "" || strings.ContainsAny(sn, ",;= /") {
+			return fmt.Errorf("invalid reality server name %q", sn)
+		}
+	}
+	pub, err := RealityPublicKey(r.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("reality: %v", err)
+	}
+	if r.PublicKey == "" {
+		r.PublicKey = pub
+	} else if r.PublicKey != pub {
+		return fmt.Errorf("reality public key does not match the private key")
+	}
+	if len(r.ShortIDs) == 0 {
+		return fmt.Errorf("reality needs at least one short ID")
+	}
+	// Short ID kosong sah di Xray: client tanpa sid
+	for _, sid := range r.ShortIDs {
+		if _, err := hex.DecodeString(sid); err != nil || len(sid) > 16 {
+			return fmt.Errorf("invalid reality short ID %q (hex, even length, max 16 characters, may be empty)", sid)
+		}
+	}
+	return nil
+}
+
+// forAudit drops the private key before an inbound goes to the audit log
+func (inb InboundDet) forAudit() InboundDet {
+	if inb.Reality != nil {
+		r := *inb.Reality
+		r.PrivateKey = ""
+		inb.Reality = &r
+	}
+	return inb
+}
+
+// ParseRealityList splits a comma or space separated list typed by a user
+func ParseRealityList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// encodeFields renders the inbounds.db fields after the port
+func (r InboundReality) encodeFields() string {
+	return strings.Join([]string{
+		"dest=" + url.PathEscape(r.Dest),
+		"sni=" + url.PathEscape(strings.Join(r.ServerNames, ",")),
+		"pk=" + r.PrivateKey,
+		"pbk=" + r.PublicKey,
+		"sid=" + strings.Join(r.ShortIDs, ","),
+	}, ";")
+}
+
+// parseRealityFields reads the key=value fields of an inbounds.db line
+func parseRealityFields(fields []string) (*InboundReality, error) {
+	r := &InboundReality{}
+	for _, f := range fields {
+		key, val, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if !ok {
+			return nil, fmt.Errorf("bad field %q (want key=value)", f)
+		}
+		v, err := url.PathUnescape(val)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", key, err)
+		}
+		switch key {
+		case "dest":
+			r.Dest = v
+		case "sni":
+			r.ServerNames = ParseRealityList(v)
+		case "pk":
+			r.PrivateKey = v
+		case "pbk":
+			r.PublicKey = v
+		case "sid":
+			r.ShortIDs = strings.Split(v, ",") // "" tetap satu short ID kosong
+		default:
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// xraySettings renders the streamSettings.realitySettings of the inbound
+func (r InboundReality) xraySettings() *RealitySettings {
+	return &RealitySettings{
+		Dest:        r.Dest,
+		ServerNames: r.ServerNames,
+		PrivateKey:  r.PrivateKey,
+		ShortIds:    r.ShortIDs,
+	}
+}
+
+// realityFromXray reads the realitySettings of an imported inbound; the
+// public key is derived from the private key
+func realityFromXray(rs *RealitySettings) (*InboundReality, error) {
+	if rs == nil {
+		return nil, fmt.Errorf("realitySettings missing")
+	}
+	r := &InboundReality{
+		Dest:        rs.Dest,
+		ServerNames: rs.ServerNames,
+		PrivateKey:  rs.PrivateKey,
+		ShortIDs:    rs.ShortIds,
+	}
+	if r.Dest == "" {
+		r.Dest = rs.Target // nama baru di Xray 24.x
+	}
+	if _, err := strconv.Atoi(r.Dest); err == nil {
+		r.Dest = "127.0.0.1:" + r.Dest // dest berupa port saja = server lokal
+	} else if !strings.Contains(r.Dest, ":") {
+		r.Dest = net.JoinHostPort(r.Dest, "443")
+	}
+	// Short ID kosong dipertahankan, client yang tidak memakai sid tetap jalan
+	if len(r.ShortIDs) == 0 {
+		r.ShortIDs = []string{NewShortID()}
+	}
+	return r, r.Validate()
+}
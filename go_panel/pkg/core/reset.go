@@ -0,0 +1,170 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Quota reset periods. A day of the month is stored as "day:N" (1-31,
+// clamped to the last day in shorter months); "" never resets.
+const (
+	ResetNone    = ""
+	ResetDaily   = "daily"
+	ResetWeekly  = "weekly"  // Monday 00:00
+	ResetMonthly = "monthly" // every month on the day the client was created
+)
+
+const resetDayPrefix = "day:"
+
+// ParseResetPeriod normalizes a user supplied reset period
+func ParseResetPeriod(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "", "none", "never":
+		return ResetNone, nil
+	case ResetDaily, ResetWeekly, ResetMonthly:
+		return s, nil
+	}
+	if v, ok := strings.CutPrefix(s, resetDayPrefix); ok {
+		if day, err := strconv.Atoi(v); err == nil && day >= 1 && day <= 31 {
+			return resetDayPrefix + strconv.Itoa(day), nil
+		}
+	}
+	return "", fmt.Errorf("unknown reset period %q (none, daily, weekly, monthly or day:1-31)", s)
+}
+
+// ResetDayPeriod is the period that resets on the given day of every month
+func ResetDayPeriod(day int) string {
+	return resetDayPrefix + strconv.Itoa(day)
+}
+
+// DescribeResetPeriod is the human readable form used by the UIs
+func DescribeResetPeriod(period string) string {
+	switch period {
+	case ResetNone:
+		return "never"
+	case ResetDaily, ResetWeekly, ResetMonthly:
+		return period
+	}
+	if day := resetDay(period); day > 0 {
+		return fmt.Sprintf("monthly on day %d", day)
+	}
+	return period
+}
+
+func resetDay(period string) int {
+	v, ok := strings.CutPrefix(period, resetDayPrefix)
+	if !ok {
+		return 0
+	}
+	day, _ := strconv.Atoi(v)
+	return day
+}
+
+// ResetKind is the period without its day ("day" for a day of the month)
+func (c Client) ResetKind() string {
+	if resetDay(c.ResetPeriod) > 0 {
+		return "day"
+	}
+	return c.ResetPeriod
+}
+
+// ResetDay is the day of the month for "day:N" periods, 0 otherwise
+func (c Client) ResetDay() int {
+	return resetDay(c.ResetPeriod)
+}
+
+// SetResetPeriod changes the period; the new cycle starts now so a client
+// switched to "monthly" is not reset immediately for past months.
+func (c *Client) SetResetPeriod(period string) {
+	if c.ResetPeriod == period {
+		return
+	}
+	c.ResetPeriod = period
+	c.LastReset = time.Now()
+}
+
+// NextReset returns when usage is zeroed next (zero time = never)
+func (c Client) NextReset() time.Time {
+	if c.ResetPeriod == ResetNone {
+		return time.Time{}
+	}
+	last := c.LastReset
+	if last.IsZero() {
+		last = c.CreatedAt
+	}
+	last = last.In(time.Local)
+	midnight := time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, time.Local)
+
+	switch c.ResetPeriod {
+	case ResetDaily:
+		return midnight.AddDate(0, 0, 1)
+	case ResetWeekly:
+		days := (8 - int(last.Weekday())) % 7
+		if days == 0 {
+			days = 7
+		}
+		return midnight.AddDate(0, 0, days)
+	}
+
+	day := resetDay(c.ResetPeriod)
+	if c.ResetPeriod == ResetMonthly {
+		anchor := c.CreatedAt
+		if anchor.IsZero() {
+			anchor = last
+		}
+		day = anchor.In(time.Local).Day()
+	}
+	if day == 0 {
+		return time.Time{}
+	}
+	next := dayOfMonth(last.Year(), last.Month(), day)
+	if !next.After(last) {
+		next = dayOfMonth(last.Year(), last.Month()+1, day)
+	}
+	return next
+}
+
+// dayOfMonth is 00:00 of the given day, clamped to the end of the month
+func dayOfMonth(year int, month time.Month, day int) time.Time {
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.Local).Day(); day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+// ResetDue reports whether the current period has ended
+func (c Client) ResetDue(now time.Time) bool {
+	next := c.NextReset()
+	return !next.IsZero() && !now.Before(next)
+}
+
+// ResetUsageIfDue starts a new quota period when the current one has ended:
+// usage moves to PrevUsed and a client blocked by its quota is re-enabled.
+// Periods missed while the panel was down collapse into one reset.
+func ResetUsageIfDue(a Actor, username string) (bool, error) {
+	var before, after Client
+	reset := false
+	err := activeStore.UpdateClient(username, func(c *Client) {
+		now := time.Now()
+		if !c.ResetDue(now) {
+			return
+		}
+		before = cloneClient(*c)
+		c.PrevUsed = c.Used
+		c.Used = 0
+		c.LastReset = now
+		if c.Status == StatusQuotaExceeded {
+			c.SetStatus(StatusActive, "quota reset ("+DescribeResetPeriod(c.ResetPeriod)+")")
+		}
+		after = cloneClient(*c)
+		reset = true
+	})
+	if err != nil || !reset {
+		return false, err
+	}
+	Audit(a, "quota_reset", username, before, after)
+	return true, nil
+}
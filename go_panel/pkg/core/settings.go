@@ -0,0 +1,85 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+var CONFIG_PANEL = "/etc/xray/panel.json"
+
+// PanelSettings holds the panel options that are not per client
+type PanelSettings struct {
+	TrashRetentionDays int  `json:"trash_retention_days"` // 0 = keep forever
+	UsageHourlyDays    int  `json:"usage_hourly_days"`    // hourly usage buckets, 0 = keep forever
+	UsageDailyDays     int  `json:"usage_daily_days"`     // daily usage buckets, 0 = keep forever
+	AutoBackup         bool `json:"auto_backup"`          // run by the xray-backup timer
+	BackupDailyKeep    int  `json:"backup_daily_keep"`    // newest copy of the last N days
+	BackupWeeklyKeep   int  `json:"backup_weekly_keep"`   // newest copy of the last N weeks
+
+	IPLimitPolicy     string `json:"ip_limit_policy"`      // IPLimitWarn or IPLimitDisable
+	IPLimitWindow     int    `json:"ip_limit_window"`      // minutes the distinct IPs are counted over
+	IPLimitBanMinutes int    `json:"ip_limit_ban_minutes"` // how long an over-limit client is disabled
+
+	UserSections []string `json:"user_sections,omitempty"` // config.json sections SyncConfig keeps as they are
+}
+
+func defaultPanelSettings() PanelSettings {
+	return PanelSettings{
+		TrashRetentionDays: 30, UsageHourlyDays: 7, UsageDailyDays: 365,
+		AutoBackup: true, BackupDailyKeep: 7, BackupWeeklyKeep: 4,
+		IPLimitPolicy: IPLimitWarn, IPLimitWindow: 10, IPLimitBanMinutes: 30,
+	}
+}
+
+// LoadPanelSettings returns the saved settings, with defaults for a missing file
+func LoadPanelSettings() (PanelSettings, error) {
+	s := defaultPanelSettings()
+	data, err := os.ReadFile(CONFIG_PANEL)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return defaultPanelSettings(), fmt.Errorf("%s: %v", CONFIG_PANEL, err)
+	}
+	return s, nil
+}
+
+// SavePanelSettings validates and stores the settings
+func SavePanelSettings(a Actor, s PanelSettings) error {
+	if s.TrashRetentionDays < 0 || s.UsageHourlyDays < 0 || s.UsageDailyDays < 0 {
+		return fmt.Errorf("retention days cannot be negative")
+	}
+	if s.BackupDailyKeep < 0 || s.BackupWeeklyKeep < 0 || s.BackupDailyKeep+s.BackupWeeklyKeep == 0 {
+		return fmt.Errorf("keep at least one daily or weekly backup")
+	}
+	if s.IPLimitPolicy != IPLimitWarn && s.IPLimitPolicy != IPLimitDisable {
+		return fmt.Errorf("device limit policy must be %s or %s", IPLimitWarn, IPLimitDisable)
+	}
+	if s.IPLimitWindow < 1 || s.IPLimitBanMinutes < 1 {
+		return fmt.Errorf("device limit window and block time must be at least 1 minute")
+	}
+	if err := CheckUserSections(s.UserSections); err != nil {
+		return err
+	}
+	var before PanelSettings
+	err := withFileLock(CONFIG_PANEL, func() error {
+		var err error
+		if before, err = LoadPanelSettings(); err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		return WriteFileAtomic(CONFIG_PANEL, data, 0644)
+	})
+	if err != nil {
+		return err
+	}
+	Audit(a, "settings", "panel", before, s)
+	return nil
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Account sharing report: the accepted connections in the access log are
+// grouped per email and scored on how many IPs, networks (ASN) and countries
+// they come from and how often several IPs are connected in the same minute.
+// ASN and country need the free GeoLite2 / DB-IP lite mmdb files; without
+// them only the IP and overlap signals are used.
+
+var GEOIP_COUNTRY_DB = "/usr/share/GeoIP/GeoLite2-Country.mmdb"
+var GEOIP_ASN_DB = "/usr/share/GeoIP/GeoLite2-ASN.mmdb"
+
+// Access log yang dibaca per file (access.log dan access.log.1)
+const sharingMaxRead = 64 << 20
+
+// Bobot skor. Ganti IP sering terjadi di jaringan seluler, jadi IP paling
+// ringan; pemakaian bersamaan di menit yang sama paling berat.
+const (
+	sharingWeightIP      = 0.5 // per IP above the device limit
+	sharingWeightASN     = 2   // per extra network
+	sharingWeightCountry = 4   // per extra country
+	sharingWeightOverlap = 0.5 // per minute with more IPs than allowed
+	sharingOverlapCap    = 60  // minutes counted at most
+)
+
+// SharingScore holds the sharing signals of one client
+type SharingScore struct {
+	Username    string
+	Score       float64
+	Connections int
+	IPs         []string // most used first
+	ASNs        []string // "AS13335 Cloudflare", most used first
+	Countries   []string // ISO codes, most used first
+	Overlap     int      // minutes with more distinct IPs than allowed
+	MaxParallel int      // most distinct IPs seen in one minute
+	DeviceLimit int
+	Reasons     []string
+}
+
+// SharingReport ranks the clients by SharingScore, highest first
+type SharingReport struct {
+	Since   time.Time
+	Lines   int // accepted connections read
+	Country bool
+	ASN     bool
+	Scores  []SharingScore // only clients with a score above 0
+}
+
+type geoInfo struct {
+	Country string
+	ASN     string
+}
+
+type geoLookup struct {
+	country, asn *maxminddb.Reader
+	cache        map[string]geoInfo
+}
+
+func openGeoLookup() *geoLookup {
+	g := &geoLookup{cache: make(map[string]geoInfo)}
+	if r, err := maxminddb.Open(GEOIP_COUNTRY_DB); err == nil {
+		g.country = r
+	}
+	if r, err := maxminddb.Open(GEOIP_ASN_DB); err == nil {
+		g.asn = r
+	}
+	return g
+}
+
+func (g *geoLookup) Close() {
+	if g.country != nil {
+		g.country.Close()
+	}
+	if g.asn != nil {
+		g.asn.Close()
+	}
+}
+
+func (g *geoLookup) lookup(ip string) geoInfo {
+	if info, ok := g.cache[ip]; ok {
+		return info
+	}
+	var info geoInfo
+	addr := net.ParseIP(ip)
+	if addr != nil && g.country != nil {
+		var rec struct {
+			Country struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+		}
+		if g.country.Lookup(addr, &rec) == nil {
+			info.Country = rec.Country.ISOCode
+		}
+	}
+	if addr != nil && g.asn != nil {
+		var rec struct {
+			Number uint   `maxminddb:"autonomous_system_number"`
+			Org    string `maxminddb:"autonomous_system_organization"`
+		}
+		if g.asn.Lookup(addr, &rec) == nil && rec.Number != 0 {
+			info.ASN = strings.TrimSpace(fmt.Sprintf("AS%d %s", rec.Number, rec.Org))
+		}
+	}
+	g.cache[ip] = info
+	return info
+}
+
+// sharingKey groups IPv6 addresses by /64: one phone or home network uses
+// many addresses of the same prefix
+func sharingKey(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() != nil {
+		return ip
+	}
+	return (&net.IPNet{IP: addr.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+type sharingUsage struct {
+	conns   int
+	ips     map[string]int            // ip -> connections
+	minutes map[int64]map[string]bool // unix minute -> ips
+}
+
+// BuildSharingReport scores every client seen in the access log since `since`
+func BuildSharingReport(since time.Time) (SharingReport, error) {
+	report := SharingReport{Since: since}
+	clients, err := LoadClients()
+	if err != nil {
+		return report, err
+	}
+	limits := make(map[string]int, len(clients))
+	for _, c := range clients {
+		limits[c.Username] = c.DeviceLimit
+	}
+
+	usage := make(map[string]*sharingUsage)
+	for _, path := range []string{ACCESS_LOG + ".1", ACCESS_LOG} {
+		err := scanAccessLog(path, sharingMaxRead, func(t time.Time, ip, email string) {
+			if t.Before(since) {
+				return
+			}
+			if _, ok := limits[email]; !ok {
+				return // client sudah dihapus
+			}
+			u := usage[email]
+			if u == nil {
+				u = &sharingUsage{ips: make(map[string]int), minutes: make(map[int64]map[string]bool)}
+				usage[email] = u
+			}
+			key := sharingKey(ip)
+			u.conns++
+			u.ips[key]++
+			m := t.Unix() / 60
+			if u.minutes[m] == nil {
+				u.minutes[m] = make(map[string]bool)
+			}
+			u.minutes[m][key] = true
+			report.Lines++
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	geo := openGeoLookup()
+	defer geo.Close()
+	report.Country, report.ASN = geo.country != nil, geo.asn != nil
+
+	for email, u := range usage {
+		s := SharingScore{Username: email, Connections: u.conns, DeviceLimit: limits[email]}
+		allowed := max(s.DeviceLimit, 1)
+		asns := make(map[string]int)
+		countries := make(map[string]int)
+		for ip, n := range u.ips {
+			// Prefix IPv6 dicari dengan alamat jaringannya
+			info := geo.lookup(strings.SplitN(ip, "/", 2)[0])
+			if info.ASN != "" {
+				asns[info.ASN] += n
+			}
+			if info.Country != "" {
+				countries[info.Country] += n
+			}
+		}
+		s.IPs = rankedKeys(u.ips)
+		s.ASNs = rankedKeys(asns)
+		s.Countries = rankedKeys(countries)
+		for _, ips := range u.minutes {
+			s.MaxParallel = max(s.MaxParallel, len(ips))
+			if len(ips) > allowed {
+				s.Overlap++
+			}
+		}
+
+		if n := len(s.IPs) - allowed; n > 0 {
+			s.Score += float64(n) * sharingWeightIP
+			s.Reasons = append(s.Reasons, fmt.Sprintf("%d IPs (limit %d)", len(s.IPs), allowed))
+		}
+		if n := len(s.ASNs) - 1; n > 0 {
+			s.Score += float64(n) * sharingWeightASN
+			s.Reasons = append(s.Reasons, fmt.Sprintf("%d networks", len(s.ASNs)))
+		}
+		if n := len(s.Countries) - 1; n > 0 {
+			s.Score += float64(n) * sharingWeightCountry
+			s.Reasons = append(s.Reasons, fmt.Sprintf("%d countries", len(s.Countries)))
+		}
+		if s.Overlap > 0 {
+			s.Score += float64(min(s.Overlap, sharingOverlapCap)) * sharingWeightOverlap
+			s.Reasons = append(s.Reasons, fmt.Sprintf("%d min with up to %d IPs at once", s.Overlap, s.MaxParallel))
+		}
+		if s.Score > 0 {
+			report.Scores = append(report.Scores, s)
+		}
+	}
+	sort.Slice(report.Scores, func(i, j int) bool {
+		if report.Scores[i].Score != report.Scores[j].Score {
+			return report.Scores[i].Score > report.Scores[j].Score
+		}
+		return report.Scores[i].Username < report.Scores[j].Username
+	})
+	return report, nil
+}
+
+// scanAccessLog calls fn for every accepted connection in the last
+// maxRead bytes of path; a missing file is skipped
+func scanAccessLog(path string, maxRead int64, fn func(t time.Time, ip, email string)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if off := info.Size() - maxRead; off > 0 {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if t, ip, email, ok := parseAccessLine(sc.Text()); ok {
+			fn(t, ip, email)
+		}
+	}
+	return sc.Err()
+}
+
+// rankedKeys returns the keys ordered by count, highest first
+func rankedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// ValidStatuses lists every status accepted by ParseStatus
+var ValidStatuses = []ClientStatus{StatusActive, StatusDisabled, StatusExpired, StatusQuotaExceeded, StatusSuspended, StatusIPLimited}
+
+// ParseStatus converts a stored or user supplied value; empty means active
+// so records written before statuses existed stay enabled.
+func ParseStatus(s string) (ClientStatus, error) {
+	if s == "" {
+		return StatusActive, nil
+	}
+	for _, st := range ValidStatuses {
+		if string(st) == s {
+			return st, nil
+		}
+	}
+	return "", fmt.Errorf("unknown status %q", s)
+}
+
+// IsActive reports whether the client should be written into the Xray config
+func (c Client) IsActive() bool {
+	return c.Status == StatusActive && !c.IsExpired
+}
+
+// QuotaBytes returns the quota in bytes (0 = unlimited)
+func (c Client) QuotaBytes() float64 {
+	return c.Quota * 1024 * 1024 * 1024
+}
+
+// UsagePercent returns the used part of the quota, not capped at 100
+// (0 for an unlimited quota)
+func (c Client) UsagePercent() int {
+	if q := c.QuotaBytes(); q > 0 {
+		return int(c.Used / q * 100)
+	}
+	return 0
+}
+
+// SetStatus changes the status and stamps reason and time
+func (c *Client) SetStatus(status ClientStatus, reason string) {
+	c.Status = status
+	c.StatusReason = reason
+	c.StatusChangedAt = time.Now()
+}
+
+// LiftLimitStatus re-activates an expired or over-quota client once its
+// expiry / quota allow it again. Disabled and suspended clients are left alone.
+func (c *Client) LiftLimitStatus() bool {
+	now := time.Now()
+	switch c.Status {
+	case StatusExpired:
+		if c.Expiry.After(now) {
+			c.SetStatus(StatusActive, "renewed")
+			c.IsExpired = false
+			return true
+		}
+	case StatusQuotaExceeded:
+		if c.Quota <= 0 || c.Used < c.QuotaBytes() {
+			c.SetStatus(StatusActive, "quota restored")
+			return true
+		}
+	}
+	return false
+}
+
+// SetClientStatus persists a status change for one client
+func SetClientStatus(a Actor, username string, status ClientStatus, reason string) error {
+	return updateClient(a, "status", username, func(c *Client) {
+		c.SetStatus(status, reason)
+	})
+}
+
+// setStatusIf changes the status only when check still passes on the stored
+// client, inside the store update. Timers pick clients from an earlier
+// LoadClients; an admin renewing or disabling the client in the meantime
+// must win. Nothing is audited when the client was skipped.
+func setStatusIf(a Actor, username string, status ClientStatus, check func(c Client) (reason string, ok bool)) (bool, error) {
+	var before, after Client
+	changed := false
+	err := activeStore.UpdateClient(username, func(c *Client) {
+		reason, ok := check(*c)
+		if !ok {
+			return
+		}
+		before = cloneClient(*c)
+		c.SetStatus(status, reason)
+		after = cloneClient(*c)
+		changed = true
+	})
+	if err != nil || !changed {
+		return false, err
+	}
+	Audit(a, "status", username, before, after)
+	return true, nil
+}
+
+// ExpireClient marks an active client expired when its expiry has passed.
+// Reports whether the status changed.
+func ExpireClient(a Actor, username string) (bool, error) {
+	return setStatusIf(a, username, StatusExpired, func(c Client) (string, bool) {
+		if c.Status != StatusActive || !time.Now().After(c.Expiry) {
+			return "", false
+		}
+		return "expired at " + c.Expiry.Format("2006-01-02 15:04"), true
+	})
+}
+
+// ExceedQuota marks an active client quota_exceeded when its usage is over
+// the quota. Reports whether the status changed.
+func ExceedQuota(a Actor, username string) (bool, error) {
+	return setStatusIf(a, username, StatusQuotaExceeded, func(c Client) (string, bool) {
+		if c.Status != StatusActive || c.QuotaBytes() <= 0 || c.Used <= c.QuotaBytes() {
+			return "", false
+		}
+		return fmt.Sprintf("used %.2f of %.2f GB", c.Used/1024/1024/1024, c.Quota), true
+	})
+}
+
+// RenewClient extends the expiry by days (counting from now if it already
+// passed) and re-enables expired or over-quota clients with the same UUID.
+// Usage is reset when the client was blocked by its quota.
+func RenewClient(a Actor, username string, days int) error {
+	if days <= 0 {
+		return fmt.Errorf("days must be positive")
+	}
+	return updateClient(a, "renew", username, func(c *Client) {
+		c.Renew(days)
+	})
+}
+
+// Renew is the change RenewClient makes, for callers that batch it with
+// other edits in one update
+func (c *Client) Renew(days int) {
+	base := c.Expiry
+	if base.Before(time.Now()) {
+		base = time.Now()
+	}
+	c.Expiry = base.Add(time.Duration(days) * 24 * time.Hour)
+	c.IsExpired = false
+	if c.Status == StatusQuotaExceeded {
+		c.Used = 0
+	}
+	c.LiftLimitStatus()
+}
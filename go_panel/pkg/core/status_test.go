@@ -0,0 +1,35 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimerStatusRechecksStoredClient(t *testing.T) {
+	setupUsageStore(t, "alice", "bob")
+	// Snapshot timer: alice expired dan bob lewat quota
+	activeStore.UpdateClient("alice", func(c *Client) { c.Expiry = time.Now().Add(-time.Hour) })
+	activeStore.UpdateClient("bob", func(c *Client) { c.Quota = 1; c.Used = 2 << 30 })
+
+	// Admin renew / top up sebelum timer menulis
+	activeStore.UpdateClient("alice", func(c *Client) { c.Renew(30) })
+	activeStore.UpdateClient("bob", func(c *Client) { c.Quota = 5 })
+
+	if changed, err := ExpireClient(TaskActor("test"), "alice"); err != nil || changed {
+		t.Errorf("ExpireClient on a renewed client = %v, %v", changed, err)
+	}
+	if changed, err := ExceedQuota(TaskActor("test"), "bob"); err != nil || changed {
+		t.Errorf("ExceedQuota on a topped up client = %v, %v", changed, err)
+	}
+	clients, _ := LoadClients()
+	for _, c := range clients {
+		if c.Status != StatusActive {
+			t.Errorf("%s status = %s, want active", c.Username, c.Status)
+		}
+	}
+
+	activeStore.UpdateClient("bob", func(c *Client) { c.Used = 6 << 30 })
+	if changed, err := ExceedQuota(TaskActor("test"), "bob"); err != nil || !changed {
+		t.Errorf("ExceedQuota over the quota = %v, %v", changed, err)
+	}
+}
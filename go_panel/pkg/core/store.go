@@ -0,0 +1,130 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+var DB_STORE = "/etc/xray/panel.db"
+
+// ClientStore is the persistence backend for clients and inbounds.
+// The package level helpers (LoadClients, SaveClient, ...) delegate to the
+// store selected with OpenStore.
+type ClientStore interface {
+	LoadClients() ([]Client, error)
+	SaveClient(c Client) error
+	UpdateClient(username string, modifier func(*Client)) error
+	// UpdateClients runs modifier on every client in one transaction;
+	// modifier returns whether it changed the client
+	UpdateClients(modifier func(*Client) bool) error
+	// DeleteClient removes a client; before gets the stored record inside the
+	// same transaction and aborts the delete by returning an error
+	DeleteClient(username string, before func(Client) error) error
+
+	LoadAllInbounds() ([]InboundDet, error)
+	SaveInbound(inb InboundDet) error
+	DeleteInbound(port int) error
+}
+
+// Default ke flat file sampai OpenStore dipanggil dari main
+var activeStore ClientStore = &FileStore{}
+
+// SetStore replaces the active backend
+func SetStore(s ClientStore) {
+	activeStore = s
+}
+
+// GetStore returns the active backend
+func GetStore() ClientStore {
+	return activeStore
+}
+
+// OpenStore selects the backend by name ("bolt" or "file").
+// The bolt backend imports the legacy flat files on first start.
+func OpenStore(kind, path string) error {
+	// Instalasi lama membuat inbounds.db dengan chmod 666
+	if err := os.Chmod(DB_INBOUNDS, inboundsPerm); err != nil && !os.IsNotExist(err) {
+		log.Printf("⚠️  chmod %s: %v", DB_INBOUNDS, err)
+	}
+	switch kind {
+	case "file", "":
+		s := &FileStore{}
+		if err := s.Migrate(); err != nil {
+			return fmt.Errorf("clients.db migration failed: %v", err)
+		}
+		SetStore(s)
+		return nil
+	case "bolt":
+		DB_STORE = path
+		s := &BoltStore{Path: path}
+		if err := MigrateLegacy(s); err != nil {
+			return fmt.Errorf("legacy migration failed: %v", err)
+		}
+		SetStore(s)
+		return nil
+	}
+	return fmt.Errorf("unknown store %q", kind)
+}
+
+// MigrateLegacy copies clients.db and inbounds.db into the bolt store once.
+// The old files are left in place and a timestamped backup is written next to them.
+// Unparsable lines are copied too, -db-check shows them like in the file store.
+func MigrateLegacy(s *BoltStore) error {
+	done, err := s.isMigrated()
+	if err != nil || done {
+		return err
+	}
+
+	cf, err := readClientsFile(DB_CLIENTS)
+	if err != nil {
+		return err
+	}
+	inf, err := readInboundsFile(DB_INBOUNDS)
+	if err != nil {
+		return err
+	}
+	clients, inbounds := cf.Clients, inf.Inbounds
+
+	stamp := time.Now().Format("20060102-150405")
+	for _, path := range []string{DB_CLIENTS, DB_INBOUNDS} {
+		if err := backupFile(path, fmt.Sprintf("%s.bak-%s", path, stamp)); err != nil {
+			return err
+		}
+	}
+
+	if err := s.importAll(clients, inbounds, cf.Bad, inf.Bad); err != nil {
+		return err
+	}
+	if len(clients) > 0 || len(inbounds) > 0 {
+		log.Printf("Migrated %d clients and %d inbounds into %s", len(clients), len(inbounds), s.Path)
+	}
+	if bad := len(cf.Bad) + len(inf.Bad); bad > 0 {
+		log.Printf("⚠️  %d unparsable line(s) of %s / %s were kept in %s, run -db-check to review them", bad, DB_CLIENTS, DB_INBOUNDS, s.Path)
+	}
+	return nil
+}
+
+// backupFile copies src to dst, skipping missing sources
+func backupFile(src, dst string) error {
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -50,7 +50,7 @@ func IsServiceRunning(service string) bool {
 // GetHostname returns the configured domain or system hostname
 func GetHostname() string {
 	// Try reading from /etc/xray/domain first (set by installer)
-	if data, err := os.ReadFile("/etc/xray/domain"); err == nil {
+	if data, err := os.ReadFile(DOMAIN_FILE); err == nil {
 		domain := strings.TrimSpace(string(data))
 		if domain != "" {
 			return domain
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+var DB_TRASH = "/etc/xray/trash.json"
+
+// TrashEntry is a deleted client kept for restore (UUID, usage and expiry intact)
+type TrashEntry struct {
+	ID        string    `json:"id"`
+	Client    Client    `json:"client"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by"`
+}
+
+// LoadTrash returns the recycle bin, oldest first
+func LoadTrash() ([]TrashEntry, error) {
+	data, err := os.ReadFile(DB_TRASH)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []TrashEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", DB_TRASH, err)
+	}
+	return entries, nil
+}
+
+func mutateTrash(fn func([]TrashEntry) ([]TrashEntry, error)) error {
+	return withFileLock(DB_TRASH, func() error {
+		entries, err := LoadTrash()
+		if err != nil {
+			return err
+		}
+		if entries, err = fn(entries); err != nil {
+			return err
+		}
+		if entries == nil {
+			entries = []TrashEntry{}
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		return WriteFileAtomic(DB_TRASH, data, 0600)
+	})
+}
+
+// findTrash matches an entry ID, or the newest entry of a username
+func findTrash(entries []TrashEntry, key string) int {
+	found := -1
+	for i, e := range entries {
+		if e.ID == key {
+			return i
+		}
+		if e.Client.Username == key {
+			found = i
+		}
+	}
+	return found
+}
+
+func removeTrash(key string) (TrashEntry, error) {
+	var removed TrashEntry
+	err := mutateTrash(func(entries []TrashEntry) ([]TrashEntry, error) {
+		i := findTrash(entries, key)
+		if i < 0 {
+			return nil, fmt.Errorf("%s is not in the trash", key)
+		}
+		removed = entries[i]
+		return append(entries[:i], entries[i+1:]...), nil
+	})
+	return removed, err
+}
+
+// DeleteClient moves a client to the trash and removes it from the store
+func DeleteClient(a Actor, username string) error {
+	now := time.Now()
+	entry := TrashEntry{
+		ID:        strconv.FormatInt(now.UnixNano(), 10),
+		DeletedAt: now,
+		DeletedBy: fmt.Sprintf("%s (%s)", a.Name, a.Channel),
+	}
+	trashed := false
+	// Record diambil di dalam transaksi store, jadi yang masuk trash sama
+	// dengan yang dihapus. Masuk trash dulu; kalau hapus gagal, trash dikembalikan.
+	err := activeStore.DeleteClient(username, func(c Client) error {
+		entry.Client = c
+		err := mutateTrash(func(entries []TrashEntry) ([]TrashEntry, error) {
+			return append(entries, entry), nil
+		})
+		trashed = err == nil
+		return err
+	})
+	if err != nil {
+		if trashed {
+			if _, rerr := removeTrash(entry.ID); rerr != nil {
+				log.Printf("trash: rollback of %s failed: %v", username, rerr)
+			}
+		}
+		return err
+	}
+	Audit(a, "delete", username, entry.Client, nil)
+	return nil
+}
+
+// RestoreClient puts a trashed client back with its old UUID, usage, expiry
+// and status. key is a trash entry ID or a username (newest entry).
+func RestoreClient(a Actor, key string) error {
+	entries, err := LoadTrash()
+	if err != nil {
+		return err
+	}
+	i := findTrash(entries, key)
+	if i < 0 {
+		return fmt.Errorf("%s is not in the trash", key)
+	}
+	c := entries[i].Client
+
+	clients, err := LoadClients()
+	if err != nil {
+		return err
+	}
+	for _, cur := range clients {
+		if cur.Username == c.Username {
+			return errUserExists(c.Username)
+		}
+		if cur.UUID == c.UUID {
+			return fmt.Errorf("UUID is already used by %s", cur.Username)
+		}
+	}
+	c.IsExpired = time.Now().After(c.Expiry)
+	if err := activeStore.SaveClient(c); err != nil {
+		return err
+	}
+	if _, err := removeTrash(entries[i].ID); err != nil {
+		log.Printf("trash: %s restored but not removed from trash: %v", c.Username, err)
+	}
+	Audit(a, "restore", c.Username, nil, c)
+	return nil
+}
+
+// PurgeTrash deletes one trash entry for good
+func PurgeTrash(a Actor, key string) error {
+	e, err := removeTrash(key)
+	if err != nil {
+		return err
+	}
+	Audit(a, "purge", e.Client.Username, e.Client, nil)
+	return nil
+}
+
+// PurgeExpiredTrash removes entries older than the retention in PanelSettings
+func PurgeExpiredTrash(a Actor) (int, error) {
+	settings, err := LoadPanelSettings()
+	if err != nil {
+		return 0, err
+	}
+	if settings.TrashRetentionDays <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-time.Duration(settings.TrashRetentionDays) * 24 * time.Hour)
+
+	var purged []TrashEntry
+	err = mutateTrash(func(entries []TrashEntry) ([]TrashEntry, error) {
+		var kept []TrashEntry
+		for _, e := range entries {
+			if e.DeletedAt.Before(cutoff) {
+				purged = append(purged, e)
+				continue
+			}
+			kept = append(kept, e)
+		}
+		return kept, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range purged {
+		Audit(a, "purge", e.Client.Username, e.Client, nil)
+	}
+	return len(purged), nil
+}
@@ -2,16 +2,44 @@ package core
 
 import "time"
 
+// ClientStatus is the persisted lifecycle state of a client
+type ClientStatus string
+
+const (
+	StatusActive        ClientStatus = "active"
+	StatusDisabled      ClientStatus = "disabled"
+	StatusExpired       ClientStatus = "expired"
+	StatusQuotaExceeded ClientStatus = "quota_exceeded"
+	StatusSuspended     ClientStatus = "suspended"
+	StatusIPLimited     ClientStatus = "ip_limited" // over DeviceLimit, lifted by the device limit check
+)
+
 // Client represents a user in the system (from clients.db)
 type Client struct {
-	Username  string    `json:"username"`
-	Quota     float64   `json:"quota"` // GB
-	Used      float64   `json:"used"`  // Bytes
-	Expiry    time.Time `json:"expiry"`
-	Protocol  string    `json:"protocol"` // e.g., VLESS-XTLS
-	UUID      string    `json:"uuid"`
-	IsExpired bool      `json:"is_expired"`
-	IsOnline  bool      `json:"is_online"`
+	Username        string            `json:"username"`
+	Quota           float64           `json:"quota"` // GB
+	Used            float64           `json:"used"`  // Bytes
+	Expiry          time.Time         `json:"expiry"`
+	Protocol        string            `json:"protocol"`           // e.g., VLESS-XTLS (first attached inbound)
+	Inbounds        []string          `json:"inbounds,omitempty"` // attached inbound tags
+	UUID            string            `json:"uuid"`
+	Password        string            `json:"password,omitempty"` // trojan password when it is not the UUID (imported clients)
+	Status          ClientStatus      `json:"status"`
+	StatusReason    string            `json:"status_reason,omitempty"`
+	StatusChangedAt time.Time         `json:"status_changed_at"`
+	CreatedAt       time.Time         `json:"created_at"`
+	Notes           string            `json:"notes,omitempty"`
+	Tags            []string          `json:"tags,omitempty"`
+	Owner           string            `json:"owner,omitempty"`
+	TelegramID      int64             `json:"telegram_id,omitempty"`
+	Plan            string            `json:"plan,omitempty"`
+	DeviceLimit     int               `json:"device_limit,omitempty"` // 0 = unlimited
+	ResetPeriod     string            `json:"reset_period,omitempty"` // see ParseResetPeriod
+	LastReset       time.Time         `json:"last_reset"`             // start of the current quota period
+	PrevUsed        float64           `json:"prev_used,omitempty"`    // bytes used in the previous period
+	Extra           map[string]string `json:"extra,omitempty"`        // unknown clients.db columns
+	IsExpired       bool              `json:"is_expired"`
+	IsOnline        bool              `json:"is_online"`
 }
 
 // XrayConfig matches the structure of config.json
@@ -55,11 +83,12 @@ type XrayClient struct {
 }
 
 type StreamSettings struct {
-	Network      string        `json:"network"`
-	Security     string        `json:"security"`
-	TLSSettings  *TLSSettings  `json:"tlsSettings,omitempty"`
-	WSSettings   *WSSettings   `json:"wsSettings,omitempty"`
-	GRPCSettings *GRPCSettings `json:"grpcSettings,omitempty"`
+	Network         string           `json:"network"`
+	Security        string           `json:"security"`
+	TLSSettings     *TLSSettings     `json:"tlsSettings,omitempty"`
+	RealitySettings *RealitySettings `json:"realitySettings,omitempty"`
+	WSSettings      *WSSettings      `json:"wsSettings,omitempty"`
+	GRPCSettings    *GRPCSettings    `json:"grpcSettings,omitempty"`
 }
 
 type TLSSettings struct {
@@ -67,6 +96,16 @@ type TLSSettings struct {
 	Alpn         []string      `json:"alpn,omitempty"`
 }
 
+type RealitySettings struct {
+	Show        bool     `json:"show"`
+	Dest        string   `json:"dest,omitempty"`
+	Target      string   `json:"target,omitempty"` // newer name of dest, only read on import
+	Xver        int      `json:"xver"`
+	ServerNames []string `json:"serverNames"`
+	PrivateKey  string   `json:"privateKey"`
+	ShortIds    []string `json:"shortIds"`
+}
+
 type Certificate struct {
 	CertificateFile string `json:"certificateFile"`
 	KeyFile         string `json:"keyFile"`
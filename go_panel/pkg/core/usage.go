@@ -0,0 +1,356 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var DB_USAGE = "/etc/xray/usage.db"
+
+// Granularity of a usage bucket
+type Granularity string
+
+const (
+	Hourly Granularity = "hourly"
+	Daily  Granularity = "daily"
+)
+
+// Bucket keys are local wall clock times so they sort and read naturally
+const (
+	hourKeyLayout = "2006-01-02T15"
+	dayKeyLayout  = "2006-01-02"
+)
+
+// UsageSample is the traffic of one client since the previous collection
+type UsageSample struct {
+	Username string
+	Up, Down int64
+}
+
+// UsagePoint is one hourly or daily bucket
+type UsagePoint struct {
+	Time time.Time `json:"time"`
+	Up   int64     `json:"up"`
+	Down int64     `json:"down"`
+}
+
+func (p UsagePoint) Total() int64 {
+	return p.Up + p.Down
+}
+
+func (g Granularity) layout() string {
+	if g == Daily {
+		return dayKeyLayout
+	}
+	return hourKeyLayout
+}
+
+// truncate returns the start of the bucket containing t
+func (g Granularity) truncate(t time.Time) time.Time {
+	t = t.In(time.Local)
+	if g == Daily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.Local)
+}
+
+func (g Granularity) next(t time.Time) time.Time {
+	if g == Daily {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.Add(time.Hour)
+}
+
+func openUsageDB(readOnly bool) (*bolt.DB, error) {
+	return bolt.Open(DB_USAGE, 0600, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: readOnly})
+}
+
+func encodeUsage(up, down int64) []byte {
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v[:8], uint64(up))
+	binary.BigEndian.PutUint64(v[8:], uint64(down))
+	return v
+}
+
+func decodeUsage(v []byte) (up, down int64) {
+	if len(v) != 16 {
+		return 0, 0
+	}
+	return int64(binary.BigEndian.Uint64(v[:8])), int64(binary.BigEndian.Uint64(v[8:]))
+}
+
+// Traffic that was read (and reset) from Xray but not yet added to the
+// clients is parked here, so it survives a failed save
+func pendingTrafficPath() string { return DB_USAGE + ".pending" }
+
+// CollectTraffic reads and resets the Xray counters and adds the traffic to
+// Client.Used of every client in one store transaction. The traffic is
+// written to the pending file first; when saving fails it stays there and
+// the next run adds it again. When the pending file cannot be written the
+// run stops with an error. Returned is the traffic that was saved, by
+// username; traffic of emails that are no longer clients is dropped.
+func CollectTraffic() (map[string]UserTraffic, error) {
+	pending, err := readPendingTraffic()
+	if err != nil {
+		return nil, err // jangan reset counter kalau traffic lama tidak terbaca
+	}
+	fresh, queryErr := QueryUserTraffic(true)
+	for email, t := range fresh {
+		if t.Up == 0 && t.Down == 0 {
+			continue
+		}
+		p := pending[email]
+		p.Up += t.Up
+		p.Down += t.Down
+		pending[email] = p
+	}
+	if len(pending) == 0 {
+		return nil, queryErr
+	}
+	if len(fresh) > 0 {
+		data, _ := json.Marshal(pending)
+		if err := WriteFileAtomic(pendingTrafficPath(), data, 0600); err != nil {
+			return nil, fmt.Errorf("park traffic in %s (traffic read from Xray is lost): %v", pendingTrafficPath(), err)
+		}
+	}
+
+	saved := make(map[string]UserTraffic)
+	err = activeStore.UpdateClients(func(c *Client) bool {
+		t, ok := pending[c.Username]
+		if !ok {
+			return false
+		}
+		c.Used += float64(t.Up + t.Down)
+		saved[c.Username] = t
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("save traffic (kept in %s for the next run): %v", pendingTrafficPath(), err)
+	}
+	for email := range pending {
+		if _, ok := saved[email]; !ok {
+			log.Printf("usage: traffic of %s dropped, no such client", email)
+		}
+	}
+	if err := os.Remove(pendingTrafficPath()); err != nil && !os.IsNotExist(err) {
+		log.Printf("usage: %v", err) // bisa terhitung dua kali di run berikutnya
+	}
+	return saved, queryErr
+}
+
+func readPendingTraffic() (map[string]UserTraffic, error) {
+	pending := make(map[string]UserTraffic)
+	data, err := readOptional(pendingTrafficPath())
+	if err != nil || data == nil {
+		return pending, err
+	}
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, fmt.Errorf("%s: %v", pendingTrafficPath(), err)
+	}
+	return pending, nil
+}
+
+// RecordUsage adds one collection to the hourly and daily buckets of each
+// client (bucket "hourly" or "daily" -> username -> time key). The daily
+// total is rolled up at write time, so pruning old hours loses no totals.
+func RecordUsage(at time.Time, samples []UsageSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	db, err := openUsageDB(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, g := range []Granularity{Hourly, Daily} {
+			root, err := tx.CreateBucketIfNotExists([]byte(g))
+			if err != nil {
+				return err
+			}
+			key := []byte(g.truncate(at).Format(g.layout()))
+			for _, s := range samples {
+				if s.Up == 0 && s.Down == 0 {
+					continue
+				}
+				b, err := root.CreateBucketIfNotExists([]byte(s.Username))
+				if err != nil {
+					return err
+				}
+				up, down := decodeUsage(b.Get(key))
+				if err := b.Put(key, encodeUsage(up+s.Up, down+s.Down)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// UsageHistory returns one point per bucket in [from, to), zero filled
+func UsageHistory(username string, g Granularity, from, to time.Time) ([]UsagePoint, error) {
+	if g != Hourly && g != Daily {
+		return nil, fmt.Errorf("unknown granularity %q", g)
+	}
+	var points []UsagePoint
+	for t := g.truncate(from); t.Before(to); t = g.next(t) {
+		points = append(points, UsagePoint{Time: t})
+	}
+	if len(points) == 0 {
+		return nil, nil
+	}
+	err := viewUsage(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(g))
+		if root == nil {
+			return nil
+		}
+		b := root.Bucket([]byte(username))
+		if b == nil {
+			return nil
+		}
+		for i := range points {
+			points[i].Up, points[i].Down = decodeUsage(b.Get([]byte(points[i].Time.Format(g.layout()))))
+		}
+		return nil
+	})
+	return points, err
+}
+
+// UsageTotals sums the daily buckets of every client from the day of since
+func UsageTotals(since time.Time) (map[string]UsagePoint, error) {
+	totals := make(map[string]UsagePoint)
+	from := []byte(Daily.truncate(since).Format(dayKeyLayout))
+	err := viewUsage(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(Daily))
+		if root == nil {
+			return nil
+		}
+		return root.ForEachBucket(func(name []byte) error {
+			p := UsagePoint{Time: Daily.truncate(since)}
+			c := root.Bucket(name).Cursor()
+			for k, v := c.Seek(from); k != nil; k, v = c.Next() {
+				up, down := decodeUsage(v)
+				p.Up += up
+				p.Down += down
+			}
+			totals[string(name)] = p
+			return nil
+		})
+	})
+	return totals, err
+}
+
+func viewUsage(fn func(tx *bolt.Tx) error) error {
+	if _, err := os.Stat(DB_USAGE); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := openUsageDB(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+// readUsageDB returns a consistent copy of usage.db, nil if there is none
+func readUsageDB() ([]byte, error) {
+	var buf bytes.Buffer
+	err := viewUsage(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(&buf)
+		return err
+	})
+	if err != nil || buf.Len() == 0 {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkUsageDB opens a usage.db image read-only to make sure it is one
+func checkUsageDB(data []byte) error {
+	f, err := os.CreateTemp("", "usage-*.db")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	db, err := bolt.Open(f.Name(), 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if g := Granularity(name); g != Hourly && g != Daily {
+				return fmt.Errorf("unexpected bucket %q", name)
+			}
+			return nil
+		})
+	})
+}
+
+// PruneUsage drops buckets older than the retention in PanelSettings and
+// removes clients left without any bucket. It returns the buckets removed.
+func PruneUsage(now time.Time) (int, error) {
+	settings, err := LoadPanelSettings()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(DB_USAGE); os.IsNotExist(err) {
+		return 0, nil
+	}
+	db, err := openUsageDB(false)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	removed := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		for g, days := range map[Granularity]int{Hourly: settings.UsageHourlyDays, Daily: settings.UsageDailyDays} {
+			root := tx.Bucket([]byte(g))
+			if root == nil || days <= 0 {
+				continue
+			}
+			cutoff := []byte(Daily.truncate(now).AddDate(0, 0, -days).Format(g.layout()))
+			var empty [][]byte
+			err := root.ForEachBucket(func(name []byte) error {
+				b := root.Bucket(name)
+				c := b.Cursor()
+				// Key berformat tanggal, jadi urutan byte = urutan waktu
+				for k, _ := c.First(); k != nil && string(k) < string(cutoff); k, _ = c.First() {
+					if err := b.Delete(k); err != nil {
+						return err
+					}
+					removed++
+				}
+				if k, _ := c.First(); k == nil {
+					empty = append(empty, append([]byte(nil), name...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, name := range empty {
+				if err := root.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingStore fails every batch update, like a locked or full disk
+type failingStore struct{ ClientStore }
+
+func (failingStore) UpdateClients(func(*Client) bool) error { return fmt.Errorf("disk full") }
+
+func setupUsageStore(t *testing.T, names ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	oldClients, oldInbounds, oldUsage, oldStore := DB_CLIENTS, DB_INBOUNDS, DB_USAGE, activeStore
+	t.Cleanup(func() {
+		DB_CLIENTS, DB_INBOUNDS, DB_USAGE, activeStore = oldClients, oldInbounds, oldUsage, oldStore
+	})
+	DB_CLIENTS = filepath.Join(dir, "clients.db")
+	DB_INBOUNDS = filepath.Join(dir, "inbounds.db")
+	DB_USAGE = filepath.Join(dir, "usage.db")
+	activeStore = &FileStore{}
+	for _, name := range names {
+		c := Client{Username: name, UUID: GenerateUUID(), Expiry: time.Now().AddDate(1, 0, 0)}
+		if err := activeStore.SaveClient(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func usedOf(t *testing.T, name string) float64 {
+	t.Helper()
+	clients, err := LoadClients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		if c.Username == name {
+			return c.Used
+		}
+	}
+	t.Fatalf("client %s not found", name)
+	return 0
+}
+
+func TestCollectTrafficKeepsTrafficWhenSaveFails(t *testing.T) {
+	setupUsageStore(t, "alice", "bob")
+	f := &fakeStatsService{stats: []XrayStat{
+		{"user>>>alice>>>traffic>>>uplink", 100},
+		{"user>>>alice>>>traffic>>>downlink", 900},
+		{"user>>>ghost>>>traffic>>>downlink", 5},
+	}}
+	startFakeStats(t, f)
+
+	// Simpan gagal: counter Xray sudah di-reset, traffic harus tetap ada
+	store := activeStore
+	activeStore = failingStore{store}
+	if _, err := CollectTraffic(); err == nil {
+		t.Fatal("expected the save error")
+	}
+	if _, err := os.Stat(pendingTrafficPath()); err != nil {
+		t.Fatalf("pending traffic not kept: %v", err)
+	}
+
+	// Run berikutnya menambahkan traffic lama dan baru sekaligus
+	activeStore = store
+	f.stats = []XrayStat{{"user>>>alice>>>traffic>>>uplink", 24}, {"user>>>bob>>>traffic>>>uplink", 7}}
+	saved, err := CollectTraffic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := usedOf(t, "alice"); got != 1024 {
+		t.Errorf("alice used = %v, want 1024", got)
+	}
+	if got := usedOf(t, "bob"); got != 7 {
+		t.Errorf("bob used = %v, want 7", got)
+	}
+	if saved["alice"] != (UserTraffic{Up: 124, Down: 900}) || len(saved) != 2 {
+		t.Errorf("saved = %v", saved)
+	}
+	if _, err := os.Stat(pendingTrafficPath()); !os.IsNotExist(err) {
+		t.Errorf("pending traffic left behind: %v", err)
+	}
+}
+
+func TestCollectTrafficQueryErrorSavesPending(t *testing.T) {
+	setupUsageStore(t, "alice")
+	data := []byte(`{"alice":{"Up":10,"Down":20}}`)
+	if err := os.WriteFile(pendingTrafficPath(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	startFakeStats(t, &fakeStatsService{err: fmt.Errorf("xray down")})
+
+	if _, err := CollectTraffic(); err == nil {
+		t.Error("query error not returned")
+	}
+	if got := usedOf(t, "alice"); got != 30 {
+		t.Errorf("alice used = %v, want 30", got)
+	}
+}
+
+func TestCollectTrafficPendingWriteFails(t *testing.T) {
+	setupUsageStore(t, "alice")
+	// Folder pending tidak ada: traffic tidak bisa diparkir
+	DB_USAGE = filepath.Join(t.TempDir(), "missing", "usage.db")
+	startFakeStats(t, &fakeStatsService{stats: []XrayStat{{"user>>>alice>>>traffic>>>uplink", 50}}})
+
+	_, err := CollectTraffic()
+	if err == nil {
+		t.Fatal("expected the pending write error")
+	}
+	if strings.Contains(err.Error(), "kept in") {
+		t.Errorf("error claims the traffic was kept: %v", err)
+	}
+	if got := usedOf(t, "alice"); got != 0 {
+		t.Errorf("alice used = %v, want 0", got)
+	}
+}
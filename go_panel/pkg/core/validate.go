@@ -0,0 +1,140 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Batas username. Username dipakai sebagai email di config Xray dan sebagai
+// kolom di clients.db, jadi hanya karakter yang aman di keduanya.
+const (
+	UsernameMinLen = 3
+	UsernameMaxLen = 32
+)
+
+var (
+	usernameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	uuidRe     = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+// Every front-end (web, bot, CLI) shows these messages as they are
+func errUserExists(username string) error {
+	return fmt.Errorf("user %s already exists", username)
+}
+
+func errUserNotFound(username string) error {
+	return fmt.Errorf("user %s not found", username)
+}
+
+// clientConflict is why c cannot be stored next to cur. The stores check it
+// inside their lock: usernames are unique ignoring case ("Budi" and "budi"
+// would be the same email to a person) and every client has its own UUID and
+// trojan password.
+func clientConflict(c, cur Client) error {
+	if strings.EqualFold(c.Username, cur.Username) {
+		return errUserExists(cur.Username)
+	}
+	if c.UUID != "" && c.UUID == cur.UUID {
+		return fmt.Errorf("UUID is already used by %s", cur.Username)
+	}
+	if p := c.TrojanPassword(); p != "" && p == cur.TrojanPassword() {
+		return fmt.Errorf("trojan password is already used by %s", cur.Username)
+	}
+	return nil
+}
+
+// inboundConflict is why inb cannot be stored next to cur; checked by the
+// stores inside their lock like clientConflict. Xray can bind one inbound
+// per port.
+func inboundConflict(inb, cur InboundDet) error {
+	if inb.Port == cur.Port {
+		return fmt.Errorf("port %d already used by %s", inb.Port, cur.Tag)
+	}
+	return nil
+}
+
+// NormalizeUUID trims and lowercases a UUID typed by a user
+func NormalizeUUID(id string) string {
+	return strings.ToLower(strings.TrimSpace(id))
+}
+
+// CheckUsernameFormat validates charset and length only
+func CheckUsernameFormat(username string) error {
+	if n := len(username); n < UsernameMinLen || n > UsernameMaxLen {
+		return fmt.Errorf("username must be %d-%d characters", UsernameMinLen, UsernameMaxLen)
+	}
+	if !usernameRe.MatchString(username) {
+		return fmt.Errorf("username may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit")
+	}
+	return nil
+}
+
+// CheckUUIDFormat validates the canonical 8-4-4-4-12 lowercase hex form
+func CheckUUIDFormat(id string) error {
+	if !uuidRe.MatchString(id) {
+		return fmt.Errorf("invalid UUID %q (expected xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx)", id)
+	}
+	return nil
+}
+
+// ValidateUsername checks format and that no client uses the name yet,
+// in any case
+func ValidateUsername(username string) error {
+	if err := CheckUsernameFormat(username); err != nil {
+		return err
+	}
+	clients, err := LoadClients()
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		if strings.EqualFold(c.Username, username) {
+			return errUserExists(c.Username)
+		}
+	}
+	return nil
+}
+
+// ValidateUUID checks format and that no other client uses the UUID.
+// owner is the client that may keep it (empty for a new client).
+func ValidateUUID(id, owner string) error {
+	if err := CheckUUIDFormat(id); err != nil {
+		return err
+	}
+	clients, err := LoadClients()
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		if c.UUID == id && c.Username != owner {
+			return fmt.Errorf("UUID is already used by %s", c.Username)
+		}
+	}
+	return nil
+}
+
+// ValidateNewClient runs every check for a client about to be created
+func ValidateNewClient(c Client) error {
+	if err := ValidateUsername(c.Username); err != nil {
+		return err
+	}
+	if c.DeviceLimit < 0 {
+		return fmt.Errorf("max IPs cannot be negative")
+	}
+	return ValidateUUID(c.UUID, "")
+}
+
+// ParseDeviceLimit reads a max-IP value typed by a user; empty = unlimited
+func ParseDeviceLimit(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("max IPs must be a number, 0 = unlimited")
+	}
+	return n, nil
+}
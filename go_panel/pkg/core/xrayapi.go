@@ -0,0 +1,324 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Xray gRPC API on the "api" inbound (see baseXrayConfig). The messages are
+// encoded by hand so the panel does not pull in xray-core for its protos.
+var XRAY_API = "127.0.0.1:10085"
+
+const xrayAPITimeout = 5 * time.Second
+
+const (
+	alterInboundMethod = "/xray.app.proxyman.command.HandlerService/AlterInbound"
+	addUserOpType      = "xray.app.proxyman.command.AddUserOperation"
+	removeUserOpType   = "xray.app.proxyman.command.RemoveUserOperation"
+)
+
+// rawCodec sends already encoded protobuf bytes. The name stays "proto" so
+// Xray sees the usual application/grpc+proto content type.
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "proto" }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec: unexpected %T", v)
+	}
+	return b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec: unexpected %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// dialXrayAPI connects lazily; errors show up on the first call
+func dialXrayAPI() (*grpc.ClientConn, error) {
+	return grpc.NewClient(XRAY_API,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+}
+
+// typedMessage encodes xray.common.serial.TypedMessage
+func typedMessage(typ string, value []byte) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, typ)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, value)
+	return b
+}
+
+func appendStringField(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// xrayAccount encodes the account message of one client entry
+func xrayAccount(protocol string, c XrayClient) ([]byte, error) {
+	var b []byte
+	switch protocol {
+	case "vless":
+		// xray.proxy.vless.Account: id = 1, flow = 2
+		b = appendStringField(b, 1, c.ID)
+		b = appendStringField(b, 2, c.Flow)
+		return typedMessage("xray.proxy.vless.Account", b), nil
+	case "vmess":
+		// xray.proxy.vmess.Account: id = 1, security_settings = 3 (AUTO = 2)
+		b = appendStringField(b, 1, c.ID)
+		var sec []byte
+		sec = protowire.AppendTag(sec, 1, protowire.VarintType)
+		sec = protowire.AppendVarint(sec, 2)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, sec)
+		return typedMessage("xray.proxy.vmess.Account", b), nil
+	case "trojan":
+		// xray.proxy.trojan.Account: password = 1
+		b = appendStringField(b, 1, c.Password)
+		return typedMessage("xray.proxy.trojan.Account", b), nil
+	}
+	return nil, fmt.Errorf("protocol %s cannot be changed live", protocol)
+}
+
+// addUserRequest encodes AlterInboundRequest{tag, AddUserOperation{User}}
+func addUserRequest(tag, protocol string, c XrayClient) ([]byte, error) {
+	account, err := xrayAccount(protocol, c)
+	if err != nil {
+		return nil, err
+	}
+	// xray.common.protocol.User: level = 1, email = 2, account = 3
+	var user []byte
+	if c.Level != 0 {
+		user = protowire.AppendTag(user, 1, protowire.VarintType)
+		user = protowire.AppendVarint(user, uint64(c.Level))
+	}
+	user = appendStringField(user, 2, c.Email)
+	user = protowire.AppendTag(user, 3, protowire.BytesType)
+	user = protowire.AppendBytes(user, account)
+
+	var op []byte
+	op = protowire.AppendTag(op, 1, protowire.BytesType)
+	op = protowire.AppendBytes(op, user)
+	return alterInboundRequest(tag, typedMessage(addUserOpType, op)), nil
+}
+
+// removeUserRequest encodes AlterInboundRequest{tag, RemoveUserOperation{email}}
+func removeUserRequest(tag, email string) []byte {
+	op := appendStringField(nil, 1, email)
+	return alterInboundRequest(tag, typedMessage(removeUserOpType, op))
+}
+
+func alterInboundRequest(tag string, operation []byte) []byte {
+	b := appendStringField(nil, 1, tag)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, operation)
+}
+
+// liveUserOp is one AddUser / RemoveUser call
+type liveUserOp struct {
+	Tag      string
+	Protocol string
+	Remove   bool
+	Client   XrayClient
+}
+
+func (op liveUserOp) String() string {
+	if op.Remove {
+		return fmt.Sprintf("remove %s from %s", op.Client.Email, op.Tag)
+	}
+	return fmt.Sprintf("add %s to %s", op.Client.Email, op.Tag)
+}
+
+// applyLiveUserOps sends the operations in order and stops at the first error
+func applyLiveUserOps(ops []liveUserOp) error {
+	conn, err := dialXrayAPI()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, op := range ops {
+		req := removeUserRequest(op.Tag, op.Client.Email)
+		if !op.Remove {
+			if req, err = addUserRequest(op.Tag, op.Protocol, op.Client); err != nil {
+				return err
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), xrayAPITimeout)
+		var resp []byte
+		err = conn.Invoke(ctx, alterInboundMethod, req, &resp)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%s: %v", op, err)
+		}
+	}
+	return nil
+}
+
+// planLiveUpdate compares the running config with the new one. It returns
+// the user operations that turn one into the other, or ok=false when
+// anything besides inbound clients changed and Xray must be restarted.
+func planLiveUpdate(oldConf, newConf map[string]interface{}) (ops []liveUserOp, ok bool) {
+	if oldConf == nil || newConf == nil {
+		return nil, false
+	}
+	for _, key := range unionKeys(oldConf, newConf) {
+		if key != "inbounds" && !reflect.DeepEqual(oldConf[key], newConf[key]) {
+			return nil, false
+		}
+	}
+	oldList, _ := oldConf["inbounds"].([]interface{})
+	newList, _ := newConf["inbounds"].([]interface{})
+	if len(oldList) != len(newList) {
+		return nil, false
+	}
+	for i := range newList {
+		oldInb, _ := oldList[i].(map[string]interface{})
+		newInb, _ := newList[i].(map[string]interface{})
+		if oldInb == nil || newInb == nil {
+			return nil, false
+		}
+		oldClients, oldRest := splitClients(oldInb)
+		newClients, newRest := splitClients(newInb)
+		if !reflect.DeepEqual(oldRest, newRest) {
+			return nil, false // definisi inbound berubah
+		}
+		if reflect.DeepEqual(oldClients, newClients) {
+			continue
+		}
+		tag, protocol := jsonField(newInb, "tag"), jsonField(newInb, "protocol")
+		if tag == "" {
+			return nil, false
+		}
+		inbOps, ok := diffClientEntries(tag, protocol, oldClients, newClients)
+		if !ok {
+			return nil, false
+		}
+		ops = append(ops, inbOps...)
+	}
+	return ops, true
+}
+
+// splitClients returns settings.clients and a copy of the inbound without them
+func splitClients(inb map[string]interface{}) ([]interface{}, map[string]interface{}) {
+	rest := make(map[string]interface{}, len(inb))
+	for k, v := range inb {
+		rest[k] = v
+	}
+	settings, _ := inb["settings"].(map[string]interface{})
+	if settings == nil {
+		return nil, rest
+	}
+	clients, _ := settings["clients"].([]interface{})
+	s := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		if k != "clients" {
+			s[k] = v
+		}
+	}
+	rest["settings"] = s
+	return clients, rest
+}
+
+// diffClientEntries matches clients by email; a changed client is removed
+// and added again
+func diffClientEntries(tag, protocol string, oldList, newList []interface{}) ([]liveUserOp, bool) {
+	oldBy, ok := clientsByEmail(oldList)
+	if !ok {
+		return nil, false
+	}
+	newBy, ok := clientsByEmail(newList)
+	if !ok {
+		return nil, false
+	}
+	var ops []liveUserOp
+	for _, email := range sortedClientEmails(oldBy) {
+		if n, still := newBy[email]; !still || n != oldBy[email] {
+			ops = append(ops, liveUserOp{Tag: tag, Protocol: protocol, Remove: true, Client: oldBy[email]})
+		}
+	}
+	for _, email := range sortedClientEmails(newBy) {
+		if o, had := oldBy[email]; !had || o != newBy[email] {
+			if _, err := xrayAccount(protocol, newBy[email]); err != nil {
+				return nil, false
+			}
+			ops = append(ops, liveUserOp{Tag: tag, Protocol: protocol, Client: newBy[email]})
+		}
+	}
+	return ops, true
+}
+
+// clientsByEmail reads client entries; entries with fields the panel does
+// not know or without an email cannot be applied live
+func clientsByEmail(list []interface{}) (map[string]XrayClient, bool) {
+	out := make(map[string]XrayClient, len(list))
+	for _, v := range list {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		var c XrayClient
+		for k := range m {
+			switch k {
+			case "id":
+				c.ID = jsonField(m, k)
+			case "password":
+				c.Password = jsonField(m, k)
+			case "email":
+				c.Email = jsonField(m, k)
+			case "flow":
+				c.Flow = jsonField(m, k)
+			case "level":
+				fmt.Sscan(jsonField(m, k), &c.Level)
+			default:
+				return nil, false
+			}
+		}
+		if _, dup := out[c.Email]; dup || c.Email == "" {
+			return nil, false
+		}
+		out[c.Email] = c
+	}
+	return out, true
+}
+
+func sortedClientEmails(m map[string]XrayClient) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range []map[string]interface{}{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,201 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Applying config.json safely:
+//
+//   - SyncConfig writes the new config to config.json.staging, checks it
+//     with `xray run -test` and only then renames it over config.json
+//   - client changes go live through the HandlerService API (xrayapi.go),
+//     other changes restart Xray
+//   - after a live update or a restart that leaves Xray active, config.json
+//     is copied to config.json.last-good
+//   - when the restart fails or Xray is not active afterwards, the rejected
+//     config is moved to config.json.failed, last-good is put back and Xray
+//     restarted again; the caller gets an error saying so
+var xrayMu sync.Mutex
+
+// Berapa lama ditunggu sebelum memastikan Xray tidak langsung mati lagi
+var xrayStartWait = 2 * time.Second
+
+func stagingConfigPath() string  { return CONFIG_XRAY + ".staging" }
+func lastGoodConfigPath() string { return CONFIG_XRAY + ".last-good" }
+func failedConfigPath() string   { return CONFIG_XRAY + ".failed" }
+
+// XrayBinary returns the path of the installed xray binary, "" if none
+func XrayBinary() string {
+	for _, p := range []string{"/usr/local/bin/xray", "/usr/bin/xray"} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// TestXrayConfig runs `xray run -test` on a config file. Without an xray
+// binary there is nothing to test against and the config is accepted.
+func TestXrayConfig(path string) error {
+	bin := XrayBinary()
+	if bin == "" {
+		return nil
+	}
+	out, err := exec.Command(bin, "run", "-test", "-format", "json", "-config", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("xray rejected the new config: %s", lastLines(out, 5))
+	}
+	return nil
+}
+
+// SyncConfig rewrites CONFIG_XRAY from the databases; the parts of the
+// config the panel does not manage are kept, see overlay.go. The new config
+// is tested first; when Xray rejects it config.json is left untouched.
+func SyncConfig() error {
+	newConfig, owned, err := previewConfig()
+	if err != nil {
+		return err
+	}
+
+	xrayMu.Lock()
+	defer xrayMu.Unlock()
+	staging := stagingConfigPath()
+	if err := WriteFileAtomic(staging, newConfig, 0644); err != nil {
+		return err
+	}
+	if err := TestXrayConfig(staging); err != nil {
+		os.Remove(staging)
+		return err
+	}
+	// Config yang sedang jalan jadi last-good pertama kalau belum ada
+	if _, err := os.Stat(lastGoodConfigPath()); os.IsNotExist(err) {
+		if cur, err := readOptional(CONFIG_XRAY); err == nil && cur != nil {
+			WriteFileAtomic(lastGoodConfigPath(), cur, 0644)
+		}
+	}
+	if st, err := os.Stat(CONFIG_XRAY); err == nil {
+		os.Chmod(staging, st.Mode().Perm())
+	}
+	if err := os.Rename(staging, CONFIG_XRAY); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(owned, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(ownedConfigPath(), data, 0644)
+}
+
+// RestartXray restarts the service and checks it stays up. On failure the
+// last-known-good config is restored; the returned error says what happened.
+func RestartXray() error {
+	xrayMu.Lock()
+	defer xrayMu.Unlock()
+
+	err := restartXrayService()
+	if err == nil {
+		saveLastGoodConfig()
+		return nil
+	}
+
+	good, gerr := os.ReadFile(lastGoodConfigPath())
+	cur, _ := os.ReadFile(CONFIG_XRAY)
+	if gerr != nil || bytes.Equal(good, cur) {
+		return fmt.Errorf("restart xray: %v", err) // tidak ada config lain untuk dipulihkan
+	}
+	if werr := WriteFileAtomic(failedConfigPath(), cur, 0600); werr != nil {
+		log.Printf("xray: could not keep the rejected config: %v", werr)
+	}
+	if werr := WriteFileAtomic(CONFIG_XRAY, good, 0644); werr != nil {
+		return fmt.Errorf("restart xray: %v; putting back the last-known-good config failed: %v", err, werr)
+	}
+	if rerr := restartXrayService(); rerr != nil {
+		return fmt.Errorf("restart xray: %v; the last-known-good config was put back but Xray still fails: %v", err, rerr)
+	}
+	log.Printf("xray: restart failed (%v), last-known-good config restored", err)
+	return fmt.Errorf("restart xray: %v; the last-known-good config was restored, the rejected config is in %s", err, failedConfigPath())
+}
+
+// Satu apply pada satu waktu, supaya diff config lama/baru tidak tumpang tindih
+var applyMu sync.Mutex
+
+// ApplyXrayConfig syncs config.json and puts it live. When only clients of
+// existing inbounds changed they are added and removed through the
+// HandlerService API so connected users stay online; anything else, or a
+// failed API call, restarts Xray. When the sync fails nothing is restarted
+// and Xray keeps the old config.
+//
+// The diff is against config.json.last-good, the config Xray last loaded:
+// config.json itself may hold changes that were synced but never applied.
+func ApplyXrayConfig() error {
+	applyMu.Lock()
+	defer applyMu.Unlock()
+
+	if err := SyncConfig(); err != nil {
+		return fmt.Errorf("sync config: %v", err)
+	}
+	// Dibaca setelah SyncConfig, yang membuat last-good pertama kalau belum ada
+	running, _ := readConfigFile(lastGoodConfigPath()) // tidak ada / rusak = restart biasa
+	next, err := readCurrentConfig()
+	if err != nil {
+		return err
+	}
+	ops, live := planLiveUpdate(running, next)
+	if live && IsServiceRunning("xray") {
+		err := applyLiveUserOps(ops)
+		if err == nil {
+			if len(ops) > 0 {
+				xrayMu.Lock()
+				saveLastGoodConfig()
+				xrayMu.Unlock()
+			}
+			return nil
+		}
+		log.Printf("xray: live update failed (%v), restarting instead", err)
+	}
+	return RestartXray()
+}
+
+// saveLastGoodConfig copies the config Xray runs with; caller holds xrayMu
+func saveLastGoodConfig() {
+	if cur, err := os.ReadFile(CONFIG_XRAY); err == nil {
+		WriteFileAtomic(lastGoodConfigPath(), cur, 0644)
+	}
+}
+
+func restartXrayService() error {
+	if out, err := exec.Command("systemctl", "restart", "xray").CombinedOutput(); err != nil {
+		if msg := lastLines(out, 3); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	time.Sleep(xrayStartWait)
+	if !IsServiceRunning("xray") {
+		out, _ := exec.Command("journalctl", "-u", "xray", "-n", "5", "--no-pager", "-o", "cat").Output()
+		return fmt.Errorf("xray is not active after restart: %s", lastLines(out, 5))
+	}
+	return nil
+}
+
+// lastLines returns the last n non-empty lines of command output, joined
+func lastLines(out []byte, n int) string {
+	var lines []string
+	for _, l := range strings.Split(string(out), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, " | ")
+}
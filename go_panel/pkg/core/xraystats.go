@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const queryStatsMethod = "/xray.app.stats.command.StatsService/QueryStats"
+
+// UserTraffic is the traffic of one client since the last reset, in bytes
+type UserTraffic struct {
+	Up   int64
+	Down int64
+}
+
+// XrayStat is one counter returned by QueryStats
+type XrayStat struct {
+	Name  string
+	Value int64
+}
+
+// QueryStats returns every counter whose name contains pattern in a single
+// StatsService call; with reset the counters are zeroed at the same time.
+// Errors are returned as is so traffic is never silently counted as 0.
+func QueryStats(pattern string, reset bool) ([]XrayStat, error) {
+	// xray.app.stats.command.QueryStatsRequest: pattern = 1, reset = 2
+	req := appendStringField(nil, 1, pattern)
+	if reset {
+		req = protowire.AppendTag(req, 2, protowire.VarintType)
+		req = protowire.AppendVarint(req, 1)
+	}
+
+	conn, err := dialXrayAPI()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), xrayAPITimeout)
+	defer cancel()
+	var resp []byte
+	if err := conn.Invoke(ctx, queryStatsMethod, req, &resp); err != nil {
+		return nil, fmt.Errorf("xray stats: %v", err)
+	}
+	return parseQueryStatsResponse(resp)
+}
+
+// parseQueryStatsResponse reads QueryStatsResponse{repeated Stat stat = 1}
+// with Stat{name = 1, value = 2}
+func parseQueryStatsResponse(b []byte) ([]XrayStat, error) {
+	var stats []XrayStat
+	err := walkFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		if num != 1 || v == nil {
+			return nil
+		}
+		var st XrayStat
+		err := walkFields(v, func(num protowire.Number, v []byte, n uint64) error {
+			switch num {
+			case 1:
+				st.Name = string(v)
+			case 2:
+				st.Value = int64(n)
+			}
+			return nil
+		})
+		stats = append(stats, st)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("xray stats: bad response: %v", err)
+	}
+	return stats, nil
+}
+
+// walkFields calls fn for every field of a protobuf message; length
+// delimited fields come as v, varints as n, other types are skipped
+func walkFields(b []byte, fn func(num protowire.Number, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+		switch typ {
+		case protowire.BytesType:
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				return protowire.ParseError(l)
+			}
+			b = b[l:]
+			if v == nil {
+				v = []byte{}
+			}
+			if err := fn(num, v, 0); err != nil {
+				return err
+			}
+		case protowire.VarintType:
+			n, l := protowire.ConsumeVarint(b)
+			if l < 0 {
+				return protowire.ParseError(l)
+			}
+			b = b[l:]
+			if err := fn(num, nil, n); err != nil {
+				return err
+			}
+		default:
+			l := protowire.ConsumeFieldValue(num, typ, b)
+			if l < 0 {
+				return protowire.ParseError(l)
+			}
+			b = b[l:]
+		}
+	}
+	return nil
+}
+
+// QueryUserTraffic returns the traffic of every client Xray has seen, keyed
+// by email (= username). With reset the counters start again from zero, so
+// the caller must store the result.
+func QueryUserTraffic(reset bool) (map[string]UserTraffic, error) {
+	stats, err := QueryStats("user>>>", reset)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]UserTraffic)
+	for _, st := range stats {
+		// user>>>[email]>>>traffic>>>uplink|downlink
+		parts := strings.Split(st.Name, ">>>")
+		if len(parts) != 4 || parts[0] != "user" || parts[2] != "traffic" {
+			continue
+		}
+		t := out[parts[1]]
+		switch parts[3] {
+		case "uplink":
+			t.Up += st.Value
+		case "downlink":
+			t.Down += st.Value
+		}
+		out[parts[1]] = t
+	}
+	return out, nil
+}
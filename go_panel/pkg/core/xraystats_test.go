@@ -0,0 +1,165 @@
+package core
+
+import (
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeStatsService serves QueryStats on 127.0.0.1:0 and records the requests
+type fakeStatsService struct {
+	stats []XrayStat
+	err   error // dikirim sebagai status gRPC kalau tidak nil
+
+	methods []string
+	resets  []bool
+	pattern string
+}
+
+func (f *fakeStatsService) handle(_ interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	f.methods = append(f.methods, method)
+	var req []byte
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	reset := false
+	walkFields(req, func(num protowire.Number, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			f.pattern = string(v)
+		case 2:
+			reset = n == 1
+		}
+		return nil
+	})
+	f.resets = append(f.resets, reset)
+	if f.err != nil {
+		return f.err
+	}
+	if method != queryStatsMethod {
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+	var resp []byte
+	for _, st := range f.stats {
+		var s []byte
+		s = appendStringField(s, 1, st.Name)
+		s = protowire.AppendTag(s, 2, protowire.VarintType)
+		s = protowire.AppendVarint(s, uint64(st.Value))
+		resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+		resp = protowire.AppendBytes(resp, s)
+	}
+	return stream.SendMsg(resp)
+}
+
+// startFakeStats points XRAY_API at a fake StatsService for one test
+func startFakeStats(t *testing.T, f *fakeStatsService) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(f.handle), grpc.ForceServerCodec(rawCodec{}))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	setXrayAPI(t, lis.Addr().String())
+}
+
+func setXrayAPI(t *testing.T, addr string) {
+	old := XRAY_API
+	XRAY_API = addr
+	t.Cleanup(func() { XRAY_API = old })
+}
+
+func TestQueryUserTrafficSumsPerEmail(t *testing.T) {
+	f := &fakeStatsService{stats: []XrayStat{
+		{"user>>>alice>>>traffic>>>uplink", 100},
+		{"user>>>alice>>>traffic>>>downlink", 2000},
+		{"user>>>alice>>>traffic>>>uplink", 50},
+		{"user>>>bob>>>traffic>>>downlink", 7},
+		{"user>>>bob>>>traffic>>>uplink", 3},
+		// nama yang tidak dikenal dilewati
+		{"user>>>carol>>>traffic", 9},
+		{"user>>>carol>>>online>>>uplink", 9},
+		{"inbound>>>vless-ws-443>>>traffic>>>uplink", 9},
+		{"user>>>dave>>>traffic>>>uplink>>>extra", 9},
+		{"", 9},
+	}}
+	startFakeStats(t, f)
+
+	got, err := QueryUserTraffic(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]UserTraffic{
+		"alice": {Up: 150, Down: 2000},
+		"bob":   {Up: 3, Down: 7},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for email, w := range want {
+		if got[email] != w {
+			t.Errorf("%s: got %+v, want %+v", email, got[email], w)
+		}
+	}
+	if len(f.methods) != 1 || f.methods[0] != queryStatsMethod {
+		t.Errorf("methods = %v, want one %s call", f.methods, queryStatsMethod)
+	}
+	if f.pattern != "user>>>" {
+		t.Errorf("pattern = %q", f.pattern)
+	}
+}
+
+func TestQueryUserTrafficSendsReset(t *testing.T) {
+	f := &fakeStatsService{}
+	startFakeStats(t, f)
+
+	if _, err := QueryUserTraffic(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := QueryUserTraffic(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.resets) != 2 || !f.resets[0] || f.resets[1] {
+		t.Errorf("reset flags = %v, want [true false]", f.resets)
+	}
+}
+
+func TestQueryUserTrafficStatusError(t *testing.T) {
+	f := &fakeStatsService{err: status.Error(codes.Unavailable, "stats disabled")}
+	startFakeStats(t, f)
+
+	got, err := QueryUserTraffic(true)
+	if err == nil {
+		t.Fatalf("expected an error, got %v", got)
+	}
+	if got != nil {
+		t.Errorf("traffic returned with error: %v", got)
+	}
+}
+
+func TestQueryUserTrafficDialError(t *testing.T) {
+	// Port yang baru saja ditutup: tidak ada yang mendengarkan
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	setXrayAPI(t, addr)
+
+	if got, err := QueryUserTraffic(true); err == nil {
+		t.Fatalf("expected an error, got %v", got)
+	}
+}
+
+func TestParseQueryStatsResponseMalformed(t *testing.T) {
+	if _, err := parseQueryStatsResponse([]byte{0x0a, 0x05, 'a'}); err == nil {
+		t.Error("truncated response accepted")
+	}
+}
@@ -2,12 +2,15 @@ package tasks
 
 import (
 	"log"
+	"strings"
 	"time"
 
 	"github.com/krisna112/scriptxray/go_panel/pkg/core"
 )
 
 // RunExpiryCheck replaces xp.sh
+// Expired users are kept in the DB with status "expired" (same UUID and usage)
+// and SyncConfig leaves them out of config.json until they are renewed.
 func RunExpiryCheck() {
 	log.Println("Running Expiry Check...")
 	clients, err := core.LoadClients()
@@ -18,68 +21,109 @@ func RunExpiryCheck() {
 
 	configChanged := false
 	for _, c := range clients {
-		if c.Expiry.Before(time.Now()) && !c.IsExpired {
-			// Mark as expired in DB (actually LoadClients calculates IsExpired,
-			// but we might want to update the protocol string to include -EXPIRED if logic demands it,
-			// or just remove the user from config.json)
-
-			// In the original python logic:
-			// "EXPIRED" in proto string meant disabled.
-			// Let's stick to core logic: if expired, we remove from config.json but keep in DB?
-			// Or we update DB to say EXPIRED.
-
-			// Current core.SyncConfig() reads DB.
-			// If we want to disable them, we should update DB protocol or expiry flag.
-
-			// Let's assume we remove them from Config but keep in DB with -EXPIRED tag if not present
-			// Or simply relying on SyncConfig filtering?
-
-			// Original xp.sh deletes users from config.
-
-			log.Printf("User %s expired.", c.Username)
-
-			// We can use UpdateClient to tag it if we want persistence
-			// But SyncConfig is what matters for Xray.
-
-			// Simple approach: Delete from Config if expired.
-			// Currently implementation of SyncConfig might not filter expired users unless we tell it to.
-			// Let's check SyncConfig logic... it puts everyone from DB to Config.
+		if c.Status != core.StatusActive || !time.Now().After(c.Expiry) {
+			continue
+		}
+		// Dicek ulang di dalam update: renew oleh admin sejak LoadClients menang
+		changed, err := core.ExpireClient(core.TaskActor("expiry-check"), c.Username)
+		if err != nil {
+			log.Printf("Failed to mark %s expired: %v", c.Username, err)
+			continue
+		}
+		if changed {
+			log.Printf("Disabled expired user: %s", c.Username)
+			configChanged = true
+		}
+	}
 
-			// So we need to update DB to flag them as disabled/expired so SyncConfig skips them?
-			// Or SyncConfig should check expiry date.
+	if configChanged {
+		if err := core.ApplyXrayConfig(); err != nil {
+			log.Printf("Apply Xray config failed: %v", err)
 		}
 	}
+	log.Println("Expiry Check Done.")
+}
 
-	// Actually, let's implement the logic:
-	// Iterate clients. If expired -> Delete from Config (or add -EXPIRED tag).
-	// To match xp.sh behavior (often deletes user or disables):
-	// Let's disable them by modifying protocol or just rely on SyncConfig.
+// RunTrashPurge deletes recycle bin entries older than the configured retention
+func RunTrashPurge() {
+	n, err := core.PurgeExpiredTrash(core.TaskActor("trash-purge"))
+	if err != nil {
+		log.Printf("Trash purge failed: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Purged %d client(s) from the trash", n)
+	}
+}
 
-	// FIX: Let's make SyncConfig smart enough to exclude expired users,
-	// OR we modify the DB to add "EXPIRED" tag to protocol so SyncConfig sees it.
+// RunUsagePrune drops usage history buckets past their retention
+func RunUsagePrune() {
+	n, err := core.PruneUsage(time.Now())
+	if err != nil {
+		log.Printf("Usage history prune failed: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Pruned %d usage history bucket(s)", n)
+	}
+}
 
-	// Updating DB is safer for persistence.
-	for _, c := range clients {
-		if time.Now().After(c.Expiry) {
-			// Logic to disable
-			if err := core.DeleteClient(c.Username); err == nil {
-				log.Printf("Deleted expired user: %s", c.Username)
-				configChanged = true
-			}
-		}
+// RunAutoBackup is started daily by the xray-backup timer
+func RunAutoBackup() {
+	s, err := core.LoadPanelSettings()
+	if err != nil {
+		log.Printf("Backup: %v", err)
+	}
+	if !s.AutoBackup {
+		log.Println("Scheduled backups are disabled.")
+		return
+	}
+	st, err := core.RunAutoBackup()
+	if err != nil {
+		log.Printf("Backup failed: %v", err)
+		return
+	}
+	log.Printf("Backup written to %s (%s), %d old copy(ies) removed", st.File, core.FormatBytes(float64(st.Size)), st.Pruned)
+	if st.UploadError != "" {
+		log.Printf("Backup upload failed: %s", st.UploadError)
+	} else if st.Uploaded {
+		log.Println("Backup copied to S3.")
 	}
+}
 
-	if configChanged {
-		core.SyncConfig()
-		core.RestartXray()
+// RunIPLimitCheck is started every minute by the xray-iplimit timer
+func RunIPLimitCheck() {
+	res, err := core.CheckIPLimits(core.TaskActor("device-limit"), time.Now())
+	if err != nil {
+		// Client yang sudah diubah statusnya tetap harus sampai ke Xray
+		log.Printf("Device limit check failed: %v", err)
+	}
+	for _, v := range res.Violations {
+		log.Printf("User %s %s: %d IPs, limit %d (%s)", v.Username, v.Action, len(v.IPs), v.Limit, strings.Join(v.IPs, ", "))
+	}
+	for _, name := range res.Restored {
+		log.Printf("User %s enabled again after device limit block", name)
+	}
+	if res.Changed {
+		if err := core.ApplyXrayConfig(); err != nil {
+			log.Printf("Apply Xray config failed: %v", err)
+		}
 	}
-	log.Println("Expiry Check Done.")
 }
 
 // RunQuotaCheck replaces quota.sh
 func RunQuotaCheck() {
 	log.Println("Running Quota Check...")
 
+	// Satu QueryStats untuk semua user, langsung disimpan dalam satu
+	// transaksi. Kalau menyimpan gagal, traffic-nya disimpan lagi di run
+	// berikutnya (lihat CollectTraffic).
+	traffic, err := core.CollectTraffic()
+	if err != nil {
+		log.Printf("Failed to collect traffic from Xray: %v", err)
+	}
+
+	// Dibaca setelah traffic disimpan, jadi Used sudah termasuk run ini
 	clients, err := core.LoadClients()
 	if err != nil {
 		log.Printf("Error loading clients: %v", err)
@@ -87,71 +131,55 @@ func RunQuotaCheck() {
 	}
 
 	configChanged := false
+	var samples []core.UsageSample
+	for name, t := range traffic {
+		samples = append(samples, core.UsageSample{Username: name, Up: t.Up, Down: t.Down})
+	}
+	now := time.Now()
 	for _, c := range clients {
-		// 1. Get Traffic
-		up, down, _ := core.GetTraffic(c.Username)
-
-		// 2. Update DB Used
-		// Note: Xray API stats are cumulative since start.
-		// If Xray restarts, stats reset.
-		// The original script handles this by storing accumulated stats in stats.db or similar.
-		// For this simple migration, we'll implement a basic accumulator if possible,
-		// OR we simply assume the DB 'Used' field + current session traffic is the total.
-		// However, protecting against reset requires a specialized service or file storage for 'last seen'.
-
-		// Simplification for migration:
-		// We add the fetched traffic to the client.Used.
-		// BUT we must be careful not to double count if we run this loop often.
-		// The API returns "current session" traffic.
-
-		// Correct Logic (as per python/shell script):
-		// Total = DB_Stored + Current_Session
-		// But when Xray restarts, Current_Session becomes 0.
-		// To persist, we should periodically flush Current_Session to DB_Stored and reset Xray stats?
-		// No, usually we just read.
-
-		// Let's rely on the DB Used field being "Previous Epochs" and API being "Current Epoch".
-		// But detecting restart is hard.
-
-		// Strategy: core.UpdateClient to save usage?
-		// Let's just update the DB with the latest known usage.
-
-		// We will stick to the Python logic:
-		// total_used = db_usage + session_traffic
-
-		fetchedTraffic := float64(up + down)
-
-		// Since GetTraffic now resets the counter, we MUST add it to the DB immediately
-		if fetchedTraffic > 0 {
-			err := core.UpdateClient(c.Username, func(target *core.Client) {
-				target.Used += fetchedTraffic
-			})
+		// Periode quota habis: traffic di atas masih dihitung ke periode
+		// lama, lalu usage dinolkan dan user yang kena quota aktif lagi
+		if c.ResetDue(now) {
+			wasBlocked := c.Status == core.StatusQuotaExceeded
+			reset, err := core.ResetUsageIfDue(core.TaskActor("quota-reset"), c.Username)
 			if err != nil {
-				log.Printf("Failed to update usage for %s: %v", c.Username, err)
+				log.Printf("Failed to reset usage for %s: %v", c.Username, err)
+			} else if reset {
+				log.Printf("Quota period of %s reset (%s)", c.Username, core.DescribeResetPeriod(c.ResetPeriod))
+				if wasBlocked {
+					configChanged = true
+				}
+				continue
 			}
-			// Update local struct for quota check logic below
-			c.Used += fetchedTraffic
 		}
 
 		currentTotal := c.Used
 
 		// 3. Check Quota
-		quotaBytes := c.Quota * 1024 * 1024 * 1024
+		quotaBytes := c.QuotaBytes()
 
-		if quotaBytes > 0 && currentTotal > quotaBytes {
-			// Limit reached
-			if !c.IsExpired { // reusing IsExpired as generic "Disabled" flag for now
+		if quotaBytes > 0 && currentTotal > quotaBytes && c.Status == core.StatusActive {
+			// Limit reached: keep the record, only take it out of config.json.
+			// Top up oleh admin sejak LoadClients dicek ulang di dalam update.
+			changed, err := core.ExceedQuota(core.TaskActor("quota-check"), c.Username)
+			if err != nil {
+				log.Printf("Failed to mark %s over quota: %v", c.Username, err)
+			} else if changed {
 				log.Printf("User %s exceeded quota (%.2f / %.2f GB)", c.Username, currentTotal/1024/1024/1024, c.Quota)
-				if err := core.DeleteClient(c.Username); err == nil {
-					configChanged = true
-				}
+				configChanged = true
 			}
 		}
 	}
 
+	// Riwayat per jam/hari; gagal di sini tidak boleh menghentikan cek quota
+	if err := core.RecordUsage(time.Now(), samples); err != nil {
+		log.Printf("Failed to record usage history: %v", err)
+	}
+
 	if configChanged {
-		core.SyncConfig()
-		core.RestartXray()
+		if err := core.ApplyXrayConfig(); err != nil {
+			log.Printf("Apply Xray config failed: %v", err)
+		}
 	}
 	log.Println("Quota Check Done.")
 }
@@ -0,0 +1,59 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// Batas baris yang ditampilkan; sisanya lewat export CLI
+const auditPageLimit = 500
+
+// AuditRow is an entry with before/after rendered for the table
+type AuditRow struct {
+	core.AuditEntry
+	BeforeText string
+	AfterText  string
+}
+
+func AuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := core.AuditFilter{
+		Actor:   q.Get("actor"),
+		Channel: core.Channel(q.Get("channel")),
+		Action:  q.Get("action"),
+		Target:  q.Get("target"),
+	}
+	if t, err := time.ParseInLocation("2006-01-02", q.Get("since"), time.Local); err == nil {
+		f.Since = t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", q.Get("until"), time.Local); err == nil {
+		f.Until = t.Add(24*time.Hour - time.Second)
+	}
+
+	entries, err := core.ReadAudit(f)
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+
+	// Terbaru di atas
+	var rows []AuditRow
+	for i := len(entries) - 1; i >= 0 && len(rows) < auditPageLimit; i-- {
+		rows = append(rows, AuditRow{
+			AuditEntry: entries[i],
+			BeforeText: string(entries[i].Before),
+			AfterText:  string(entries[i].After),
+		})
+	}
+
+	Render(w, "audit.html", map[string]interface{}{
+		"rows":     rows,
+		"total":    len(entries),
+		"limit":    auditPageLimit,
+		"q":        q,
+		"channels": []core.Channel{core.ChannelWeb, core.ChannelBot, core.ChannelCLI, core.ChannelTask},
+		"error":    errMsg,
+	})
+}
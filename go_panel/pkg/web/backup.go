@@ -0,0 +1,137 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// BackupHandler downloads a full backup archive
+func BackupHandler(w http.ResponseWriter, r *http.Request) {
+	// Dibuat di memori dulu supaya error tidak menghasilkan file setengah jadi
+	var buf bytes.Buffer
+	if _, err := core.WriteBackup(&buf); err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Backup: "+err.Error())
+		return
+	}
+	name := fmt.Sprintf("backup-%s-%s.tar.gz", core.GetHostname(), time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Write(buf.Bytes())
+}
+
+// RestoreUploadHandler validates an uploaded archive and shows what a
+// restore would change. Nothing is written until the admin confirms.
+func RestoreUploadHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<20)
+	file, _, err := r.FormFile("backup_file")
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Restore: choose a backup file")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Restore: "+err.Error())
+		return
+	}
+	token, b, err := core.StagePendingRestore(data, r.FormValue("passphrase"))
+	var changes []core.BackupChange
+	if err == nil {
+		changes, err = b.Diff()
+	}
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Restore: "+err.Error())
+		return
+	}
+	Render(w, "restore.html", map[string]interface{}{
+		"manifest": b.Manifest,
+		"changes":  changes,
+		"token":    token,
+	})
+}
+
+// RestoreApplyHandler applies a confirmed upload, then syncs and restarts Xray
+func RestoreApplyHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := core.TakePendingRestore(r.FormValue("token"))
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Restore: "+err.Error())
+		return
+	}
+	pre, err := core.RestoreBackup(webActor(), b)
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Restore: "+err.Error())
+		return
+	}
+	msg := "Backup restored. Previous state saved to " + pre
+	if err := core.ApplyXrayConfig(); err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", msg+", but "+err.Error())
+		return
+	}
+	renderSettings(w, core.GetAdminCreds(), msg, "")
+}
+
+// BackupSettingsHandler saves the schedule, retention and S3 target
+func BackupSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	s, _ := core.LoadPanelSettings()
+	s.AutoBackup = r.FormValue("auto_backup") != ""
+	var err error
+	if s.BackupDailyKeep, err = strconv.Atoi(r.FormValue("backup_daily_keep")); err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Keep daily: not a number")
+		return
+	}
+	if s.BackupWeeklyKeep, err = strconv.Atoi(r.FormValue("backup_weekly_keep")); err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Keep weekly: not a number")
+		return
+	}
+
+	old, _ := core.LoadS3Config()
+	s3 := core.S3Config{
+		Endpoint:   r.FormValue("s3_endpoint"),
+		UseSSL:     r.FormValue("s3_ssl") != "",
+		Region:     strings.TrimSpace(r.FormValue("s3_region")),
+		Bucket:     strings.TrimSpace(r.FormValue("s3_bucket")),
+		Prefix:     strings.TrimSpace(r.FormValue("s3_prefix")),
+		AccessKey:  strings.TrimSpace(r.FormValue("s3_access_key")),
+		SecretKey:  r.FormValue("s3_secret_key"),
+		Passphrase: r.FormValue("s3_passphrase"),
+	}
+	// Secret / passphrase kosong = tidak diganti
+	if s3.SecretKey == "" {
+		s3.SecretKey = old.SecretKey
+	}
+	if s3.Passphrase == "" && r.FormValue("s3_no_encrypt") == "" {
+		s3.Passphrase = old.Passphrase
+	}
+
+	if err := core.SavePanelSettings(webActor(), s); err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", err.Error())
+		return
+	}
+	if s3 != old {
+		if err := core.SaveS3Config(webActor(), s3); err != nil {
+			renderSettings(w, core.GetAdminCreds(), "", "S3: "+err.Error())
+			return
+		}
+	}
+	renderSettings(w, core.GetAdminCreds(), "Backup settings saved", "")
+}
+
+// RunBackupHandler runs the scheduled backup right away
+func RunBackupHandler(w http.ResponseWriter, r *http.Request) {
+	st, err := core.RunAutoBackup()
+	switch {
+	case err != nil:
+		renderSettings(w, core.GetAdminCreds(), "", "Backup failed: "+err.Error())
+	case st.UploadError != "":
+		renderSettings(w, core.GetAdminCreds(), "", "Backup saved to "+st.File+", but the S3 upload failed: "+st.UploadError)
+	default:
+		renderSettings(w, core.GetAdminCreds(), "Backup saved to "+st.File, "")
+	}
+}
@@ -0,0 +1,149 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// Batas ukuran file import (10 MB cukup untuk puluhan ribu client)
+const importMaxBytes = 10 << 20
+
+// Database 3x-ui / Marzban bisa jauh lebih besar karena log trafik
+const panelImportMaxBytes = 128 << 20
+
+// ExportClientsHandler downloads every client as csv or json
+func ExportClientsHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != core.FormatJSON {
+		format = core.FormatCSV
+	}
+	clients, err := core.LoadClients()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := fmt.Sprintf("clients-%s.%s", time.Now().Format("20060102-150405"), format)
+	if format == core.FormatJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	core.ExportClients(w, clients, format)
+}
+
+// ImportClientsHandler imports an uploaded export and shows the report.
+// Xray is synced and restarted once, after all clients are written.
+func ImportClientsHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Import: choose a file")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Import: "+err.Error())
+		return
+	}
+
+	opt := core.ImportOptions{
+		KeepUUID: r.FormValue("keep_uuid") != "",
+		Conflict: r.FormValue("conflict"),
+		DryRun:   r.FormValue("dry_run") != "",
+	}
+	clients, err := core.ParseClientImport(data, core.DetectImportFormat(header.Filename, data))
+	var report core.ImportReport
+	if err == nil {
+		report, err = core.ImportClients(webActor(), clients, opt)
+	}
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Import: "+err.Error())
+		return
+	}
+	var xrayErr string
+	if report.Changed() {
+		if err := core.ApplyXrayConfig(); err != nil {
+			xrayErr = err.Error()
+		}
+	}
+
+	Render(w, "import.html", map[string]interface{}{
+		"file":      header.Filename,
+		"report":    report,
+		"counts":    importCounts(report),
+		"xrayError": xrayErr,
+	})
+}
+
+func importCounts(report core.ImportReport) map[string]int {
+	return map[string]int{
+		"created":     report.Count("created"),
+		"overwritten": report.Count("overwritten"),
+		"renamed":     report.Count("renamed"),
+		"skipped":     report.Count("skipped"),
+		"failed":      report.Count("failed"),
+	}
+}
+
+// ImportPanelHandler imports an uploaded 3x-ui/x-ui database, Marzban
+// database / export or Xray config.json and shows the inbounds, clients and unmapped items
+func ImportPanelHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, panelImportMaxBytes)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Panel import: choose a file")
+		return
+	}
+	defer file.Close()
+
+	// SQLite butuh file di disk
+	tmp, err := os.CreateTemp("", "panel-import-*")
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Panel import: "+err.Error())
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, file)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	var fi *core.ForeignImport
+	if err == nil {
+		fi, err = core.ReadForeignPanel(tmp.Name())
+	}
+	var report core.ForeignReport
+	if err == nil {
+		report, err = core.ApplyForeignImport(webActor(), fi, core.ImportOptions{
+			KeepUUID: true,
+			Conflict: r.FormValue("conflict"),
+			DryRun:   r.FormValue("dry_run") != "",
+		})
+	}
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Panel import: "+err.Error())
+		return
+	}
+	var xrayErr string
+	if report.InboundsChanged() || report.Clients.Changed() {
+		if err := core.ApplyXrayConfig(); err != nil {
+			xrayErr = err.Error()
+		}
+	}
+
+	Render(w, "import.html", map[string]interface{}{
+		"file":      header.Filename + " (" + report.Source + ")",
+		"report":    report.Clients,
+		"counts":    importCounts(report.Clients),
+		"inbounds":  report.Inbounds,
+		"unmapped":  report.Unmapped,
+		"overlay":   report.Overlay,
+		"xrayError": xrayErr,
+	})
+}
@@ -1,8 +1,11 @@
 package web
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/krisna112/scriptxray/go_panel/pkg/core"
@@ -10,42 +13,154 @@ import (
 
 // Dashboard Data
 type DashboardData struct {
-	Users           []core.Client
+	Users           []UserRow
 	CPU             float64
 	RAM             float64
 	TotalUsage      string
 	OnlineCount     int
 	XrayStatus      bool
 	InstallDuration string
+	Backup          core.BackupStatus
+	AutoBackup      bool
+	IPViolations    []core.IPViolation // device limit, last 24 hours
+	IPLimitPolicy   string
+	Total           int // every client; Users holds only the filtered ones
+	Filter          core.ClientFilter
+	FilterQuery     string // free text of the search box
+	FilterUsage     string // "80" (at least) or "<10" (below)
+	FilterError     string
+	XrayError       string // set when the last change could not be applied
+	Tags            []string
+	Inbounds        []string
+	Statuses        []core.ClientStatus
+}
+
+// UserRow is a client plus the values the dashboard card displays
+type UserRow struct {
+	core.Client
+	Days          int
+	UsedFmt       string
+	Percent       int
+	ProgressClass string
+	ResetInfo     string // next quota reset and last period usage
+	IPs           int    // distinct IPs in the device limit window
+	Links         []core.ClientLink
+}
+
+func newUserRow(c core.Client, domain string, inbounds []core.InboundDet) UserRow {
+	row := UserRow{
+		Client:        c,
+		UsedFmt:       core.FormatBytes(c.Used),
+		ProgressClass: "bg-emerald-500",
+		Links:         core.GenerateLinkFor(c, domain, inbounds),
+	}
+	if days := int(time.Until(c.Expiry).Hours() / 24); days > 0 {
+		row.Days = days
+	}
+	if next := c.NextReset(); !next.IsZero() {
+		row.ResetInfo = "Resets " + core.DescribeResetPeriod(c.ResetPeriod) + ", next " + next.Format("2006-01-02")
+		if !c.LastReset.IsZero() && c.PrevUsed > 0 {
+			row.ResetInfo += " · last period " + core.FormatBytes(c.PrevUsed)
+		}
+	}
+	if c.Quota > 0 {
+		row.Percent = c.UsagePercent()
+		if row.Percent > 100 {
+			row.Percent = 100
+		}
+		if row.Percent >= 90 {
+			row.ProgressClass = "bg-red-500"
+		} else if row.Percent >= 70 {
+			row.ProgressClass = "bg-yellow-500"
+		}
+	}
+	return row
 }
 
 func DashboardHandler(w http.ResponseWriter, r *http.Request) {
 	clients, _ := core.LoadClients()
+	domain := core.GetHostname()
 
 	var totalBytes float64
 	online := 0
-	
+	rows := make([]UserRow, 0, len(clients))
+	ipState, _ := core.LoadIPLimitState()
+	filter, filterErr := filterFromQuery(r)
+	now := time.Now()
+	// Sekali per request, bukan per client
+	inbounds, _ := core.LoadAllInbounds()
+	onlineUsers := core.OnlineUsers()
+
 	for i := range clients {
 		totalBytes += clients[i].Used
-		if core.IsUserOnline(clients[i].Username) {
+		if onlineUsers[clients[i].Username] {
 			clients[i].IsOnline = true
 			online++
 		}
+		if !filter.Match(clients[i], now) {
+			continue
+		}
+		row := newUserRow(clients[i], domain, inbounds)
+		row.IPs = ipState.IPCount(clients[i].Username)
+		rows = append(rows, row)
 	}
 
 	data := DashboardData{
-		Users:           clients,
-		CPU:             0.0, 
-		RAM:             0.0, 
+		Users:           rows,
+		CPU:             0.0,
+		RAM:             0.0,
 		TotalUsage:      core.FormatBytes(totalBytes),
 		OnlineCount:     online,
 		XrayStatus:      core.IsServiceRunning("xray"),
 		InstallDuration: "Unknown",
+		Total:           len(clients),
+		Filter:          filter,
+		FilterQuery:     filter.Text,
+		Tags:            core.TagList(clients),
+		Statuses:        core.ValidStatuses,
+	}
+	if filterErr != nil {
+		data.FilterError = filterErr.Error()
+	}
+	data.XrayError = r.URL.Query().Get("xray_error")
+	// Satu input usage; batas bawah dan atas sekaligus hanya lewat search box
+	switch {
+	case filter.UsageMin > 0 && filter.UsageMax > 0:
+		data.FilterUsage = strconv.Itoa(filter.UsageMin)
+		data.FilterQuery = strings.TrimSpace(fmt.Sprintf("%s usage:<%d", filter.Text, filter.UsageMax))
+	case filter.UsageMin > 0:
+		data.FilterUsage = strconv.Itoa(filter.UsageMin)
+	case filter.UsageMax > 0:
+		data.FilterUsage = fmt.Sprintf("<%d", filter.UsageMax)
+	case filter.NeverUsed:
+		data.FilterUsage = "0"
 	}
+	data.Inbounds, _ = core.InboundTagList()
+	data.Backup, _ = core.LoadBackupStatus()
+	if panel, err := core.LoadPanelSettings(); err == nil {
+		data.AutoBackup = panel.AutoBackup
+		data.IPLimitPolicy = panel.IPLimitPolicy
+	}
+	data.IPViolations = ipState.RecentViolations(time.Now().Add(-24*time.Hour), 10)
 
 	Render(w, "dashboard.html", data)
 }
 
+// filterFromQuery reads the dashboard search box (?q=, full search syntax)
+// and the filter selects (?tag=, ?status=, ...); the selects win
+func filterFromQuery(r *http.Request) (core.ClientFilter, error) {
+	f, err := core.ParseClientFilter(r.URL.Query().Get("q"))
+	if err != nil {
+		return core.ClientFilter{}, err
+	}
+	for _, key := range core.FilterKeys {
+		if err := f.Set(key, r.URL.Query().Get(key)); err != nil {
+			return core.ClientFilter{}, err
+		}
+	}
+	return f, nil
+}
+
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	Render(w, "login.html", nil)
 }
@@ -80,70 +195,360 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func AddUserFormHandler(w http.ResponseWriter, r *http.Request) {
-	inbound, _, _ := core.GetActiveInbound() // Ignore port and error for display only logic if needed, or pass port too
-	Render(w, "form.html", map[string]interface{}{
-		"action":  "Add",
-		"inbound": inbound,
+	renderUserForm(w, map[string]interface{}{
+		"action": "Add",
 	})
 }
 
+// renderUserForm fills in the inbound list shared by the add and edit form
+func renderUserForm(w http.ResponseWriter, data map[string]interface{}) {
+	tags, _ := core.InboundTagList()
+	data["inbounds"] = tags
+	attached := make(map[string]bool)
+	if c, ok := data["user"].(core.Client); ok {
+		for _, t := range c.InboundTags() {
+			attached[t] = true
+		}
+	} else if len(tags) > 0 {
+		attached[tags[0]] = true
+	}
+	data["attached"] = attached
+	data["plans"], _ = core.LoadPlans()
+	if _, ok := data["reset_kind"]; !ok {
+		c, _ := data["user"].(core.Client)
+		data["reset_kind"] = c.ResetKind()
+		data["reset_day"] = c.ResetDay()
+		if c.PrevUsed > 0 {
+			data["prev_used"] = core.FormatBytes(c.PrevUsed)
+		}
+	}
+	Render(w, "form.html", data)
+}
+
+// resetPeriodFromForm combines the reset_period select and the reset_day input
+func resetPeriodFromForm(r *http.Request) (string, error) {
+	period := r.FormValue("reset_period")
+	if period == "day" {
+		day, err := strconv.Atoi(r.FormValue("reset_day"))
+		if err != nil {
+			return "", fmt.Errorf("invalid reset day")
+		}
+		period = core.ResetDayPeriod(day)
+	}
+	return core.ParseResetPeriod(period)
+}
+
 func AddUserPostHandler(w http.ResponseWriter, r *http.Request) {
-	inb, _, err := core.GetActiveInbound()
-	if err != nil || inb == "" {
+	tags, err := core.InboundTagList()
+	if err != nil || len(tags) == 0 {
 		http.Error(w, "CRITICAL ERROR: No Active Inbound created yet! Please create Inbound via CLI Menu first.", http.StatusPreconditionRequired)
 		return
 	}
+	r.ParseForm()
+	selected := r.Form["inbounds"]
 
-	username := r.FormValue("username")
+	username := strings.TrimSpace(r.FormValue("username"))
 	quotaStr := r.FormValue("quota")
 	daysStr := r.FormValue("days")
 
 	quota, _ := strconv.ParseFloat(quotaStr, 64)
 	days, _ := strconv.Atoi(daysStr)
 
+	uuid := core.GenerateUUID()
+	if r.FormValue("uuid_mode") == "manual" {
+		uuid = core.NormalizeUUID(r.FormValue("custom_uuid"))
+	}
+
 	newClient := core.Client{
 		Username: username,
 		Quota:    quota,
 		Used:     0,
 		Expiry:   time.Now().Add(time.Duration(days) * 24 * time.Hour),
-		Protocol: inb,
-		UUID:     core.GenerateUUID(),
+		UUID:     uuid,
+	}
+	newClient.SetInbounds(selected)
+	if plan := r.FormValue("plan"); plan != "" {
+		// Paket menentukan quota, masa aktif, reset, batas device dan inbound
+		newClient, err = core.NewClientFromPlan(username, uuid, plan)
+	} else if newClient.ResetPeriod, err = resetPeriodFromForm(r); err == nil {
+		if newClient.DeviceLimit, err = core.ParseDeviceLimit(r.FormValue("device_limit")); err == nil {
+			err = core.CheckInboundTags(newClient.InboundTags())
+		}
 	}
 
-	if err := core.SaveClient(newClient); err != nil {
-		http.Error(w, "Failed to save: "+err.Error(), http.StatusInternalServerError)
+	if err == nil {
+		newClient.Tags, err = core.ParseTags(r.FormValue("tags"))
+	}
+	if err == nil {
+		newClient.Notes, err = core.ParseNotes(r.FormValue("notes"))
+	}
+
+	// Validasi sama dengan bot dan CLI; tampilkan lagi form dengan pesan error
+	if err == nil {
+		err = core.SaveClient(webActor(), newClient)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderUserForm(w, map[string]interface{}{
+			"action": "Add",
+			"error":  err.Error(),
+			"tags":   r.FormValue("tags"),
+			"notes":  r.FormValue("notes"),
+		})
 		return
 	}
 
-	core.SyncConfig()
-	core.RestartXray()
+	applyAndRedirect(w, r)
+}
+
+// applyAndRedirect puts a change live and goes back to the dashboard. When
+// Xray rejects the new config the dashboard shows why.
+func applyAndRedirect(w http.ResponseWriter, r *http.Request) {
+	target := "/"
+	if err := core.ApplyXrayConfig(); err != nil {
+		target = "/?xray_error=" + url.QueryEscape(err.Error())
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func findClient(username string) (core.Client, bool) {
+	clients, _ := core.LoadClients()
+	for _, c := range clients {
+		if c.Username == username {
+			return c, true
+		}
+	}
+	return core.Client{}, false
+}
 
-	http.Redirect(w, r, "/", http.StatusFound)
+func EditUserFormHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := findClient(r.PathValue("username"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	renderUserForm(w, map[string]interface{}{
+		"action":   "Edit",
+		"user":     c,
+		"statuses": core.ValidStatuses,
+	})
 }
 
-func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+func EditUserPostHandler(w http.ResponseWriter, r *http.Request) {
 	username := r.PathValue("username")
+	orig, ok := findClient(username)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
 
-	core.DeleteClient(username)
-	core.SyncConfig()
-	core.RestartXray()
+	quota, err := strconv.ParseFloat(r.FormValue("quota"), 64)
+	if err != nil {
+		http.Error(w, "Invalid quota", http.StatusBadRequest)
+		return
+	}
+	uuid := core.NormalizeUUID(r.FormValue("uuid"))
+	if uuid != "" && uuid != orig.UUID {
+		if err := core.ValidateUUID(uuid, username); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			renderUserForm(w, map[string]interface{}{
+				"action":   "Edit",
+				"user":     orig,
+				"statuses": core.ValidStatuses,
+				"error":    err.Error(),
+			})
+			return
+		}
+	}
+
+	period, err := resetPeriodFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	deviceLimit, err := core.ParseDeviceLimit(r.FormValue("device_limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tags, err := core.ParseTags(r.FormValue("tags"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	notes, err := core.ParseNotes(r.FormValue("notes"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var newExpiry time.Time
+	addDays := 0
+	if r.FormValue("expiry_mode") == "date" {
+		newExpiry, err = time.ParseInLocation("2006-01-02T15:04", r.FormValue("date_input"), time.Local)
+		if err != nil {
+			http.Error(w, "Invalid date", http.StatusBadRequest)
+			return
+		}
+	} else {
+		addDays, _ = strconv.Atoi(r.FormValue("add_days"))
+	}
+
+	r.ParseForm()
+	inbounds := r.Form["inbounds"]
+	if len(inbounds) > 0 {
+		if err := core.CheckInboundTags(inbounds); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	status := core.ClientStatus(r.FormValue("status"))
+	if status != "" && status != orig.Status {
+		if _, err := core.ParseStatus(string(status)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Satu update, satu catatan audit: tidak ada edit yang setengah tersimpan
+	err = core.UpdateClient(webActor(), username, func(c *core.Client) {
+		c.Quota = quota
+		c.SetResetPeriod(period)
+		c.DeviceLimit = deviceLimit
+		c.Tags = tags
+		c.Notes = notes
+		if uuid != "" {
+			c.UUID = uuid
+		}
+		if !newExpiry.IsZero() {
+			c.Expiry = newExpiry
+			c.IsExpired = time.Now().After(newExpiry)
+		}
+		c.LiftLimitStatus()
+		if addDays > 0 {
+			c.Renew(addDays)
+		}
+		if len(inbounds) > 0 {
+			c.SetInbounds(inbounds)
+		}
+		if status != "" && status != orig.Status {
+			c.SetStatus(status, "set from web panel")
+		}
+	})
+	if err != nil {
+		http.Error(w, "Failed to update: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	applyAndRedirect(w, r)
+}
+
+// DeleteUserHandler moves the client to the trash (POST only)
+func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	if err := core.DeleteClient(webActor(), username); err != nil {
+		http.Error(w, "Failed to delete: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	applyAndRedirect(w, r)
 }
 
 func SettingsHandler(w http.ResponseWriter, r *http.Request) {
 	creds := core.GetAdminCreds()
-	msg := ""
+	msg, errMsg := "", ""
 	if r.Method == "POST" {
 		user := r.FormValue("username")
 		pass := r.FormValue("password")
-		core.SaveAdminCreds(user, pass)
-		msg = "Saved!"
-		creds = core.AdminCreds{Username: user, Password: pass}
+		if err := core.SaveAdminCreds(webActor(), user, pass); err != nil {
+			errMsg = err.Error()
+		} else {
+			msg = "Saved!"
+			creds = core.AdminCreds{Username: user, Password: pass}
+		}
 	}
+	renderSettings(w, creds, msg, errMsg)
+}
 
+func renderSettings(w http.ResponseWriter, creds core.AdminCreds, msg, errMsg string) {
+	panel, err := core.LoadPanelSettings()
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+	}
+	s3, err := core.LoadS3Config()
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+	}
+	status, _ := core.LoadBackupStatus()
+	userSections := make(map[string]bool)
+	for _, sec := range panel.UserSections {
+		userSections[sec] = true
+	}
+	overlays, overlayErr := core.LoadOverlays()
+	if overlayErr == nil {
+		// Tampilkan juga error merge supaya admin tahu sebelum user berikutnya ditambah
+		_, overlayErr = core.PreviewConfig()
+	}
+	overlayError := ""
+	if overlayErr != nil {
+		overlayError = overlayErr.Error()
+	}
 	Render(w, "settings.html", map[string]interface{}{
-		"creds":   creds,
-		"success": msg,
+		"creds":          creds,
+		"panel":          panel,
+		"s3":             s3,
+		"backupStatus":   status,
+		"sectionChoices": core.UserSectionChoices,
+		"userSections":   userSections,
+		"overlays":       overlays,
+		"overlayDir":     core.CONFIG_OVERLAY_DIR,
+		"overlayError":   overlayError,
+		"success":        msg,
+		"error":          errMsg,
 	})
 }
+
+// PanelSettingsHandler saves the panel wide options. Every settings card
+// posts its own fields; fields missing from the form keep their value.
+func PanelSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	s, _ := core.LoadPanelSettings()
+	var err error
+	for name, dst := range map[string]*int{
+		"trash_retention_days": &s.TrashRetentionDays,
+		"usage_hourly_days":    &s.UsageHourlyDays,
+		"usage_daily_days":     &s.UsageDailyDays,
+		"ip_limit_window":      &s.IPLimitWindow,
+		"ip_limit_ban_minutes": &s.IPLimitBanMinutes,
+	} {
+		v := r.FormValue(name)
+		if v == "" {
+			continue
+		}
+		if *dst, err = strconv.Atoi(v); err != nil {
+			err = fmt.Errorf("%s: not a number", name)
+			break
+		}
+	}
+	if v := r.FormValue("ip_limit_policy"); v != "" {
+		s.IPLimitPolicy = v
+	}
+	// Checkbox kosong tidak terkirim, jadi kartu Xray Config memakai penanda
+	xrayCard := r.FormValue("user_sections_form") != ""
+	if xrayCard {
+		s.UserSections = r.Form["user_sections"]
+	}
+	if err == nil {
+		err = core.SavePanelSettings(webActor(), s)
+	}
+	if err != nil {
+		renderSettings(w, core.GetAdminCreds(), "", "Invalid settings: "+err.Error())
+		return
+	}
+	if xrayCard {
+		if err := core.ApplyXrayConfig(); err != nil {
+			renderSettings(w, core.GetAdminCreds(), "", "Settings saved, but "+err.Error())
+			return
+		}
+	}
+	renderSettings(w, core.GetAdminCreds(), "Panel settings saved.", "")
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+func renderPlans(w http.ResponseWriter, success, errMsg string) {
+	plans, err := core.LoadPlans()
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+	}
+	tags, _ := core.InboundTagList()
+	Render(w, "plans.html", map[string]interface{}{
+		"plans":    plans,
+		"inbounds": tags,
+		"success":  success,
+		"error":    errMsg,
+	})
+}
+
+func PlansHandler(w http.ResponseWriter, r *http.Request) {
+	renderPlans(w, "", "")
+}
+
+func SavePlanHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	quota, _ := strconv.ParseFloat(r.FormValue("quota"), 64)
+	days, _ := strconv.Atoi(r.FormValue("days"))
+	limit, _ := strconv.Atoi(r.FormValue("device_limit"))
+	price, _ := strconv.ParseFloat(r.FormValue("price"), 64)
+
+	period, err := resetPeriodFromForm(r)
+	if err != nil {
+		renderPlans(w, "", err.Error())
+		return
+	}
+
+	p := core.Plan{
+		Name:        r.FormValue("name"),
+		Quota:       quota,
+		Days:        days,
+		Inbounds:    r.Form["inbounds"],
+		DeviceLimit: limit,
+		ResetPeriod: period,
+		Price:       price,
+	}
+	if err := core.SavePlan(webActor(), p); err != nil {
+		renderPlans(w, "", err.Error())
+		return
+	}
+	renderPlans(w, "Plan "+p.Name+" saved.", "")
+}
+
+func DeletePlanHandler(w http.ResponseWriter, r *http.Request) {
+	if err := core.DeletePlan(webActor(), r.PathValue("name")); err != nil {
+		renderPlans(w, "", err.Error())
+		return
+	}
+	http.Redirect(w, r, "/plans", http.StatusFound)
+}
+
+// UserPlanHandler renews or upgrades one client from a plan
+func UserPlanHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	plan := r.FormValue("plan")
+
+	var err error
+	if r.FormValue("mode") == "upgrade" {
+		err = core.UpgradeToPlan(webActor(), username, plan)
+	} else {
+		err = core.RenewFromPlan(webActor(), username, plan)
+	}
+	if err != nil {
+		http.Error(w, "Failed to apply plan: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	applyAndRedirect(w, r)
+}
@@ -5,15 +5,39 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/krisna112/scriptxray/go_panel/pkg/core"
 )
 
 // We might embed templates if we move them, but for now we read from disk
-// or we can use a variable.
-var TemplatesDir = "../web_panel/templates"
-var StaticDir = "../web_panel/static"
+// or we can use a variable. setup_go.sh copies both folders next to the binary;
+// relative paths are looked up there first (see assetDir).
+var TemplatesDir = "templates"
+var StaticDir = "static"
+
+// assetDir resolves a relative folder next to the executable, so the panel
+// does not depend on the working directory it was started from. When the
+// folder is not there (go run) the path is used as is.
+func assetDir(dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return dir
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	p := filepath.Join(filepath.Dir(exe), dir)
+	if st, err := os.Stat(p); err == nil && st.IsDir() {
+		return p
+	}
+	return dir
+}
 
 type Server struct {
 	Port   int
@@ -21,6 +45,8 @@ type Server struct {
 }
 
 func NewServer(port int) *Server {
+	TemplatesDir = assetDir(TemplatesDir)
+	StaticDir = assetDir(StaticDir)
 	return &Server{
 		Port:   port,
 		Router: http.NewServeMux(),
@@ -48,10 +74,43 @@ func (s *Server) routes() {
 	// User Management
 	s.Router.HandleFunc("GET /add", AuthMiddleware(AddUserFormHandler))
 	s.Router.HandleFunc("POST /add", AuthMiddleware(AddUserPostHandler))
-	s.Router.HandleFunc("GET /delete/{username}", AuthMiddleware(DeleteUserHandler))
+	s.Router.HandleFunc("GET /edit/{username}", AuthMiddleware(EditUserFormHandler))
+	s.Router.HandleFunc("POST /edit/{username}", AuthMiddleware(EditUserPostHandler))
+	s.Router.HandleFunc("POST /delete/{username}", AuthMiddleware(DeleteUserHandler))
+
+	// Recycle Bin
+	s.Router.HandleFunc("GET /trash", AuthMiddleware(TrashHandler))
+	s.Router.HandleFunc("POST /trash/restore/{id}", AuthMiddleware(RestoreTrashHandler))
+	s.Router.HandleFunc("POST /trash/purge/{id}", AuthMiddleware(PurgeTrashHandler))
+
+	s.Router.HandleFunc("POST /edit/{username}/plan", AuthMiddleware(UserPlanHandler))
+	s.Router.HandleFunc("GET /usage/{username}", AuthMiddleware(UsageHandler))
+
+	// Import / Export
+	s.Router.HandleFunc("GET /clients/export", AuthMiddleware(ExportClientsHandler))
+	s.Router.HandleFunc("POST /clients/import", AuthMiddleware(ImportClientsHandler))
+	s.Router.HandleFunc("POST /clients/import-panel", AuthMiddleware(ImportPanelHandler))
+
+	// Backup / Restore
+	s.Router.HandleFunc("GET /backup", AuthMiddleware(BackupHandler))
+	s.Router.HandleFunc("POST /restore", AuthMiddleware(RestoreUploadHandler))
+	s.Router.HandleFunc("POST /restore/apply", AuthMiddleware(RestoreApplyHandler))
+	s.Router.HandleFunc("POST /backup/run", AuthMiddleware(RunBackupHandler))
+	s.Router.HandleFunc("POST /settings/backup", AuthMiddleware(BackupSettingsHandler))
+
+	// Plans
+	s.Router.HandleFunc("GET /plans", AuthMiddleware(PlansHandler))
+	s.Router.HandleFunc("POST /plans", AuthMiddleware(SavePlanHandler))
+	s.Router.HandleFunc("POST /plans/delete/{name}", AuthMiddleware(DeletePlanHandler))
+
+	// Audit
+	s.Router.HandleFunc("GET /audit", AuthMiddleware(AuditHandler))
+	s.Router.HandleFunc("GET /sharing", AuthMiddleware(SharingHandler))
 
 	// Settings
 	s.Router.HandleFunc("GET /settings", AuthMiddleware(SettingsHandler))
+	s.Router.HandleFunc("POST /settings", AuthMiddleware(SettingsHandler))
+	s.Router.HandleFunc("POST /settings/panel", AuthMiddleware(PanelSettingsHandler))
 	// Add more routes as needed
 }
 
@@ -67,6 +126,11 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// webActor identifies the panel admin in the audit log (single admin account)
+func webActor() core.Actor {
+	return core.Actor{Name: core.GetAdminCreds().Username, Channel: core.ChannelWeb}
+}
+
 // Simple Session Store (In-Memory for now)
 var validSessions = make(map[string]time.Time)
 
@@ -90,17 +154,20 @@ func CreateSession() string {
 
 // Helper to render templates
 func Render(w http.ResponseWriter, tmplName string, data interface{}) {
-	// Parse all templates effectively allowing layouts if needed
-	// For efficiency in prod, ParseGlob should be done once at startup.
+	// Each page fills the "content" block of base.html, so parse the layout
+	// together with only the requested page.
+	// For efficiency in prod, parsing should be done once at startup.
 	// But for dev/migration, parsing on request is safer.
-	pk := fmt.Sprintf("%s/*.html", TemplatesDir)
-	tmpl, err := template.ParseGlob(pk)
+	tmpl, err := template.ParseFiles(
+		filepath.Join(TemplatesDir, "base.html"),
+		filepath.Join(TemplatesDir, tmplName),
+	)
 	if err != nil {
 		http.Error(w, "Template Error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := tmpl.ExecuteTemplate(w, tmplName, data); err != nil {
+	if err := tmpl.ExecuteTemplate(w, "base.html", data); err != nil {
 		http.Error(w, "Render Error: "+err.Error(), http.StatusInternalServerError)
 	}
 }
@@ -0,0 +1,36 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// Pilihan rentang waktu laporan sharing
+var sharingRanges = []struct {
+	Hours int
+	Label string
+}{{6, "6h"}, {24, "24h"}, {72, "3d"}, {168, "7d"}}
+
+// SharingHandler shows the ranked account sharing report
+func SharingHandler(w http.ResponseWriter, r *http.Request) {
+	hours, err := strconv.Atoi(r.URL.Query().Get("hours"))
+	if err != nil || hours <= 0 {
+		hours = 24
+	}
+	errMsg := ""
+	report, err := core.BuildSharingReport(time.Now().Add(-time.Duration(hours) * time.Hour))
+	if err != nil {
+		errMsg = err.Error()
+	}
+	Render(w, "sharing.html", map[string]interface{}{
+		"report":    report,
+		"hours":     hours,
+		"ranges":    sharingRanges,
+		"countryDB": core.GEOIP_COUNTRY_DB,
+		"asnDB":     core.GEOIP_ASN_DB,
+		"error":     errMsg,
+	})
+}
@@ -0,0 +1,65 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// TrashRow is a trash entry plus the days left before auto purge
+type TrashRow struct {
+	core.TrashEntry
+	UsedFmt string
+	PurgeIn int // days, -1 = never
+}
+
+func renderTrash(w http.ResponseWriter, success, errMsg string) {
+	entries, err := core.LoadTrash()
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+	}
+	settings, _ := core.LoadPanelSettings()
+
+	// Terbaru di atas
+	var rows []TrashRow
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		row := TrashRow{TrashEntry: e, UsedFmt: core.FormatBytes(e.Client.Used), PurgeIn: -1}
+		if d := settings.TrashRetentionDays; d > 0 {
+			left := time.Until(e.DeletedAt.Add(time.Duration(d)*24*time.Hour)).Hours() / 24
+			row.PurgeIn = int(left)
+			if row.PurgeIn < 0 {
+				row.PurgeIn = 0
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	Render(w, "trash.html", map[string]interface{}{
+		"rows":      rows,
+		"retention": settings.TrashRetentionDays,
+		"success":   success,
+		"error":     errMsg,
+	})
+}
+
+func TrashHandler(w http.ResponseWriter, r *http.Request) {
+	renderTrash(w, "", "")
+}
+
+func RestoreTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if err := core.RestoreClient(webActor(), r.PathValue("id")); err != nil {
+		renderTrash(w, "", "Restore failed: "+err.Error())
+		return
+	}
+	applyAndRedirect(w, r)
+}
+
+func PurgeTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if err := core.PurgeTrash(webActor(), r.PathValue("id")); err != nil {
+		renderTrash(w, "", err.Error())
+		return
+	}
+	http.Redirect(w, r, "/trash", http.StatusFound)
+}
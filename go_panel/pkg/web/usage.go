@@ -0,0 +1,99 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/krisna112/scriptxray/go_panel/pkg/core"
+)
+
+// UsageRow is one history bucket formatted for the usage page
+type UsageRow struct {
+	Label    string
+	UpFmt    string
+	DownFmt  string
+	TotalFmt string
+	Percent  int // bar width relative to the busiest bucket
+}
+
+// UsageSummary is the total of a named period ("Today", "Last 7 days"...)
+type UsageSummary struct {
+	Name     string
+	TotalFmt string
+}
+
+func UsageHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := findClient(r.PathValue("username"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	view := core.Hourly
+	from, layout := now.Add(-47*time.Hour), "01-02 15:00"
+	if r.URL.Query().Get("view") == string(core.Daily) {
+		view = core.Daily
+		from, layout = today.AddDate(0, 0, -29), "2006-01-02"
+	}
+
+	errMsg := ""
+	points, err := core.UsageHistory(c.Username, view, from, now)
+	if err != nil {
+		errMsg = err.Error()
+	}
+	days, err := core.UsageHistory(c.Username, core.Daily, today.AddDate(0, 0, -29), now)
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+	}
+
+	// Ringkasan dari bucket harian, indeks terakhir = hari ini
+	sum := func(n int) string {
+		var total int64
+		for i := max(len(days)-n, 0); i < len(days); i++ {
+			total += days[i].Total()
+		}
+		return core.FormatBytes(float64(total))
+	}
+	yesterday := core.FormatBytes(0)
+	if len(days) >= 2 {
+		yesterday = core.FormatBytes(float64(days[len(days)-2].Total()))
+	}
+	summary := []UsageSummary{
+		{"Today", sum(1)},
+		{"Yesterday", yesterday},
+		{"Last 7 days", sum(7)},
+		{"Last 30 days", sum(30)},
+	}
+
+	var peak int64
+	for _, p := range points {
+		if p.Total() > peak {
+			peak = p.Total()
+		}
+	}
+	// Terbaru di atas
+	rows := make([]UsageRow, 0, len(points))
+	for i := len(points) - 1; i >= 0; i-- {
+		p := points[i]
+		row := UsageRow{
+			Label:    p.Time.Format(layout),
+			UpFmt:    core.FormatBytes(float64(p.Up)),
+			DownFmt:  core.FormatBytes(float64(p.Down)),
+			TotalFmt: core.FormatBytes(float64(p.Total())),
+		}
+		if peak > 0 {
+			row.Percent = int(p.Total() * 100 / peak)
+		}
+		rows = append(rows, row)
+	}
+
+	Render(w, "usage.html", map[string]interface{}{
+		"user":    c,
+		"view":    string(view),
+		"rows":    rows,
+		"summary": summary,
+		"error":   errMsg,
+	})
+}
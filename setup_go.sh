@@ -21,7 +21,7 @@ echo -e "${GREEN}📥 Installing Xray Panel (Go Edition)...${NC}"
 echo -e "\n${YELLOW}📦 Installing System Dependencies...${NC}"
 export DEBIAN_FRONTEND=noninteractive
 apt-get update -qq
-apt-get install -y -qq wget curl git jq net-tools zip unzip socat qrencode bc nginx certbot python3-certbot-nginx python3-certbot-dns-cloudflare ufw fail2ban build-essential
+apt-get install -y -qq wget curl git jq net-tools zip unzip socat qrencode bc logrotate nginx certbot python3-certbot-nginx python3-certbot-dns-cloudflare ufw fail2ban build-essential
 
 # 2. CREATE SWAP (PENTING AGAR TIDAK ERROR SAAT BUILD)
 # Cek jika swap kurang dari 1GB, buat swap file
@@ -77,6 +77,14 @@ sed -i 's/User=nobody/User=root/g' /lib/systemd/system/xray.service 2>/dev/null
 systemctl daemon-reload
 
 # 5. GENERATE DEFAULT CONFIG
+# Config Xray yang sudah ada disimpan dulu, di-adopt setelah panel terpasang
+ADOPT_CONFIG=""
+if [ -s /usr/local/etc/xray/config.json ] && grep -q '"inbounds"' /usr/local/etc/xray/config.json; then
+    mkdir -p /etc/xray
+    ADOPT_CONFIG="/etc/xray/config.pre-panel.json"
+    cp /usr/local/etc/xray/config.json "$ADOPT_CONFIG"
+    echo -e "${YELLOW}📦 Existing Xray config saved to $ADOPT_CONFIG${NC}"
+fi
 echo -e "${YELLOW}⚙️  Generating default Xray config...${NC}"
 cat > /usr/local/etc/xray/config.json <<EOF
 {
@@ -109,10 +117,12 @@ cat > /usr/local/etc/xray/config.json <<EOF
 }
 EOF
 
-# Create DB files
-mkdir -p /etc/xray
+# Create DB files (config.d = overlay JSON yang digabung ke config.json)
+mkdir -p /etc/xray /etc/xray/config.d
 touch /etc/xray/clients.db /etc/xray/inbounds.db
-chmod 666 /etc/xray/{clients.db,inbounds.db}
+chmod 666 /etc/xray/clients.db
+# inbounds.db menyimpan private key REALITY, hanya root yang boleh baca
+chmod 600 /etc/xray/inbounds.db
 
 # 6. SSL CERTIFICATE
 if [ -f "/root/.secrets/cloudflare.ini" ]; then
@@ -219,6 +229,83 @@ Persistent=true
 WantedBy=timers.target
 EOF
 
+# Quota Check: traffic, quota, reset periode dan riwayat usage
+cat > /etc/systemd/system/xray-quota.service <<EOF
+[Unit]
+Description=Xray Panel Quota Check
+[Service]
+Type=oneshot
+ExecStart=$APP_DIR/$BIN_NAME -quota
+EOF
+
+cat > /etc/systemd/system/xray-quota.timer <<EOF
+[Unit]
+Description=Run Xray Panel Quota Check Every 5 Minutes
+[Timer]
+OnCalendar=*:0/5
+Persistent=true
+[Install]
+WantedBy=timers.target
+EOF
+
+# Device (IP) Limit Check
+cat > /etc/systemd/system/xray-iplimit.service <<EOF
+[Unit]
+Description=Xray Panel Device Limit Check
+[Service]
+Type=oneshot
+ExecStart=$APP_DIR/$BIN_NAME -ip-limit
+EOF
+
+cat > /etc/systemd/system/xray-iplimit.timer <<EOF
+[Unit]
+Description=Run Xray Panel Device Limit Check Every Minute
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=1min
+[Install]
+WantedBy=timers.target
+EOF
+
+# Scheduled Backup
+cat > /etc/systemd/system/xray-backup.service <<EOF
+[Unit]
+Description=Xray Panel Scheduled Backup
+[Service]
+Type=oneshot
+ExecStart=$APP_DIR/$BIN_NAME -auto-backup
+EOF
+
+cat > /etc/systemd/system/xray-backup.timer <<EOF
+[Unit]
+Description=Run Xray Panel Backup Daily
+[Timer]
+OnCalendar=*-*-* 03:30:00
+Persistent=true
+[Install]
+WantedBy=timers.target
+EOF
+
+# Rotasi log Xray. copytruncate: Xray tidak membuka ulang file log.
+# delaycompress: access.log.1 tetap teks biasa, dibaca laporan sharing.
+cat > /etc/logrotate.d/xray <<EOF
+/var/log/xray/access.log /var/log/xray/error.log {
+    daily
+    rotate 7
+    missingok
+    notifempty
+    compress
+    delaycompress
+    copytruncate
+}
+EOF
+
+# Adopt inbound dan client dari config lama
+if [ -n "$ADOPT_CONFIG" ]; then
+    echo -e "${YELLOW}📥 Adopting inbounds and clients from $ADOPT_CONFIG...${NC}"
+    "$APP_DIR/$BIN_NAME" -adopt "$ADOPT_CONFIG" || echo -e "${RED}⚠️  Adopt incomplete, check the report above.${NC}"
+fi
+
 # 9. CLI SHORTCUT
 echo "#!/bin/bash" > /usr/bin/menu
 echo "$APP_DIR/$BIN_NAME -menu" >> /usr/bin/menu
@@ -228,6 +315,9 @@ chmod +x /usr/bin/menu
 systemctl daemon-reload
 systemctl enable --now xray-panel
 systemctl enable --now xray-xp.timer
+systemctl enable --now xray-quota.timer
+systemctl enable --now xray-backup.timer
+systemctl enable --now xray-iplimit.timer
 
 echo -e "\n${GREEN}=========================================${NC}"
 echo -e "${GREEN}      ✅ INSTALLATION COMPLETE!          ${NC}"